@@ -0,0 +1,85 @@
+// Package alignio reads alignments of two-state characters, and writes them in
+// the NEXUS layout the rest of the tool reads.
+package alignio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/goalign/io/fasta"
+	"github.com/evolbioinfo/goalign/io/nexus"
+	"github.com/evolbioinfo/goalign/io/phylip"
+)
+
+// Formats ReadFormat reads.
+var Formats = []string{"nexus", "fasta", "phylip"}
+
+// Reads a NEXUS alignment.
+func Read(file string) (align.Alignment, error) {
+	return ReadFormat(file, "nexus")
+}
+
+// Reads an alignment in one of Formats, or in the format its extension
+// tells if format is empty.
+func ReadFormat(file, format string) (align.Alignment, error) {
+	if format == "" {
+		var err error
+		if format, err = FormatOf(file); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var aln align.Alignment
+	switch format {
+	case "fasta":
+		aln, err = fasta.NewParser(f).Parse()
+	case "phylip":
+		aln, err = phylip.NewParser(f, false).Parse()
+	case "nexus":
+		aln, err = nexus.NewParser(f).Parse()
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected one of %s)", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s as %s: %w", file, format, err)
+	}
+	return aln, nil
+}
+
+// Returns the format of an alignment file from its extension.
+func FormatOf(file string) (string, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".fa", ".fas", ".fasta", ".fna":
+		return "fasta", nil
+	case ".phy", ".phylip":
+		return "phylip", nil
+	case ".nex", ".nexus", ".nxs":
+		return "nexus", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s from its extension", file)
+}
+
+// Writes an alignment as NEXUS taxa and characters blocks, the layout the
+// NEXUS parser reads two-state characters from.
+func WriteNexus(aln align.Alignment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#NEXUS\n\nBEGIN Taxa;\nDIMENSIONS ntax=%d;\nTAXLABELS\n", aln.NbSequences())
+	aln.IterateChar(func(name string, _ []uint8) bool {
+		fmt.Fprintln(&b, name)
+		return false
+	})
+	fmt.Fprintf(&b, ";\nEND;\n\nBEGIN Characters;\nDIMENSIONS NChar=%d;\nFORMAT GAP = - MISSING = ?;\nMATRIX\n", aln.Length())
+	aln.IterateChar(func(name string, seq []uint8) bool {
+		fmt.Fprintf(&b, "%s\t\t%s\n", name, seq)
+		return false
+	})
+	b.WriteString(";\nEND;\n")
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/benchmark"
+	"lv1-netest/simulate"
+	"lv1-netest/subproblem"
+)
+
+func newBenchmarkCommand() *cobra.Command {
+	var b backendFlags
+	settings := benchmark.Settings{}
+	cmd := &cobra.Command{
+		Use:   "benchmark -d DIR",
+		Short: "Measure accuracy and running time on simulated networks over a grid of settings",
+		Long: `Simulates replicates for every combination of the comma-separated values of
+--taxa, --cycles, --sites, --rate and --model, like simulate, estimates their
+networks with each search effort of --effort (the PAUP* preset, or the
+maxtrees and nreps of the built-in search) and compares them with the true
+networks, like compare. The efforts of a simulation setting run on the same
+data. Writes to the output directory:
+
+  replicates.tsv   one row per run: settings, seed, running time, normalized
+                   distances, hybrid precision and recall, and error
+  summary.tsv      per combination: runs, failures, and the mean and standard
+                   deviation of the running time and of each measure
+  <measure>.png    a box plot per combination; seconds.png for the time
+  networks/        alignment, true and estimated networks of every run
+
+A run that fails is recorded and the benchmark goes on.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			if _, err := subproblem.ParseCriterion(settings.Criterion); err != nil {
+				return usageError{err}
+			}
+			var err error
+			if settings.Backend, err = b.backend(); err != nil {
+				return err
+			}
+			if settings.Simulation.Seed == 0 {
+				settings.Simulation.Seed = time.Now().UnixNano()
+			}
+			settings.Log = os.Stdout
+			runs, err := benchmark.Benchmark(cmd.Context(), settings)
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, run := range runs {
+				if run.Err != nil {
+					failed++
+				}
+			}
+			fmt.Printf("%d runs, %d failed; summary written to %s (seed %d)\n", len(runs), failed, filepath.Join(settings.Dir, "summary.tsv"), settings.Simulation.Seed)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&settings.Dir, "dir", "d", "", "output directory")
+	cmd.Flags().IntVarP(&settings.Replicates, "replicates", "n", 10, "replicates per simulation setting")
+	cmd.Flags().IntSliceVar(&settings.Grid.Taxa, "taxa", []int{12}, "numbers of taxa")
+	cmd.Flags().IntSliceVar(&settings.Grid.Cycles, "cycles", []int{1}, "numbers of reticulation cycles")
+	cmd.Flags().IntSliceVar(&settings.Grid.Sites, "sites", []int{500}, "numbers of characters")
+	cmd.Flags().StringSliceVar(&settings.Grid.Models, "model", []string{"cf"}, modelUsage)
+	cmd.Flags().Float64SliceVar(&settings.Grid.Rates, "rate", []float64{1}, rateUsage)
+	cmd.Flags().StringSliceVar(&settings.Grid.Efforts, "effort", []string{"default"}, "search efforts: "+strings.Join(subproblem.Presets, ", "))
+	addSimulationFlags(cmd, &settings.Simulation)
+	cmd.Flags().Int64Var(&settings.Simulation.Seed, "seed", 0, "random seed of the first replicate (0 picks one)")
+	cmd.Flags().StringVarP(&settings.Criterion, "criterion", "c", "unord", "optimality criterion for polytomy subproblems: "+strings.Join(subproblem.CriterionNames(), ", "))
+	b.add(cmd, "builtin", true)
+	cmd.Flags().IntVarP(&settings.Parallel, "jobs", "j", runtime.NumCPU(), "subproblems solved at once")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagDirname("dir")
+	cmd.RegisterFlagCompletionFunc("model", completeValues(simulate.Models...))
+	cmd.RegisterFlagCompletionFunc("effort", completeValues(subproblem.Presets...))
+	cmd.RegisterFlagCompletionFunc("criterion", completeValues(subproblem.CriterionNames()...))
+	return cmd
+}
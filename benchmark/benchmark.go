@@ -0,0 +1,353 @@
+// Package benchmark measures the accuracy and running time of the estimator
+// on simulated replicates, over a grid of simulation and search settings.
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"lv1-netest/alignio"
+	"lv1-netest/errs"
+	"lv1-netest/netest"
+	"lv1-netest/network"
+	"lv1-netest/simulate"
+	"lv1-netest/subproblem"
+)
+
+// Values of the settings that vary between runs; every combination is run.
+type Grid struct {
+	Taxa    []int
+	Cycles  []int
+	Sites   []int
+	Rates   []float64
+	Models  []string
+	Efforts []string // search presets, see subproblem.Presets
+}
+
+// One combination of the grid.
+type Point struct {
+	Taxa   int
+	Cycles int
+	Sites  int
+	Rate   float64
+	Model  string
+	Effort string
+}
+
+// Returns the combinations of the grid, the effort varying fastest so that
+// the efforts of a simulation setting follow each other.
+func (g Grid) Points() []Point {
+	points := make([]Point, 0)
+	for _, taxa := range g.Taxa {
+		for _, cycles := range g.Cycles {
+			for _, sites := range g.Sites {
+				for _, rate := range g.Rates {
+					for _, model := range g.Models {
+						for _, effort := range g.Efforts {
+							points = append(points, Point{taxa, cycles, sites, rate, model, effort})
+						}
+					}
+				}
+			}
+		}
+	}
+	return points
+}
+
+func (g Grid) check() error {
+	switch {
+	case len(g.Taxa) == 0 || len(g.Cycles) == 0 || len(g.Sites) == 0 || len(g.Rates) == 0 || len(g.Models) == 0 || len(g.Efforts) == 0:
+		return fmt.Errorf("every dimension of the grid needs at least one value")
+	}
+	for _, effort := range g.Efforts {
+		if !slices.Contains(subproblem.Presets, effort) {
+			return fmt.Errorf("unknown effort %q (expected one of %s)", effort, strings.Join(subproblem.Presets, ", "))
+		}
+	}
+	return nil
+}
+
+// Names the values of the dimensions that vary in the grid, e.g.
+// "taxa=12 effort=fast"; "all" if none does.
+func (g Grid) label(p Point) string {
+	fields := make([]string, 0, 6)
+	if len(g.Taxa) > 1 {
+		fields = append(fields, fmt.Sprintf("taxa=%d", p.Taxa))
+	}
+	if len(g.Cycles) > 1 {
+		fields = append(fields, fmt.Sprintf("cycles=%d", p.Cycles))
+	}
+	if len(g.Sites) > 1 {
+		fields = append(fields, fmt.Sprintf("sites=%d", p.Sites))
+	}
+	if len(g.Rates) > 1 {
+		fields = append(fields, fmt.Sprintf("rate=%g", p.Rate))
+	}
+	if len(g.Models) > 1 {
+		fields = append(fields, "model="+p.Model)
+	}
+	if len(g.Efforts) > 1 {
+		fields = append(fields, "effort="+p.Effort)
+	}
+	if len(fields) == 0 {
+		return "all"
+	}
+	return strings.Join(fields, " ")
+}
+
+// Settings of a benchmark.
+type Settings struct {
+	Dir        string                   // output directory of the tables, plots and networks
+	Grid       Grid                     // settings varying between runs
+	Simulation simulate.Settings        // the other simulation settings; its seed is the one of the first replicate
+	Replicates int                      // simulated data sets per simulation setting
+	Criterion  string                   // optimality criterion; unord if empty
+	Backend    subproblem.SearchBackend // the built-in search if nil; it runs the preset of each effort
+	Parallel   int                      // subproblems solved at once; the number of CPUs if 0
+	Log        io.Writer                // one line per run; discarded if nil
+}
+
+// The outcome of one run of the estimator.
+type Run struct {
+	Point
+	Replicate  int                 // from 1
+	Seed       int64               // seed of the simulation and of the searches
+	Seconds    float64             // running time of the estimator
+	Comparison *network.Comparison // estimated against true network, nil if the run failed
+	Err        error
+}
+
+// Simulates the replicates of every point of the grid, estimates their
+// networks and compares them with the true ones. The data of a simulation
+// setting are shared by its efforts, and replicate k of simulation setting i
+// (both from 1) uses the seed of the settings plus (i-1)*replicates+k-1. Failed runs
+// are recorded and do not stop the benchmark. Writes replicates.tsv,
+// summary.tsv, a box plot per measure, and the alignment and networks of
+// every run to settings.Dir.
+func Benchmark(ctx context.Context, settings Settings) ([]Run, error) {
+	if err := settings.Grid.check(); err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	if settings.Replicates < 1 {
+		return nil, errs.New(errs.ErrInvalidOption, "", fmt.Errorf("%d replicates, expected at least 1", settings.Replicates))
+	}
+	log := settings.Log
+	if log == nil {
+		log = io.Discard
+	}
+	if err := os.MkdirAll(filepath.Join(settings.Dir, "networks"), 0755); err != nil {
+		return nil, err
+	}
+	points := settings.Grid.Points()
+	runs := make([]Run, 0, len(points)*settings.Replicates)
+	efforts := len(settings.Grid.Efforts)
+	for i := 0; i < len(points); i += efforts {
+		for k := range settings.Replicates {
+			s := settings.Simulation
+			s.Taxa, s.Cycles, s.Sites, s.Rate, s.Model = points[i].Taxa, points[i].Cycles, points[i].Sites, points[i].Rate, points[i].Model
+			s.Seed += int64(i/efforts*settings.Replicates + k)
+			replicate, err := simulate.Simulate(s)
+			if err != nil {
+				return runs, err
+			}
+			stem := filepath.Join(settings.Dir, "networks", fmt.Sprintf("setting_%d_replicate_%d", i/efforts+1, k+1))
+			if err := os.WriteFile(stem+".nex", []byte(alignio.WriteNexus(replicate.Alignment)), 0644); err != nil {
+				return runs, err
+			}
+			if err := os.WriteFile(stem+"_true.nwk", []byte(fmt.Sprintf("[simulated %s]\n%s\n", s, replicate.Network)), 0644); err != nil {
+				return runs, err
+			}
+			truth, err := network.ParseNetworks(replicate.Network)
+			if err != nil {
+				return runs, errs.New(errs.ErrInternal, "", fmt.Errorf("simulated network: %w", err))
+			}
+			for _, point := range points[i : i+efforts] {
+				if err := ctx.Err(); err != nil {
+					return runs, err
+				}
+				run := Run{Point: point, Replicate: k + 1, Seed: s.Seed}
+				newick := ""
+				run.Seconds, newick, run.Err = settings.estimate(ctx, replicate, point.Effort, s.Seed)
+				if run.Err == nil {
+					run.Err = os.WriteFile(fmt.Sprintf("%s_%s.nwk", stem, point.Effort), []byte(newick+"\n"), 0644)
+				}
+				if run.Err == nil {
+					var estimated []*network.Network
+					if estimated, run.Err = network.ParseNetworks(newick); run.Err == nil {
+						run.Comparison, run.Err = network.Compare(estimated[0], truth[0])
+					}
+				}
+				if run.Err != nil {
+					fmt.Fprintf(log, "%s replicate %d: failed after %.1fs: %v\n", settings.Grid.label(point), k+1, run.Seconds, run.Err)
+				} else {
+					fmt.Fprintf(log, "%s replicate %d: %.1fs, hardwired cluster distance %d\n", settings.Grid.label(point), k+1, run.Seconds, run.Comparison.HardwiredClusters.Distance())
+				}
+				runs = append(runs, run)
+			}
+		}
+	}
+	if err := writeReplicates(filepath.Join(settings.Dir, "replicates.tsv"), runs); err != nil {
+		return runs, err
+	}
+	if err := writeSummary(filepath.Join(settings.Dir, "summary.tsv"), settings.Grid, runs); err != nil {
+		return runs, err
+	}
+	return runs, writePlots(settings.Dir, settings.Grid, runs)
+}
+
+// Runs the estimator on a replicate with the search preset of an effort,
+// returning its running time and network.
+func (s *Settings) estimate(ctx context.Context, replicate *simulate.Replicate, effort string, seed int64) (float64, string, error) {
+	backend := s.Backend
+	if backend == nil {
+		backend = subproblem.BuiltinBackend{}
+	}
+	if builtin, ok := backend.(subproblem.BuiltinBackend); ok {
+		builtin.Preset = effort
+		backend = builtin
+	}
+	start := time.Now()
+	result, err := netest.Estimate(ctx, replicate.Alignment, netest.Options{
+		Criterion: s.Criterion,
+		Template:  effort,
+		Seed:      seed,
+		Backend:   backend,
+		Parallel:  s.Parallel,
+	})
+	seconds := time.Since(start).Seconds()
+	if err != nil {
+		return seconds, "", err
+	}
+	return seconds, result.Newick(), nil
+}
+
+// A measure of accuracy, taken from the comparison of a successful run.
+type measure struct {
+	name  string
+	value func(c *network.Comparison) float64
+}
+
+var measures = []measure{
+	{"hardwired_clusters", func(c *network.Comparison) float64 { return c.HardwiredClusters.Normalized() }},
+	{"softwired_clusters", func(c *network.Comparison) float64 { return c.SoftwiredClusters.Normalized() }},
+	{"tripartitions", func(c *network.Comparison) float64 { return c.Tripartitions.Normalized() }},
+	{"triplets", func(c *network.Comparison) float64 { return c.Triplets.Normalized() }},
+	{"displayed_trees", func(c *network.Comparison) float64 { return c.DisplayedTrees.Normalized() }},
+	{"displayed_tree_rf", func(c *network.Comparison) float64 { return c.DisplayedTreeRF }},
+	{"hybrid_precision", func(c *network.Comparison) float64 { return c.HybridTaxa.Precision() }},
+	{"hybrid_recall", func(c *network.Comparison) float64 { return c.HybridTaxa.Recall() }},
+}
+
+func (p Point) fields() string {
+	return fmt.Sprintf("%d\t%d\t%d\t%g\t%s\t%s", p.Taxa, p.Cycles, p.Sites, p.Rate, p.Model, p.Effort)
+}
+
+const pointHeader = "taxa\tcycles\tsites\trate\tmodel\teffort"
+
+// Writes one row per run, with its normalized distances, precision and
+// recall; empty for failed runs.
+func writeReplicates(file string, runs []Run) error {
+	var b strings.Builder
+	b.WriteString(pointHeader + "\treplicate\tseed\tseconds")
+	for _, m := range measures {
+		b.WriteString("\t" + m.name)
+	}
+	b.WriteString("\terror\n")
+	for _, run := range runs {
+		fmt.Fprintf(&b, "%s\t%d\t%d\t%.3f", run.Point.fields(), run.Replicate, run.Seed, run.Seconds)
+		for _, m := range measures {
+			if run.Comparison == nil {
+				b.WriteString("\t")
+			} else {
+				fmt.Fprintf(&b, "\t%.4f", m.value(run.Comparison))
+			}
+		}
+		if run.Err != nil {
+			b.WriteString("\t" + strings.Join(strings.Fields(run.Err.Error()), " "))
+		} else {
+			b.WriteString("\t")
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
+
+// Returns the runs of each point of the grid, in grid order.
+func byPoint(grid Grid, runs []Run) ([]Point, [][]Run) {
+	points := grid.Points()
+	grouped := make([][]Run, len(points))
+	for _, run := range runs {
+		grouped[slices.Index(points, run.Point)] = append(grouped[slices.Index(points, run.Point)], run)
+	}
+	return points, grouped
+}
+
+// Returns the values of a measure over the successful runs, skipping
+// undefined ones (NaN); the running time if m is nil.
+func values(runs []Run, m *measure) []float64 {
+	values := make([]float64, 0, len(runs))
+	for _, run := range runs {
+		switch {
+		case m == nil:
+			values = append(values, run.Seconds)
+		case run.Comparison != nil:
+			if v := m.value(run.Comparison); !math.IsNaN(v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func meanSD(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	mean, sd := 0.0, 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if len(values) > 1 {
+		for _, v := range values {
+			sd += (v - mean) * (v - mean)
+		}
+		sd = math.Sqrt(sd / float64(len(values)-1))
+	}
+	return mean, sd
+}
+
+// Writes one row per point of the grid, with its numbers of runs and
+// failures and the mean and standard deviation of each measure.
+func writeSummary(file string, grid Grid, runs []Run) error {
+	var b strings.Builder
+	b.WriteString(pointHeader + "\truns\tfailed\tseconds_mean\tseconds_sd")
+	for _, m := range measures {
+		fmt.Fprintf(&b, "\t%s_mean\t%s_sd", m.name, m.name)
+	}
+	b.WriteString("\n")
+	points, grouped := byPoint(grid, runs)
+	for i, point := range points {
+		failed := 0
+		for _, run := range grouped[i] {
+			if run.Err != nil {
+				failed++
+			}
+		}
+		mean, sd := meanSD(values(grouped[i], nil))
+		fmt.Fprintf(&b, "%s\t%d\t%d\t%.3f\t%.3f", point.fields(), len(grouped[i]), failed, mean, sd)
+		for _, m := range measures {
+			mean, sd := meanSD(values(grouped[i], &m))
+			fmt.Fprintf(&b, "\t%.4f\t%.4f", mean, sd)
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
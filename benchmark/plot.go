@@ -0,0 +1,52 @@
+package benchmark
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// Writes a box plot of the running time, seconds.png, and one of each
+// measure, <measure>.png, with a box per point of the grid.
+func writePlots(dir string, grid Grid, runs []Run) error {
+	if err := writePlot(filepath.Join(dir, "seconds.png"), "running time (s)", grid, runs, nil); err != nil {
+		return err
+	}
+	for _, m := range measures {
+		if err := writePlot(filepath.Join(dir, m.name+".png"), strings.ReplaceAll(m.name, "_", " "), grid, runs, &m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePlot(file, title string, grid Grid, runs []Run, m *measure) error {
+	p := plot.New()
+	p.Title.Text = title
+	points, grouped := byPoint(grid, runs)
+	labels := make([]string, len(points))
+	for i, point := range points {
+		labels[i] = grid.label(point)
+		values := values(grouped[i], m)
+		if len(values) == 0 {
+			continue // every run failed, or the measure is undefined
+		}
+		box, err := plotter.NewBoxPlot(vg.Points(20), float64(i), plotter.Values(values))
+		if err != nil {
+			return err
+		}
+		p.Add(box)
+	}
+	p.NominalX(labels...)
+	p.X.Tick.Label.Rotation = math.Pi / 4
+	p.X.Tick.Label.XAlign = draw.XRight
+	p.X.Tick.Label.YAlign = text.YCenter
+	width := max(4*vg.Inch, vg.Length(len(points))*vg.Points(36))
+	return p.Save(width, 4*vg.Inch, file)
+}
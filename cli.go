@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"lv1-netest/alignio"
+	"lv1-netest/errs"
+	"lv1-netest/netest"
+	"lv1-netest/subproblem"
+)
+
+// Exit codes of the command line tool.
+const (
+	exitOK            = 0
+	exitFailure       = 1 // the command ran but failed, e.g. on an I/O error
+	exitUsage         = 2 // invalid flags, arguments or settings
+	exitInvalidInput  = 3 // ErrInvalidInput or ErrUnknownTaxon
+	exitMissingOutput = 4 // ErrMissingOutput
+	exitInvalidOutput = 5 // ErrInvalidOutput or ErrNonBinaryTree
+	exitIncompatible  = 6 // ErrIncompatibleSplits
+	exitInternal      = 7 // ErrInternal, or a panic
+)
+
+// An error in how the tool was invoked.
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+// An error returned by a command after its flags were accepted.
+type runError struct{ error }
+
+func (e runError) Unwrap() error { return e.error }
+
+// Settings not given as flags are read from environment variables named
+// envPrefix followed by the flag name, e.g. LV1_NETEST_PAUP_ARGS for --paup-args.
+const envPrefix = "LV1_NETEST_"
+
+func execute(arguments []string) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\nhint: %s\n", errs.ErrInternal, r, errorHint(errs.ErrInternal))
+			code = exitInternal
+		}
+	}()
+	root := newRootCommand()
+	root.SetArgs(arguments)
+	err := root.Execute()
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+	if hint := errorHint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, "hint:", hint)
+	}
+	return exitCode(err)
+}
+
+func exitCode(err error) int {
+	var failed runError
+	var usage usageError
+	switch {
+	case errors.As(err, &usage) || !errors.As(err, &failed), errors.Is(err, errs.ErrInvalidOption):
+		return exitUsage
+	case errors.Is(err, errs.ErrInvalidInput), errors.Is(err, errs.ErrUnknownTaxon):
+		return exitInvalidInput
+	case errors.Is(err, errs.ErrMissingOutput):
+		return exitMissingOutput
+	case errors.Is(err, errs.ErrInvalidOutput), errors.Is(err, errs.ErrNonBinaryTree):
+		return exitInvalidOutput
+	case errors.Is(err, errs.ErrIncompatibleSplits):
+		return exitIncompatible
+	case errors.Is(err, errs.ErrInternal):
+		return exitInternal
+	}
+	return exitFailure
+}
+
+// Advice for each kind of error, printed after the error.
+func errorHint(err error) string {
+	var e *errs.Error
+	errors.As(err, &e)
+	switch {
+	case errors.Is(err, errs.ErrMissingOutput):
+		return "\"lv1-netest status\" lists the subproblems of the output directory that still need to be searched"
+	case errors.Is(err, errs.ErrInvalidOutput), errors.Is(err, errs.ErrNonBinaryTree):
+		if e != nil && e.File != "" {
+			return fmt.Sprintf("remove %s and search its subproblem again", filepath.Base(e.File))
+		}
+		return "search the affected subproblem again"
+	case errors.Is(err, errs.ErrInvalidInput):
+		return "check the alignment file, and that the output directory was set up from it"
+	case errors.Is(err, errs.ErrInternal):
+		return "this is a bug; please report it with the input that caused it"
+	}
+	return ""
+}
+
+func newRootCommand() *cobra.Command {
+	var configFile string
+	root := &cobra.Command{
+		Use:   "lv1-netest",
+		Short: "Estimate level-1 phylogenetic networks from two-state characters",
+		Long: `Estimates a level-1 network from an alignment of two-state characters: an
+SN-tree is built, and the cycle at each of its polytomies is found by searching
+leave-one-out subproblems with PAUP* or a built-in parsimony search.
+
+Use "run" to do everything at once, or "setup" and "finish" to run PAUP*
+elsewhere in between.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			return applySettings(cmd, configFile)
+		},
+	}
+	root.PersistentFlags().StringVar(&configFile, "config", "", "config file (default: $LV1_NETEST_CONFIG, or lv1-netest/config in the user config directory)")
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
+	root.AddCommand(newSetupCommand(), newFinishCommand(), newRunCommand(), newStatusCommand(), newHPCCommand(),
+		newServeCommand(), newWorkerCommand(), newConvertCommand(), newValidateCommand(), newCompareCommand(), newSimulateCommand(), newBenchmarkCommand(), newScoreCommand())
+	return root
+}
+
+// Wraps the work of a command, so its errors are told apart from usage errors.
+func runE(f func(cmd *cobra.Command, args []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := f(cmd, args); err != nil {
+			return runError{err}
+		}
+		return nil
+	}
+}
+
+// Sets the flags of cmd that were not given on the command line from the
+// environment or, failing that, from the config file.
+func applySettings(cmd *cobra.Command, configFile string) error {
+	config, file, err := readConfig(configFile)
+	if err != nil {
+		return usageError{err}
+	}
+	section := config[cmd.Name()]
+	for key := range section {
+		if cmd.Flags().Lookup(key) == nil {
+			return usageError{fmt.Errorf("%s: unknown setting %q in [%s]", file, key, cmd.Name())}
+		}
+	}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		source, value, found := envPrefix+strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_")), "", false
+		if value, found = os.LookupEnv(source); !found {
+			source = file
+			if value, found = section[f.Name]; !found {
+				value, found = config[""][f.Name] // settings before the first section apply to every command having the flag
+			}
+		}
+		if found {
+			if setErr := cmd.Flags().Set(f.Name, value); setErr != nil {
+				err = usageError{fmt.Errorf("%s: --%s: %w", source, f.Name, setErr)}
+			}
+		}
+	})
+	return err
+}
+
+// Reads a config file of "flag = value" lines, with settings for a single
+// command in a "[command]" section. Returns the settings by section, "" for
+// those before any section, and the file they were read from.
+func readConfig(file string) (map[string]map[string]string, string, error) {
+	config := map[string]map[string]string{"": {}}
+	optional := false
+	if file == "" {
+		file = os.Getenv(envPrefix + "CONFIG")
+	}
+	if file == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return config, "", nil
+		}
+		file, optional = filepath.Join(dir, "lv1-netest", "config"), true
+	}
+	f, err := os.Open(file)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return config, file, nil
+	} else if err != nil {
+		return nil, file, err
+	}
+	defer f.Close()
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if config[section] == nil {
+				config[section] = make(map[string]string)
+			}
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, file, fmt.Errorf("%s:%d: expected \"flag = value\", got %q", file, n, line)
+		}
+		config[section][strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return config, file, scanner.Err()
+}
+
+func completeValues(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// Flags of the setup, finish and run commands.
+type pipelineFlags struct {
+	alignment string
+	dir       string
+	criterion string
+	template  string
+	ranking   string
+	seed      int64
+	redo      string
+	cache     string
+}
+
+func (f *pipelineFlags) addInput(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.alignment, "alignment", "a", "", "alignment file (NEXUS)")
+	cmd.Flags().StringVarP(&f.dir, "dir", "d", "", "output directory, holding the subproblems and results")
+	cmd.MarkFlagRequired("alignment")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagFilename("alignment", "nex", "nexus", "nxs")
+	cmd.MarkFlagDirname("dir")
+	cmd.Flags().StringVarP(&f.criterion, "criterion", "c", "unord", "optimality criterion for polytomy subproblems: "+strings.Join(subproblem.CriterionNames(), ", "))
+	cmd.RegisterFlagCompletionFunc("criterion", completeValues(subproblem.CriterionNames()...))
+	cmd.Flags().StringVar(&f.cache, "cache", "", "directory caching subproblem results across runs")
+	cmd.MarkFlagDirname("cache")
+}
+
+func (f *pipelineFlags) addSetup(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.template, "template", "t", "default", "PAUP* block: a preset (fast, default, thorough) or a text/template file")
+	cmd.RegisterFlagCompletionFunc("template", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return []string{"fast", "default", "thorough"}, cobra.ShellCompDirectiveDefault
+	})
+	cmd.Flags().Int64Var(&f.seed, "seed", 0, "random seed for the subproblem searches (0, the default, picks one from the clock; it is recorded in manifest.json)")
+}
+
+func (f *pipelineFlags) addFinish(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.ranking, "ranking", "r", "", "ranking of candidate trees: length, ci, ri, rc, hi, lnl, aic, aicc, or weights such as ci=1,ri=0.5 (default depends on the criterion)")
+	cmd.RegisterFlagCompletionFunc("ranking", completeValues("length", "ci", "ri", "rc", "hi", "lnl", "aic", "aicc"))
+}
+
+// Flags choosing the search backend and the programs it runs.
+type backendFlags struct {
+	name      string
+	solving   bool // whether the command runs the backend, rather than only preparing input for it
+	paupArgs  string
+	tntArgs   string
+	iqArgs    string
+	raxmlArgs string
+	programs  subproblem.Programs
+}
+
+// Adds --backend with the given default and, if the command solves
+// subproblems, the flags of the programs backends run.
+func (f *backendFlags) add(cmd *cobra.Command, def string, solving bool) {
+	cmd.Flags().StringVar(&f.name, "backend", def, "program solving the subproblems: "+strings.Join(subproblem.Backends, ", ")+" (builtin and tnt are parsimony only, iqtree and raxml likelihood only)")
+	cmd.RegisterFlagCompletionFunc("backend", completeValues(subproblem.Backends...))
+	if f.solving = solving; !solving {
+		return
+	}
+	cmd.Flags().StringVar(&f.name, "engine", def, "")
+	cmd.Flags().MarkDeprecated("engine", "use --backend")
+	cmd.Flags().StringVar(&f.programs.PAUP, "paup", "paup", "PAUP* executable, with --backend paup")
+	cmd.Flags().StringVar(&f.paupArgs, "paup-args", "-n", "arguments given to PAUP* before the input file")
+	cmd.Flags().StringVar(&f.programs.TNT, "tnt", "tnt", "TNT executable, with --backend tnt")
+	cmd.Flags().StringVar(&f.tntArgs, "tnt-args", "bground", "arguments given to TNT before the command running the run script")
+	cmd.Flags().StringVar(&f.programs.IQTree, "iqtree", "iqtree2", "IQ-TREE executable, with --backend iqtree")
+	cmd.Flags().StringVar(&f.iqArgs, "iqtree-args", "", "arguments given to IQ-TREE after the ones the backend sets, e.g. \"-nt 2\"")
+	cmd.Flags().StringVar(&f.programs.RAxML, "raxml", "raxml-ng", "RAxML-NG executable, with --backend raxml")
+	cmd.Flags().StringVar(&f.raxmlArgs, "raxml-args", "", "arguments given to RAxML-NG after the ones the backend sets, e.g. \"--threads 2\"")
+	cmd.Flags().StringVar(&f.programs.Command, "command", "", "shell command solving a subproblem, with --backend command; {input}, {stem}, {scores}, {trees}, {seed} and {criterion} are replaced by its values")
+}
+
+func (f *backendFlags) backend() (subproblem.SearchBackend, error) {
+	f.programs.PAUPArgs = strings.Fields(f.paupArgs)
+	f.programs.TNTArgs = strings.Fields(f.tntArgs)
+	f.programs.IQArgs = strings.Fields(f.iqArgs)
+	f.programs.RAxMLArgs = strings.Fields(f.raxmlArgs)
+	if f.solving && f.name == "command" && f.programs.Command == "" {
+		return nil, usageError{errors.New("--backend command needs --command")}
+	}
+	b, err := subproblem.NewBackend(f.name, f.programs)
+	if err != nil {
+		return nil, usageError{err}
+	}
+	return b, nil
+}
+
+// Reads the alignment and turns the flags into estimation options.
+func (f *pipelineFlags) resolve(cmd *cobra.Command) (netest.Options, align.Alignment, error) {
+	if _, err := subproblem.ParseCriterion(f.criterion); err != nil {
+		return netest.Options{}, nil, usageError{err}
+	}
+	aln, err := alignio.Read(f.alignment)
+	if err != nil {
+		return netest.Options{}, nil, errs.New(errs.ErrInvalidInput, f.alignment, fmt.Errorf("could not read alignment: %w", err))
+	}
+	opts := netest.Options{
+		Dir:           f.dir,
+		AlignmentFile: f.alignment,
+		Criterion:     f.criterion,
+		Ranking:       f.ranking,
+		Template:      f.template,
+		Seed:          f.seed,
+		Redo:          f.redo,
+		Cache:         f.cache,
+		Log:           os.Stdout,
+	}
+	if !cmd.Flags().Changed("criterion") && cmd.Name() == "finish" {
+		opts.Criterion = "" // the one used in setup
+	}
+	return opts, aln, nil
+}
+
+func newSetupCommand() *cobra.Command {
+	var f pipelineFlags
+	var b backendFlags
+	cmd := &cobra.Command{
+		Use:   "setup -a ALIGNMENT -d DIR",
+		Short: "Write the polytomy subproblems of an alignment as PAUP* input",
+		Long: `Builds the SN-tree of the alignment and writes one PAUP* input file for
+every leave-one-out subproblem of every polytomy to the output directory.
+Run PAUP* on each of them, then "finish" on the same directory. With --backend
+the input is written for another search program instead.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			opts, aln, err := f.resolve(cmd)
+			if err != nil {
+				return err
+			}
+			if opts.Backend, err = b.backend(); err != nil {
+				return err
+			}
+			_, err = netest.Setup(aln, opts)
+			return err
+		}),
+	}
+	f.addInput(cmd)
+	f.addSetup(cmd)
+	b.add(cmd, "paup", false)
+	return cmd
+}
+
+func newFinishCommand() *cobra.Command {
+	var f pipelineFlags
+	cmd := &cobra.Command{
+		Use:   "finish -a ALIGNMENT -d DIR",
+		Short: "Read the PAUP* results of a setup directory and write the network",
+		Long: `Reads the PAUP* output of every subproblem in a directory written by
+"setup", picks the best candidate tree of each polytomy, closes its cycle and
+writes the final network to final_network.nwk. Completed stages are kept in
+the finish subdirectory, so a rerun resumes where the last one stopped.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			opts, aln, err := f.resolve(cmd)
+			if err != nil {
+				return err
+			}
+			_, err = netest.Finish(aln, opts)
+			return err
+		}),
+	}
+	f.addInput(cmd)
+	f.addFinish(cmd)
+	cmd.Flags().StringVar(&f.redo, "redo", "", "polytomies to recompute instead of resuming from the checkpoint, by index or ID (e.g. 0,3 or all)")
+	criterion := cmd.Flags().Lookup("criterion")
+	criterion.Usage += " (default: the one used in setup)"
+	criterion.DefValue = ""
+	return cmd
+}
+
+func newConvertCommand() *cobra.Command {
+	var input, output, format string
+	cmd := &cobra.Command{
+		Use:   "convert -i INPUT -o OUTPUT.nex",
+		Short: "Convert a FASTA or PHYLIP alignment to the NEXUS format read by the other commands",
+		Args:  cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			if format == "" {
+				if _, err := alignio.FormatOf(input); err != nil {
+					return usageError{fmt.Errorf("%w; use --format", err)}
+				}
+			} else if !slices.Contains(alignio.Formats, format) {
+				return usageError{fmt.Errorf("unknown format %q (expected one of %s)", format, strings.Join(alignio.Formats, ", "))}
+			}
+			aln, err := alignio.ReadFormat(input, format)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(output, []byte(alignio.WriteNexus(aln)), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("%d taxa and %d sites written to %s\n", aln.NbSequences(), aln.Length(), output)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", "", "alignment to convert")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "NEXUS file to write")
+	cmd.Flags().StringVar(&format, "format", "", "format of the input: "+strings.Join(alignio.Formats, ", ")+" (default: from its extension)")
+	cmd.MarkFlagRequired("input")
+	cmd.MarkFlagRequired("output")
+	cmd.RegisterFlagCompletionFunc("format", completeValues(alignio.Formats...))
+	return cmd
+}
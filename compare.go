@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/network"
+)
+
+func newCompareCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare ESTIMATED REFERENCE",
+		Short: "Measure how far an estimated network is from a reference network",
+		Long: `Compares the first network of each extended Newick file, which must have the
+same taxa, and prints a table of the hardwired and softwired cluster,
+tripartition, rooted triplet and displayed tree distances and the precision and
+recall of the hybrid taxa (those below a reticulation), followed by the mean
+Robinson-Foulds distance of each displayed tree to the closest one of the other
+network.`,
+		Args: cobra.ExactArgs(2),
+		RunE: runE(func(cmd *cobra.Command, args []string) error {
+			networks := make([]*network.Network, 2)
+			for k, file := range args {
+				read, err := network.ReadNetworks(file)
+				if err != nil {
+					return err
+				}
+				networks[k] = read[0]
+			}
+			comparison, err := network.Compare(networks[0], networks[1])
+			if err != nil {
+				return err
+			}
+			fmt.Print(comparison)
+			return nil
+		}),
+	}
+	return cmd
+}
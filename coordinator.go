@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/subproblem"
+)
+
+// Hands out the subproblem runs of a setup directory to workers over HTTP and
+// collects their output. Runs are leased for a limited time, so runs of
+// workers that disappear are handed out again.
+//
+// API (all bodies are JSON):
+//
+//	POST /lease              {"worker"}          -> 200 Lease, 204 if every run is leased, 410 if all are done
+//	POST /jobs/{id}/renew    {"token"}           -> 200 Lease, 409 if the lease was lost or expired
+//	POST /jobs/{id}/result   {"token", "outputs"} -> 200 {"status"}, 409 unless the token is the one of the last lease or if the run failed; repeating a submission is harmless
+//	POST /jobs/{id}/fail     {"token", "error"}  -> 200 {"status"}
+//	GET  /status                                 -> 200 CoordinatorStatus
+type Coordinator struct {
+	dir       string
+	criterion string
+	backend   subproblem.SearchBackend // the one the setup was prepared for, which checks results
+	lease     time.Duration
+	attempts  int // runs that failed this many times are given up on
+	mu        sync.Mutex
+	jobs      []*coordinatorJob
+	done      chan struct{} // closed when no run is pending or leased
+}
+
+type coordinatorJob struct {
+	ID        string // file stem, e.g. polytomy_0_3
+	State     string // pending, leased, done or failed
+	Worker    string
+	Token     string `json:"-"` // only the worker holding the lease knows it
+	Expires   time.Time
+	Attempts  int
+	Errors    []string
+	lastToken string   // token of the last lease, kept after it expires
+	failedOn  []string // workers this run failed on
+	sub       subproblem.Subproblem
+	result    *JobResult
+}
+
+type Lease struct {
+	ID         string            `json:"id"`
+	Token      string            `json:"token"`
+	Expires    time.Time         `json:"expires"`
+	Backend    string            `json:"backend,omitempty"` // backend the input was prepared for
+	Inputs     map[string]string `json:"inputs,omitempty"`  // content of each input file, by name
+	Polytomy   int               `json:"polytomy"`
+	PolytomyID string            `json:"polytomy_id,omitempty"`
+	Candidate  int               `json:"candidate"`
+	Removed    string            `json:"removed,omitempty"`
+	Taxa       []string          `json:"taxa,omitempty"`
+	Criterion  string            `json:"criterion,omitempty"` // empty without a manifest
+	Seed       int64             `json:"seed,omitempty"`
+}
+
+type JobResult struct {
+	Token   string            `json:"token"`
+	Outputs map[string]string `json:"outputs"` // content of each output file, by name
+}
+
+type CoordinatorStatus struct {
+	Pending int               `json:"pending"`
+	Leased  int               `json:"leased"`
+	Done    int               `json:"done"`
+	Failed  int               `json:"failed"`
+	Jobs    []*coordinatorJob `json:"jobs"`
+}
+
+func NewCoordinator(dir string, lease time.Duration, attempts int) (*Coordinator, error) {
+	cache, err := subproblem.OpenSetupCache(dir, "")
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := subproblem.Status(dir, cache)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := subproblem.ReadManifest(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	c := &Coordinator{dir: dir, backend: subproblem.SetupBackend(manifest), lease: lease, attempts: attempts, done: make(chan struct{})}
+	if manifest != nil {
+		c.criterion = manifest.Parameters.Criterion
+	}
+	for _, s := range statuses {
+		if s.Done() {
+			continue
+		}
+		for _, input := range c.backend.Inputs(s.Stem) {
+			if _, err := os.Stat(filepath.Join(dir, input)); err != nil {
+				return nil, fmt.Errorf("%s cannot be run: %w", s.Stem, err)
+			}
+		}
+		c.jobs = append(c.jobs, &coordinatorJob{ID: s.Stem, State: "pending", sub: s.Subproblem})
+	}
+	c.checkDone()
+	return c, nil
+}
+
+// Done is closed once every run is done or has failed too often.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /lease", c.handleLease)
+	mux.HandleFunc("POST /jobs/{id}/renew", c.handleRenew)
+	mux.HandleFunc("POST /jobs/{id}/result", c.handleResult)
+	mux.HandleFunc("POST /jobs/{id}/fail", c.handleFail)
+	mux.HandleFunc("GET /status", c.handleStatus)
+	return mux
+}
+
+// must be called with c.mu held
+func (c *Coordinator) expireLeases(now time.Time) {
+	for _, job := range c.jobs {
+		if job.State == "leased" && now.After(job.Expires) {
+			c.giveUp(job, fmt.Sprintf("lease of %s expired", job.Worker))
+		}
+	}
+}
+
+// must be called with c.mu held
+func (c *Coordinator) giveUp(job *coordinatorJob, reason string) {
+	job.Attempts++
+	job.Errors = append(job.Errors, reason)
+	job.Worker, job.Token = "", ""
+	if job.Attempts >= c.attempts {
+		job.State = "failed"
+		fmt.Fprintf(os.Stderr, "%s failed %d times, giving up: %s\n", job.ID, job.Attempts, reason)
+	} else {
+		job.State = "pending"
+	}
+	c.checkDone()
+}
+
+// must be called with c.mu held
+func (c *Coordinator) checkDone() {
+	open := slices.ContainsFunc(c.jobs, func(j *coordinatorJob) bool { return j.State == "pending" || j.State == "leased" })
+	select {
+	case <-c.done:
+	default:
+		if !open {
+			close(c.done)
+		}
+	}
+}
+
+func (c *Coordinator) find(w http.ResponseWriter, r *http.Request) *coordinatorJob {
+	id := r.PathValue("id")
+	for _, job := range c.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	http.Error(w, fmt.Sprintf("no run %q", id), http.StatusNotFound)
+	return nil
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Worker string `json:"worker"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.expireLeases(now)
+	// runs are given to workers they have not failed on first
+	i := slices.IndexFunc(c.jobs, func(j *coordinatorJob) bool {
+		return j.State == "pending" && !slices.Contains(j.failedOn, request.Worker)
+	})
+	if i == -1 {
+		i = slices.IndexFunc(c.jobs, func(j *coordinatorJob) bool { return j.State == "pending" })
+	}
+	if i == -1 {
+		if slices.ContainsFunc(c.jobs, func(j *coordinatorJob) bool { return j.State == "leased" }) {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusGone)
+		}
+		return
+	}
+	job := c.jobs[i]
+	inputs := make(map[string]string)
+	for _, name := range c.backend.Inputs(job.ID) {
+		content, err := os.ReadFile(filepath.Join(c.dir, name))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		inputs[name] = string(content)
+	}
+	job.State, job.Worker, job.Token, job.Expires = "leased", request.Worker, newToken(), now.Add(c.lease)
+	job.lastToken = job.Token
+	fmt.Printf("%s leased to %s\n", job.ID, job.Worker)
+	writeJSON(w, Lease{ID: job.ID, Token: job.Token, Expires: job.Expires, Backend: c.backend.Name(), Inputs: inputs,
+		Polytomy: job.sub.Polytomy, PolytomyID: job.sub.PolytomyID, Candidate: job.sub.Candidate, Removed: job.sub.Removed, Taxa: job.sub.Taxa,
+		Criterion: c.criterion, Seed: job.sub.Seed})
+}
+
+func (c *Coordinator) handleRenew(w http.ResponseWriter, r *http.Request) {
+	var request JobResult
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLeases(time.Now()) // an expired lease cannot be renewed, even if the run was not leased again yet
+	job := c.find(w, r)
+	if job == nil {
+		return
+	}
+	if job.State != "leased" || job.Token != request.Token {
+		http.Error(w, fmt.Sprintf("lease on %s was lost", job.ID), http.StatusConflict)
+		return
+	}
+	job.Expires = time.Now().Add(c.lease)
+	writeJSON(w, Lease{ID: job.ID, Token: job.Token, Expires: job.Expires})
+}
+
+func (c *Coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	var result JobResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job := c.find(w, r)
+	if job == nil {
+		return
+	}
+	if result.Token == "" || result.Token != job.lastToken {
+		http.Error(w, fmt.Sprintf("no lease on %s with this token", job.ID), http.StatusConflict)
+		return
+	}
+	if job.State == "failed" {
+		http.Error(w, fmt.Sprintf("%s was given up on", job.ID), http.StatusConflict)
+		return
+	}
+	if job.State == "done" {
+		// a retried submission, or a worker whose lease expired finishing late
+		status := "duplicate"
+		if maps.Equal(job.result.Outputs, result.Outputs) {
+			status = "done"
+		}
+		writeJSON(w, map[string]string{"status": status})
+		return
+	}
+	// output is accepted even if the lease expired, as long as the run was not
+	// leased again, as it is still a valid search result
+	if err := c.store(job, &result); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	job.State, job.result = "done", &result
+	fmt.Printf("%s done\n", job.ID)
+	c.checkDone()
+	writeJSON(w, map[string]string{"status": "done"})
+}
+
+// Checks the output of a run and writes it to the setup directory.
+func (c *Coordinator) store(job *coordinatorJob, result *JobResult) error {
+	tmp, err := os.MkdirTemp(c.dir, "upload-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	outputs := c.backend.Outputs(job.ID)
+	for name := range result.Outputs {
+		if !slices.Contains(outputs, name) {
+			return fmt.Errorf("unexpected output %s (expected %s)", name, strings.Join(outputs, ", "))
+		}
+	}
+	for _, name := range outputs {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(result.Outputs[name]), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := c.backend.Results(tmp, job.sub); err != nil {
+		return fmt.Errorf("invalid output: %w", err)
+	}
+	// the outputs are moved one at a time, so they are marked as not belonging
+	// together until all are moved
+	marker := subproblem.Replacing(c.dir, job.ID)
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return err
+	}
+	for _, name := range outputs {
+		if err := os.Rename(filepath.Join(tmp, name), filepath.Join(c.dir, name)); err != nil {
+			return err
+		}
+	}
+	return os.Remove(marker)
+}
+
+func (c *Coordinator) handleFail(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job := c.find(w, r)
+	if job == nil {
+		return
+	}
+	if job.State == "leased" && job.Token == request.Token { // reports on lost leases are ignored
+		fmt.Fprintf(os.Stderr, "%s failed on %s: %s\n", job.ID, job.Worker, request.Error)
+		job.failedOn = append(job.failedOn, job.Worker)
+		c.giveUp(job, fmt.Sprintf("%s: %s", job.Worker, request.Error))
+	}
+	writeJSON(w, map[string]string{"status": job.State})
+}
+
+func (c *Coordinator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLeases(time.Now())
+	writeJSON(w, c.status())
+}
+
+// must be called with c.mu held
+func (c *Coordinator) status() CoordinatorStatus {
+	s := CoordinatorStatus{Jobs: c.jobs}
+	for _, job := range c.jobs {
+		switch job.State {
+		case "pending":
+			s.Pending++
+		case "leased":
+			s.Leased++
+		case "done":
+			s.Done++
+		case "failed":
+			s.Failed++
+		}
+	}
+	return s
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func newServeCommand() *cobra.Command {
+	var polytomyDir, addr string
+	var lease, linger time.Duration
+	var attempts int
+	cmd := &cobra.Command{
+		Use:   "serve -d DIR",
+		Short: "Hand out the subproblem runs of a setup directory to workers over HTTP",
+		Long: `Serves the subproblems of a setup directory that are not complete yet to
+"worker" processes, which may run on other machines, and writes their results
+to the directory. Exits once every run is done.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			c, err := NewCoordinator(polytomyDir, lease, attempts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("serving %d runs on %s\n", len(c.jobs), addr)
+			server := &http.Server{Addr: addr, Handler: c.Handler()}
+			go func() {
+				<-c.Done()
+				time.Sleep(linger) // lets idle workers see that everything is done
+				server.Close()
+			}()
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			c.mu.Lock()
+			s := c.status()
+			c.mu.Unlock()
+			fmt.Printf("%d runs done, %d failed\n", s.Done, s.Failed)
+			if s.Failed > 0 {
+				return fmt.Errorf("%d runs failed", s.Failed)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&polytomyDir, "dir", "d", "", "directory created by setup")
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "address to listen on")
+	cmd.Flags().DurationVar(&lease, "lease", 30*time.Minute, "how long a worker may hold a run without renewing it")
+	cmd.Flags().IntVar(&attempts, "attempts", 3, "give up on a run after this many failed or expired attempts")
+	cmd.Flags().DurationVar(&linger, "linger", 10*time.Second, "keep answering workers this long after all runs are done")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagDirname("dir")
+	return cmd
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lv1-netest/alignio"
+	"lv1-netest/netest"
+	"lv1-netest/subproblem"
+)
+
+// Sets up testdata/twocyclesfull.nex for the built-in search in a temporary
+// directory, and returns it.
+func setupForCoordinator(t *testing.T) string {
+	t.Helper()
+	aln, err := alignio.Read("testdata/twocyclesfull.nex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "setup")
+	opts := netest.Options{Dir: dir, AlignmentFile: "testdata/twocyclesfull.nex", Seed: 1, Backend: subproblem.BuiltinBackend{}}
+	if _, err := netest.Setup(aln, opts); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Posts in as JSON and returns the status code and body.
+func post(t *testing.T, url string, in any) (int, string) {
+	t.Helper()
+	body, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(content)
+}
+
+func TestCoordinatorWorker(t *testing.T) {
+	dir := setupForCoordinator(t)
+	c, err := NewCoordinator(dir, time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+	w := &Worker{URL: server.URL, Name: "test", Backend: subproblem.BuiltinBackend{}, WorkDir: t.TempDir(), Poll: 10 * time.Millisecond, Retries: 1}
+	if err := w.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("the coordinator is not done after the worker finished")
+	}
+	jobs, err := subproblem.Status(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if !job.Done() {
+			t.Errorf("%s is not done: %v", job.Stem, job.Problems)
+		}
+	}
+	if markers, _ := filepath.Glob(filepath.Join(dir, "*.replacing")); len(markers) > 0 {
+		t.Errorf("markers left behind: %v", markers)
+	}
+}
+
+func TestCoordinatorLeases(t *testing.T) {
+	// Each step acts on the run of the first lease, with the token of one
+	// of the leases so far ("bad" for a wrong one).
+	type step struct {
+		action   string // lease, renew, result, fail, expire or status
+		lease    int    // index of the lease whose token is sent, -1 for a bad token
+		outputs  string // result: "valid" or "other", valid output of another run
+		code     int
+		contains string // in the body of the answer
+	}
+	tests := []struct {
+		name     string
+		attempts int
+		steps    []step
+		done     bool // whether the run of the first lease ends up done
+	}{
+		{"bad token", 3, []step{
+			{action: "lease", code: http.StatusOK},
+			{action: "renew", lease: -1, code: http.StatusConflict},
+			{action: "result", lease: -1, outputs: "valid", code: http.StatusConflict},
+			{action: "fail", lease: -1, code: http.StatusOK, contains: `"leased"`},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusOK, contains: `"done"`},
+		}, true},
+		{"duplicate submission", 3, []step{
+			{action: "lease", code: http.StatusOK},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusOK, contains: `"done"`},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusOK, contains: `"done"`},
+			{action: "result", lease: 0, outputs: "other", code: http.StatusOK, contains: `"duplicate"`},
+		}, true},
+		{"lease expiry", 3, []step{
+			{action: "lease", code: http.StatusOK},
+			{action: "expire"},
+			{action: "renew", lease: 0, code: http.StatusConflict},
+			{action: "lease", code: http.StatusOK},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusConflict},
+			{action: "result", lease: 1, outputs: "valid", code: http.StatusOK, contains: `"done"`},
+		}, true},
+		{"late result of an expired lease", 3, []step{
+			{action: "lease", code: http.StatusOK},
+			{action: "expire"},
+			{action: "status", code: http.StatusOK},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusOK, contains: `"done"`},
+		}, true},
+		{"failed run", 1, []step{
+			{action: "lease", code: http.StatusOK},
+			{action: "fail", lease: 0, code: http.StatusOK, contains: `"failed"`},
+			{action: "result", lease: 0, outputs: "valid", code: http.StatusConflict},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := setupForCoordinator(t)
+			const lease = 200 * time.Millisecond
+			c, err := NewCoordinator(dir, lease, test.attempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(c.Handler())
+			defer server.Close()
+			w := &Worker{Backend: subproblem.BuiltinBackend{}, WorkDir: t.TempDir()}
+			var leases []Lease
+			results := make(map[string]*JobResult) // valid output of each run
+			output := func(l Lease) *JobResult {
+				if results[l.ID] == nil {
+					result, err := w.solve(context.Background(), l)
+					if err != nil {
+						t.Fatal(err)
+					}
+					results[l.ID] = result
+				}
+				return results[l.ID]
+			}
+			for i, s := range test.steps {
+				token := "bad"
+				if s.lease >= 0 && s.lease < len(leases) {
+					token = leases[s.lease].Token
+				}
+				var code int
+				var body string
+				switch s.action {
+				case "lease":
+					code, body = post(t, server.URL+"/lease", map[string]string{"worker": "test"})
+					var l Lease
+					if err := json.Unmarshal([]byte(body), &l); err != nil {
+						t.Fatalf("step %d: %v", i, err)
+					}
+					if len(leases) > 0 && l.ID != leases[0].ID {
+						t.Fatalf("step %d: leased %s rather than %s again", i, l.ID, leases[0].ID)
+					}
+					leases = append(leases, l)
+				case "renew", "fail":
+					code, body = post(t, server.URL+"/jobs/"+leases[0].ID+"/"+s.action, map[string]string{"token": token, "error": "test"})
+				case "result":
+					result := &JobResult{Token: token, Outputs: output(leases[0]).Outputs}
+					if s.outputs == "other" {
+						other := maps.Clone(result.Outputs)
+						for name := range other {
+							other[name] += "\n"
+						}
+						result.Outputs = other
+					}
+					code, body = post(t, server.URL+"/jobs/"+leases[0].ID+"/result", result)
+				case "expire":
+					output(leases[0]) // solved first, so it is not what takes time
+					time.Sleep(lease + 50*time.Millisecond)
+					continue
+				case "status":
+					resp, err := http.Get(server.URL + "/status")
+					if err != nil {
+						t.Fatal(err)
+					}
+					content, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					code, body = resp.StatusCode, string(content)
+				}
+				if code != s.code {
+					t.Errorf("step %d (%s): status %d, expected %d: %s", i, s.action, code, s.code, body)
+				}
+				if !strings.Contains(body, s.contains) {
+					t.Errorf("step %d (%s): answer %q has no %s", i, s.action, body, s.contains)
+				}
+			}
+			resp, err := http.Get(server.URL + "/status")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			content, _ := io.ReadAll(resp.Body)
+			for _, l := range leases {
+				if strings.Contains(string(content), l.Token) {
+					t.Errorf("status shows the token of the lease on %s", l.ID)
+				}
+			}
+			jobs, err := subproblem.Status(dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, job := range jobs {
+				if job.Stem == leases[0].ID && job.Done() != test.done {
+					t.Errorf("%s done: %t, expected %t (%v)", job.Stem, job.Done(), test.done, job.Problems)
+				}
+				if job.Stem == leases[0].ID && job.Done() {
+					for name, content := range results[job.Stem].Outputs {
+						if written := readFile(t, filepath.Join(dir, name)); written != content {
+							t.Errorf("%s was replaced by another submission", name)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,140 +1,38 @@
-package main
+// Package cycle closes the cycle of a polytomy: given the best tree of a
+// subproblem without one of the polytomy's taxa, it attaches that taxon so
+// that the resulting cycle agrees with the most sites.
+package cycle
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"slices"
-	"strconv"
-	"strings"
 
 	"github.com/evolbioinfo/goalign/align"
-	"github.com/evolbioinfo/gotree/io/nexus"
 	"github.com/evolbioinfo/gotree/tree"
 	"github.com/fredericlemoine/bitset"
-)
-
-func ReadTaxa(dir string) map[int][]string {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		panic(err)
-	}
-	taxa := make(map[int][]string, 0)
-	var id int
-	for _, file := range files {
-		if nMatch, err := fmt.Sscanf(file.Name(), "taxa_%d.txt", &id); err != nil {
-			// fmt.Println(file.Name(), nMatch, id)
-			// panic(err)
-			continue
-		} else if nMatch != 1 {
-			panic("expected to match 1 value")
-		} else {
-			content, err := os.ReadFile(filepath.Join(dir, file.Name()))
-			if err != nil {
-				panic(err)
-			}
-			taxa[id] = strings.Split(string(content), "\n")
-		}
-	}
-	return taxa
-}
 
-func ReadPAUPResults(dir string, nPolytomy uint) []*tree.Tree {
-	trees := make([]*tree.Tree, nPolytomy)
-	for i := range nPolytomy {
-		trees[i] = readPolytomy(dir, i)
-	}
-	return trees
-}
-
-func readPolytomy(dir string, i uint) *tree.Tree {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		panic(err)
-	}
-	var j int
-	candidates := make(map[int][]int) // possible trees (each with a different taxa removed)
-	for _, file := range files {
-		if nMatch, err := fmt.Sscanf(file.Name(), "polytomy_"+strconv.Itoa(int(i))+"_%d_scores.tsv", &j); err != nil {
-			// panic(err) // TODO: catch error if this file doesn't exist as any of the files
-			continue
-		} else if nMatch != 1 {
-			panic("expected to match 2 values")
-		} else {
-			// fmt.Println(file.Name())
-			filePointer, err := os.Open(filepath.Join(dir, file.Name()))
-			if err != nil {
-				panic(err)
-			}
-			defer filePointer.Close()
-			reader := csv.NewReader(filePointer)
-			reader.Comma = '\t'
-			records, err := reader.ReadAll()
-			if err != nil {
-				panic(err)
-			}
-			// This loop selects which of the multiple trees *on the same taxa* outputted by PAUP* is best
-			maxTree, maxVal := -1, -1
-			for k, record := range records {
-				if k != 0 { // skip header
-					fval, err := strconv.ParseFloat(record[2], 64)
-					if err != nil {
-						panic(err)
-					}
-					curVal := int(100 * fval)
-					if curVal < maxVal || maxVal == -1 {
-						maxTree, maxVal = k-1, curVal // -1 as we don't count the heade
-					}
-				}
-			}
-			candidates[j] = []int{maxTree, maxVal}
-		}
-	}
-	// fmt.Println(candidates)
-	bestTree, bestScore := -1, -1
-	for k, v := range candidates {
-		// fmt.Println(v)
-		if v[1] > bestScore || bestScore == -1 {
-			bestTree, bestScore = k, v[1]
-		}
-	}
-	// fmt.Printf("best score for polytomy %d is %d\n", i, bestScore)
-	// read tree
-	treeFile, err := os.Open(fmt.Sprintf("%s/polytomy_%d_%d_trees.nex", dir, i, bestTree))
-	if err != nil {
-		panic(err)
-	}
-	nxs, err := nexus.NewParser(treeFile).Parse()
-	if err != nil {
-		panic(err)
-	}
-	// fmt.Println(nxs)
-	var result *tree.Tree
-	tIndex := 0
-	nxs.IterateTrees(func(s string, t *tree.Tree) { // I don't think there's a better way to get the nth tree with this library
-		if tIndex == candidates[bestTree][0] {
-			result = t
-		}
-		tIndex++
-	})
-	return result
-}
+	"lv1-netest/errs"
+	"lv1-netest/splits"
+)
 
-func getSubalignment(aln align.Alignment, taxa []string) align.Alignment {
+func getSubalignment(aln align.Alignment, taxa []string) (align.Alignment, error) {
 	subaln := align.NewAlign(align.UNKNOWN)
 	for _, t := range taxa {
 		seq, exists := aln.SequenceByName(t)
 		if exists {
 			subaln.AddSequence(t, seq.Sequence(), "")
 		} else {
-			panic(fmt.Sprintf("sequence for taxa %s does not exist", t))
+			return nil, errs.NoSequence(-1, t)
 		}
 	}
-	return subaln
+	return subaln, nil
 }
 
-func CloseCycle(bestTree *tree.Tree, taxa []string, aln align.Alignment, n int) *tree.Tree {
+// Attaches the taxon of taxa that is missing from bestTree, the best tree of
+// polytomy n, where it closes the cycle explaining the most sites, and
+// returns where its other parent in the cycle is.
+func Close(bestTree *tree.Tree, taxa []string, aln align.Alignment, n int) (*tree.Tree, *Hybrid, error) {
 	// if !slices.IsSorted(taxa) { // make sure the bitset order matches between tree alignment
 	// 	panic("my assumption that taxa are sorted is wrong")
 	// }
@@ -142,6 +40,7 @@ func CloseCycle(bestTree *tree.Tree, taxa []string, aln align.Alignment, n int)
 	// for _, n := range bestTree.SortedTips() {
 	// 	fmt.Println(n.Name())
 	// }
+	taxa = slices.Clone(taxa) // the caller's order is the candidate order
 	slices.Sort(taxa)
 	x := 0
 	for i, t := range taxa {
@@ -152,39 +51,56 @@ func CloseCycle(bestTree *tree.Tree, taxa []string, aln align.Alignment, n int)
 	bestTree.ReinitInternalIndexes()
 	// bestTree.UpdateTipIndex()
 	// bestTree.UpdateBitSet()
-	subaln := getSubalignment(aln, taxa)
-	splits, err := CreateSplits(subaln, nil)
+	subaln, err := getSubalignment(aln, taxa)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss, err := splits.FromAlignment(subaln, nil)
 	if err != nil { // shouldn't happen
-		panic(err)
+		return nil, nil, errs.New(errs.ErrInternal, "", err)
+	}
+	edgeScores, err := preprocessEdgeScores(bestTree, ss, x)
+	if err != nil {
+		return nil, nil, err
 	}
-	edgeScores := preprocessEdgeScores(bestTree, splits, x)
 	// fmt.Println("edge scores", edgeScores)
 	// postorder and preorder pass scores (assuming edges are not part of backbone)
-	postorderPass := postorderScore(bestTree, edgeScores)
-	preorderPass := preorderScore(bestTree, edgeScores, postorderPass)
+	postorderPass, err := postorderScore(bestTree, edgeScores)
+	if err != nil {
+		return nil, nil, err
+	}
+	preorderPass, err := preorderScore(bestTree, edgeScores, postorderPass)
+	if err != nil {
+		return nil, nil, err
+	}
 	// fmt.Println(postorderPass)
 	// fmt.Println(preorderPass)
 
-	backbone := findBackbone(bestTree, edgeScores, postorderPass, preorderPass)
+	backbone, err := findBackbone(bestTree, edgeScores, postorderPass, preorderPass)
+	if err != nil {
+		return nil, nil, err
+	}
 	// fmt.Println(backbone)
+	hybrid := newHybrid(bestTree, edgeScores, backbone, taxa[x])
 	attachTaxa(bestTree, backbone, taxa[x], createLabel(n))
-	return bestTree
+	return bestTree, hybrid, nil
 }
 
-func preprocessEdgeScores(bestTree *tree.Tree, splits []*Split, x int) [][2]int {
+func preprocessEdgeScores(bestTree *tree.Tree, ss []*splits.Split, x int) ([][2]int, error) {
 	scores := make([][2]int, len(bestTree.Edges()))
 	// fmt.Print("splits")
 	// PrintSplits(splits)
+	var err error
 	bestTree.PostOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
 		if cur != bestTree.Root() {
-			scores[e.Id()] = scoreEdge(e, splits, x)
+			scores[e.Id()], err = scoreEdge(e, ss, x)
 		}
-		return true
+		return err == nil
 	})
-	return scores
+	return scores, err
 }
 
-func scoreEdge(e *tree.Edge, splits []*Split, x int) [2]int {
+func scoreEdge(e *tree.Edge, ss []*splits.Split, x int) ([2]int, error) {
 	// build bipartitions
 	// if e.Left().Tip() || e.Right().Tip() {
 	// 	return [2]int{0, 0}
@@ -192,7 +108,7 @@ func scoreEdge(e *tree.Edge, splits []*Split, x int) [2]int {
 	ogBitset := e.Bitset()
 	// fmt.Println("ogbitset", ogBitset.String())
 	if ogBitset == nil {
-		panic("bitset is nil")
+		return [2]int{}, errs.New(errs.ErrInternal, "", errors.New("edge has no bitset"))
 	}
 	xLeft, xRight := bitset.New(ogBitset.Len()+1), bitset.New(ogBitset.Len()+1)
 	for i := range ogBitset.Len() {
@@ -208,22 +124,36 @@ func scoreEdge(e *tree.Edge, splits []*Split, x int) [2]int {
 	// fmt.Println("left", xLeft.String())
 	// fmt.Println("right", xRight.String())
 	// fmt.Println("x", x)
-	return [2]int{CountMatches(splits, &Split{split: xLeft}), CountMatches(splits, &Split{split: xRight})}
+	left, err := splits.CountMatches(ss, splits.New(xLeft))
+	if err != nil {
+		return [2]int{}, err
+	}
+	right, err := splits.CountMatches(ss, splits.New(xRight))
+	return [2]int{left, right}, err
+}
+
+// Checks that e joins cur to its parent prev, as the traversals assume.
+func checkTraversal(cur, prev *tree.Node, e *tree.Edge) error {
+	if (e.Left() != cur && e.Right() != cur) || (e.Left() != prev && e.Right() != prev) {
+		return errs.New(errs.ErrInternal, "", errors.New("cur, prev and the edge e don't work the way I think"))
+	}
+	if p, err := cur.Parent(); err != nil {
+		return errs.New(errs.ErrInternal, "", err)
+	} else if p != prev {
+		return errs.New(errs.ErrInternal, "", errors.New("prev is not parent of cur"))
+	}
+	return nil
 }
 
-func postorderScore(bestTree *tree.Tree, scores [][2]int) []int {
+func postorderScore(bestTree *tree.Tree, scores [][2]int) ([]int, error) {
 	result := make([]int, len(bestTree.Edges())+1)
+	var err error
 	bestTree.PostOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
 		if bestTree.Root() == cur { // root has no corresponding edge
 			return true
 		}
-		if (e.Left() != cur && e.Right() != cur) || (e.Left() != prev && e.Right() != prev) {
-			panic("cur, prev and the edge e don't work the way I think")
-		}
-		if p, err := cur.Parent(); err != nil {
-			panic(err)
-		} else if p != prev {
-			panic("prev is not parent of cur")
+		if err = checkTraversal(cur, prev, e); err != nil {
+			return false
 		}
 		if e.Left() == cur {
 			result[e.Id()] = scores[e.Id()][1]
@@ -231,19 +161,23 @@ func postorderScore(bestTree *tree.Tree, scores [][2]int) []int {
 			result[e.Id()] = scores[e.Id()][0]
 		}
 		if !cur.Tip() {
-			for _, c := range children(cur) {
+			var nodes []*tree.Node
+			if nodes, err = children(cur); err != nil {
+				return false
+			}
+			for _, c := range nodes {
 				result[e.Id()] += result[c.Id()]
 			}
 		}
 		return true
 	})
-	return result
+	return result, err
 }
 
-func children(node *tree.Node) []*tree.Node {
+func children(node *tree.Node) ([]*tree.Node, error) {
 	p, err := node.Parent()
 	if err != nil {
-		panic(err)
+		return nil, errs.New(errs.ErrInternal, "", err)
 	}
 	children := make([]*tree.Node, 0)
 	for _, n := range node.Neigh() {
@@ -252,36 +186,37 @@ func children(node *tree.Node) []*tree.Node {
 		}
 	}
 	if len(children) != 2 {
-		panic("tree is not binary")
+		return nil, errs.New(errs.ErrNonBinaryTree, "", fmt.Errorf("node with %d children", len(children)))
 	}
-	return children
+	return children, nil
 }
 
-func preorderScore(bestTree *tree.Tree, scores [][2]int, postorderScores []int) []int {
+func preorderScore(bestTree *tree.Tree, scores [][2]int, postorderScores []int) ([]int, error) {
 	result := make([]int, len(bestTree.Edges())+1)
 	root := bestTree.Root()
+	var err error
 	bestTree.PreOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
 		if root == cur { // root has no corresponding edge
 			return true
 		}
-		if (e.Left() != cur && e.Right() != cur) || (e.Left() != prev && e.Right() != prev) {
-			panic("cur, prev and the edge e don't work the way I think")
+		if err = checkTraversal(cur, prev, e); err != nil {
+			return false
 		}
-		if p, err := cur.Parent(); err != nil {
-			panic(err)
-		} else if p != prev {
-			panic("prev is not parent of cur")
-		}
-		p, err := prev.Parent()
-		if err != nil && err.Error() == "The node has more than one parent" {
-			panic(err)
+		var p *tree.Node
+		if p, err = prev.Parent(); err != nil && err.Error() == "The node has more than one parent" {
+			err = errs.New(errs.ErrInternal, "", err)
+			return false
 		}
+		err = nil
 		if e.Left() == cur {
 			result[e.Id()] = scores[e.Id()][0]
 		} else {
 			result[e.Id()] = scores[e.Id()][1]
 		}
-		edges := preorderEdges(cur, root)
+		var edges []*tree.Edge
+		if edges, err = preorderEdges(cur, root); err != nil {
+			return false
+		}
 		for _, edge := range edges {
 			if prev == root {
 				result[e.Id()] += postorderScores[edge.Id()]
@@ -293,7 +228,7 @@ func preorderScore(bestTree *tree.Tree, scores [][2]int, postorderScores []int)
 		}
 		return true
 	})
-	return result
+	return result, err
 }
 
 // func preorderEdges(node, root *tree.Node) []*tree.Edge {
@@ -319,11 +254,11 @@ func preorderScore(bestTree *tree.Tree, scores [][2]int, postorderScores []int)
 // 	return result
 // }
 
-func preorderEdges(node, root *tree.Node) []*tree.Edge {
+func preorderEdges(node, root *tree.Node) ([]*tree.Edge, error) {
 	result := make([]*tree.Edge, 0)
 	p, err := node.Parent()
 	if err != nil {
-		panic(err)
+		return nil, errs.New(errs.ErrInternal, "", err)
 	}
 	for _, edge := range p.Edges() {
 		if edge.Left() != node && edge.Right() != node {
@@ -331,15 +266,19 @@ func preorderEdges(node, root *tree.Node) []*tree.Edge {
 		}
 	}
 	if len(result) != 2 {
-		panic(fmt.Errorf("preorder edge finder did not return the expected number of edges. Returned %d edges; root %t", len(result), p == root))
+		if p != root {
+			return nil, errs.New(errs.ErrNonBinaryTree, "", fmt.Errorf("node with %d children", len(result)))
+		}
+		return nil, errs.New(errs.ErrInternal, "", fmt.Errorf("preorder edge finder did not return the expected number of edges. Returned %d edges; root %t", len(result), p == root))
 	}
-	return result
+	return result, nil
 }
 
-func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]int {
+func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) ([2]int, error) {
 	scoresPost := make([]int, len(edgeScores)) // score if backbone ends at the index
 	postStarts := make([]int, len(edgeScores)) // edge ids for where backbone starts if it ends at the index
 	root := bestTree.Root()
+	var err error
 	bestTree.PostOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
 		if cur == root { // no edge
 			return true
@@ -350,7 +289,10 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 			postStarts[e.Id()] = e.Id()
 			return true
 		}
-		children := childEdges(cur)
+		var children []*tree.Edge
+		if children, err = childEdges(cur); err != nil {
+			return false
+		}
 		left := score + scoresPost[children[0].Id()] + post[children[1].Id()]
 		right := score + scoresPost[children[1].Id()] + post[children[0].Id()]
 		startAtCur := score + post[children[1].Id()] + post[children[0].Id()]
@@ -366,6 +308,9 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 		}
 		return true
 	})
+	if err != nil {
+		return [2]int{}, err
+	}
 	// fmt.Println("post scores", scoresPost)
 	scoresPre := make([]int, len(edgeScores))
 	preStarts := make([]int, len(edgeScores))
@@ -374,7 +319,10 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 			return true
 		}
 		score := edgeScores[e.Id()][0] + edgeScores[e.Id()][1]
-		edges := preorderEdges(cur, root)
+		var edges []*tree.Edge
+		if edges, err = preorderEdges(cur, root); err != nil {
+			return false
+		}
 		if prev == root {
 			// fmt.Printf("HERE!! %d\n", root.Nneigh())
 			left := score + scoresPost[edges[0].Id()] + post[edges[1].Id()]
@@ -388,9 +336,10 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 				preStarts[e.Id()] = postStarts[edges[1].Id()]
 			}
 		} else {
-			p, err := prev.Parent()
-			if err != nil && err.Error() == "The node has more than one parent" {
-				panic(err)
+			p, parentErr := prev.Parent()
+			if parentErr != nil && parentErr.Error() == "The node has more than one parent" {
+				err = errs.New(errs.ErrInternal, "", parentErr)
+				return false
 			}
 			if edges[0].Left() == p || edges[0].Right() == p {
 				score += scoresPre[edges[0].Id()] + post[edges[1].Id()]
@@ -403,6 +352,9 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 		}
 		return true
 	})
+	if err != nil {
+		return [2]int{}, err
+	}
 	// fmt.Println("pre scores", scoresPre)
 
 	// find maximum over all possible backbones
@@ -423,11 +375,14 @@ func findBackbone(bestTree *tree.Tree, edgeScores [][2]int, post, pre []int) [2]
 		}
 	}
 	// I need to fix the bug in the preorder preprocess bit
-	return [2]int{maxStart, maxEnd}
+	return [2]int{maxStart, maxEnd}, nil
 }
 
-func childEdges(node *tree.Node) []*tree.Edge {
-	children := children(node)
+func childEdges(node *tree.Node) ([]*tree.Edge, error) {
+	children, err := children(node)
+	if err != nil {
+		return nil, err
+	}
 	result := make([]*tree.Edge, 0)
 	for _, c := range children {
 		for _, e := range c.Edges() {
@@ -437,9 +392,9 @@ func childEdges(node *tree.Node) []*tree.Edge {
 		}
 	}
 	if len(result) != 2 {
-		panic(fmt.Sprintf("did not find two edges, found %d", len(result)))
+		return nil, errs.New(errs.ErrInternal, "", fmt.Errorf("did not find two edges, found %d", len(result)))
 	}
-	return result
+	return result, nil
 }
 
 func attachTaxa(bestTree *tree.Tree, backbone [2]int, taxaX, label string) {
@@ -472,7 +427,7 @@ func intToAlphabet(n uint) string {
 	result := ""
 	for n > 0 {
 		remainder := n % 26
-		result = string('A'+remainder) + result
+		result = string(rune('A'+remainder)) + result
 		n = n / 26
 	}
 	return result
@@ -0,0 +1,69 @@
+package cycle
+
+import (
+	"math"
+	"slices"
+
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/splits"
+)
+
+// Where a closed cycle attaches its hybrid taxon and how many sites support
+// each of its two parents, the ends of the backbone.
+type Hybrid struct {
+	Taxon    string   // taxon attached to close the cycle
+	Attached int      // sites grouping it with the end of the backbone it is attached to
+	Other    int      // sites grouping it with the other end
+	OtherEnd []string // taxa below the edge at the other end of the backbone, in the best tree; nil if the backbone is a single edge
+}
+
+// Returns the inheritance proportion of the arc from the other end of the
+// backbone, the share of its sites, and its 95% Wilson score interval. The
+// arc of the attached end has one minus that proportion. Without
+// supporting sites the proportion is 0.5 and the interval 0 to 1.
+func (h *Hybrid) Gamma() (float64, float64, float64) {
+	n := float64(h.Attached + h.Other)
+	if n == 0 {
+		return 0.5, 0, 1
+	}
+	const z = 1.959964 // 97.5% quantile of the standard normal distribution
+	p := float64(h.Other) / n
+	centre := (p + z*z/(2*n)) / (1 + z*z/n)
+	half := z / (1 + z*z/n) * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	// Mathematically the interval holds p and is within 0 to 1, but at p = 0
+	// or 1 rounding may put a bound just past it.
+	return p, max(min(centre-half, p), 0), min(max(centre+half, p), 1)
+}
+
+// Counts the sites grouping taxon x with either end of the backbone, from
+// the scores of the edges on the path between them: on each edge, those
+// putting x on the side of the start edge count for the attached end.
+func newHybrid(bestTree *tree.Tree, edgeScores [][2]int, backbone [2]int, taxon string) *Hybrid {
+	h := &Hybrid{Taxon: taxon}
+	edges := bestTree.Edges()
+	start, end := edges[backbone[0]], edges[backbone[1]]
+	below := func(a, b *tree.Edge) bool { // whether edge a is below edge b
+		return a != b && b.Bitset().IsSuperSet(a.Bitset())
+	}
+	for _, e := range edges {
+		if e != start && e != end && below(start, e) == below(end, e) {
+			continue // not on the path
+		}
+		startBelow := below(start, e)
+		if e == start {
+			startBelow = !below(end, start)
+		}
+		if startBelow { // scores[1] puts x below the edge
+			h.Attached, h.Other = h.Attached+edgeScores[e.Id()][1], h.Other+edgeScores[e.Id()][0]
+		} else {
+			h.Attached, h.Other = h.Attached+edgeScores[e.Id()][0], h.Other+edgeScores[e.Id()][1]
+		}
+	}
+	if start != end {
+		taxa := bestTree.AllTipNames()
+		slices.Sort(taxa) // bits follow the sorted taxa, see scoreEdge
+		h.OtherEnd = splits.GetClade(*end.Bitset(), taxa, false)
+	}
+	return h
+}
@@ -0,0 +1,32 @@
+package cycle
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGamma(t *testing.T) {
+	tests := []struct {
+		attached, other  int
+		gamma, low, high float64
+	}{
+		{0, 0, 0.5, 0, 1},
+		{14, 8, 0.3636, 0.1973, 0.5705},
+		{8, 14, 0.6364, 0.4295, 0.8027},
+		{3, 3, 0.5, 0.1876, 0.8124},
+		{0, 5, 1, 0.5655, 1},
+		{5, 0, 0, 0, 0.4345},
+		{1, 0, 0, 0, 0.7935},
+	}
+	for _, test := range tests {
+		h := &Hybrid{Taxon: "x", Attached: test.attached, Other: test.other}
+		gamma, low, high := h.Gamma()
+		if math.Abs(gamma-test.gamma) > 1e-4 || math.Abs(low-test.low) > 1e-4 || math.Abs(high-test.high) > 1e-4 {
+			t.Errorf("Gamma() with %d attached and %d other sites = %.4f (%.4f-%.4f), expected %.4f (%.4f-%.4f)",
+				test.attached, test.other, gamma, low, high, test.gamma, test.low, test.high)
+		}
+		if low < 0 || high > 1 || low > gamma || gamma > high {
+			t.Errorf("Gamma() with %d attached and %d other sites: interval %f-%f does not hold %f within 0-1", test.attached, test.other, low, high, gamma)
+		}
+	}
+}
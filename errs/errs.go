@@ -0,0 +1,85 @@
+// Package errs defines the kinds of error returned by the estimation
+// pipeline, and an error type recording where they happened.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of pipeline failure. Errors returned by the pipeline match one of
+// them with errors.Is.
+var (
+	ErrInvalidOption      = errors.New("invalid option")               // a setting that cannot be used, e.g. an unknown ranking
+	ErrInvalidInput       = errors.New("invalid input")                // unreadable alignment, or a setup directory that does not match it
+	ErrUnknownTaxon       = errors.New("unknown taxon")                // a taxon that is not in the alignment or SN-tree
+	ErrMissingOutput      = errors.New("missing search output")        // a subproblem has not been searched (completely) yet
+	ErrInvalidOutput      = errors.New("invalid search output")        // score or tree files that cannot be used
+	ErrNonBinaryTree      = errors.New("candidate tree is not binary") // cycles can only be closed on binary trees
+	ErrIncompatibleSplits = errors.New("splits are not compatible")    // splits that do not fit in one tree
+	ErrInternal           = errors.New("internal error")               // a broken assumption of the algorithm
+)
+
+// An error of the pipeline, with the polytomy, file and taxon it is about
+// where known.
+type Error struct {
+	Kind     error  // one of the Err values above
+	Polytomy int    // -1 if the error is not about a single polytomy
+	File     string // empty if not about a file
+	Taxon    string // empty if not about a taxon
+	Err      error  // underlying error, may be nil
+}
+
+func (e *Error) Error() string {
+	msg, cause := "", ""
+	if e.Err != nil {
+		cause = ": " + e.Err.Error()
+	}
+	if e.Polytomy >= 0 {
+		msg = fmt.Sprintf("polytomy %d: ", e.Polytomy)
+	}
+	if e.File != "" && !strings.HasPrefix(cause, ": "+e.File) { // errors of file readers often start with the file already
+		msg += e.File + ": "
+	}
+	msg += e.Kind.Error()
+	if e.Taxon != "" {
+		msg += fmt.Sprintf(" (taxon %s)", e.Taxon)
+	}
+	return msg + cause
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Returns an Error of the given kind that is not about a single polytomy.
+func New(kind error, file string, err error) *Error {
+	return &Error{Kind: kind, Polytomy: -1, File: file, Err: err}
+}
+
+// Returns an Error of the given kind about polytomy i.
+func Polytomy(kind error, i int, file string, err error) *Error {
+	return &Error{Kind: kind, Polytomy: i, File: file, Err: err}
+}
+
+// Returns the error for a taxon of polytomy i (or -1) that is not in the alignment.
+func NoSequence(i int, taxon string) *Error {
+	return &Error{Kind: ErrUnknownTaxon, Polytomy: i, Taxon: taxon, Err: errors.New("not in the alignment")}
+}
+
+// Sets the polytomy of err if it is an Error that has none yet, and
+// otherwise wraps it with the polytomy index.
+func InPolytomy(i int, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Polytomy < 0 {
+			e.Polytomy = i
+		}
+		return err
+	}
+	return fmt.Errorf("polytomy %d: %w", i, err)
+}
@@ -2,6 +2,15 @@ module lv1-netest
 
 go 1.22.4
 
+require (
+	github.com/evolbioinfo/goalign v0.3.7
+	github.com/evolbioinfo/gotree v0.4.5
+	github.com/fredericlemoine/bitset v1.2.0
+	github.com/spf13/cobra v1.5.0
+	github.com/spf13/pflag v1.0.5
+	gonum.org/v1/plot v0.14.0
+)
+
 require (
 	git.sr.ht/~sbinet/gg v0.5.0 // indirect
 	github.com/abiosoft/ishell v2.0.0+incompatible // indirect
@@ -9,11 +18,8 @@ require (
 	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b // indirect
 	github.com/armon/go-radix v1.0.0 // indirect
 	github.com/campoy/embedmd v1.0.0 // indirect
-	github.com/evolbioinfo/goalign v0.3.7 // indirect
-	github.com/evolbioinfo/gotree v0.4.5 // indirect
 	github.com/fatih/color v1.10.0 // indirect
 	github.com/flynn-archive/go-shlex v0.0.0-20150515145356-3f9db97f8568 // indirect
-	github.com/fredericlemoine/bitset v1.2.0 // indirect
 	github.com/fredericlemoine/cobrashell v0.0.0-20180921081141-49c72f93426c // indirect
 	github.com/fredericlemoine/gostats v0.1.1 // indirect
 	github.com/go-fonts/liberation v0.3.1 // indirect
@@ -26,12 +32,9 @@ require (
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/spf13/cobra v1.5.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/ulikunitz/xz v0.5.10 // indirect
 	golang.org/x/image v0.11.0 // indirect
 	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 	gonum.org/v1/gonum v0.14.0 // indirect
-	gonum.org/v1/plot v0.14.0 // indirect
 )
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/subproblem"
+)
+
+// Settings for the job array scripts of a setup directory.
+type HPCSettings struct {
+	Scheduler string
+	Dir       string // absolute setup directory, the working directory of every job
+	Scripts   string // absolute directory the scripts are written to
+	JobList   string // array index to input file mapping: line k is the input of array index k
+	Backend   string // backend of the setup; tnt runs TNT, any other PAUP*
+	NJobs     int
+	PAUP      string // PAUP* executable
+	PAUPArgs  string
+	TNT       string // TNT executable
+	TNTArgs   string
+	Finish    string // command running the finish step
+	Name      string // job name prefix
+	CPUs      int
+	Memory    string // e.g. 2G
+	Time      string // wall time, e.g. 01:00:00
+	Queue     string // partition or queue
+	Account   string
+	Throttle  int    // maximum number of array tasks running at once (0 for no limit)
+	Prelude   string // commands run before PAUP* and the finish step, e.g. module loads
+}
+
+type hpcTemplates struct {
+	array, finish, submit string
+}
+
+var hpcSchedulers = map[string]hpcTemplates{
+	"slurm": {
+		array: `#!/bin/bash
+#SBATCH --job-name={{.Name}}-paup
+#SBATCH --array=1-{{.NJobs}}{{if .Throttle}}%{{.Throttle}}{{end}}
+#SBATCH --cpus-per-task={{.CPUs}}
+{{- if .Memory}}
+#SBATCH --mem={{.Memory}}{{end}}
+{{- if .Time}}
+#SBATCH --time={{.Time}}{{end}}
+{{- if .Queue}}
+#SBATCH --partition={{.Queue}}{{end}}
+{{- if .Account}}
+#SBATCH --account={{.Account}}{{end}}
+#SBATCH --output={{.Dir}}/logs/paup_%A_%a.log
+{{template "paup" (task . "SLURM_ARRAY_TASK_ID")}}`,
+		finish: `#!/bin/bash
+#SBATCH --job-name={{.Name}}-finish
+#SBATCH --cpus-per-task=1
+{{- if .Memory}}
+#SBATCH --mem={{.Memory}}{{end}}
+{{- if .Time}}
+#SBATCH --time={{.Time}}{{end}}
+{{- if .Queue}}
+#SBATCH --partition={{.Queue}}{{end}}
+{{- if .Account}}
+#SBATCH --account={{.Account}}{{end}}
+#SBATCH --output={{.Dir}}/logs/finish_%j.log
+{{template "finish" .}}`,
+		submit: `#!/bin/bash
+set -euo pipefail
+mkdir -p {{quote .Dir}}/logs
+cd {{quote .Scripts}}
+array=$(sbatch --parsable paup_array.slurm.sh)
+sbatch --dependency=afterok:${array%%;*} finish.slurm.sh
+`,
+	},
+	"pbs": {
+		array: `#!/bin/bash
+#PBS -N {{.Name}}-paup
+#PBS -J 1-{{.NJobs}}{{if .Throttle}}%{{.Throttle}}{{end}}
+#PBS -l select=1:ncpus={{.CPUs}}{{if .Memory}}:mem={{.Memory}}{{end}}
+{{- if .Time}}
+#PBS -l walltime={{.Time}}{{end}}
+{{- if .Queue}}
+#PBS -q {{.Queue}}{{end}}
+{{- if .Account}}
+#PBS -A {{.Account}}{{end}}
+#PBS -j oe
+#PBS -o {{.Dir}}/logs/
+{{template "paup" (task . "PBS_ARRAY_INDEX")}}`,
+		finish: `#!/bin/bash
+#PBS -N {{.Name}}-finish
+#PBS -l select=1:ncpus=1{{if .Memory}}:mem={{.Memory}}{{end}}
+{{- if .Time}}
+#PBS -l walltime={{.Time}}{{end}}
+{{- if .Queue}}
+#PBS -q {{.Queue}}{{end}}
+{{- if .Account}}
+#PBS -A {{.Account}}{{end}}
+#PBS -j oe
+#PBS -o {{.Dir}}/logs/
+{{template "finish" .}}`,
+		submit: `#!/bin/bash
+set -euo pipefail
+mkdir -p {{quote .Dir}}/logs
+cd {{quote .Scripts}}
+array=$(qsub paup_array.pbs.sh)
+qsub -W depend=afterok:$array finish.pbs.sh
+`,
+	},
+	"sge": {
+		array: `#!/bin/bash
+#$ -N {{.Name}}-paup
+#$ -t 1-{{.NJobs}}
+{{- if .Throttle}}
+#$ -tc {{.Throttle}}{{end}}
+{{- if gt .CPUs 1}}
+#$ -pe smp {{.CPUs}}{{end}}
+{{- if .Memory}}
+#$ -l h_vmem={{.Memory}}{{end}}
+{{- if .Time}}
+#$ -l h_rt={{.Time}}{{end}}
+{{- if .Queue}}
+#$ -q {{.Queue}}{{end}}
+{{- if .Account}}
+#$ -P {{.Account}}{{end}}
+#$ -cwd
+#$ -j y
+#$ -o {{.Dir}}/logs/
+{{template "paup" (task . "SGE_TASK_ID")}}`,
+		finish: `#!/bin/bash
+#$ -N {{.Name}}-finish
+{{- if .Memory}}
+#$ -l h_vmem={{.Memory}}{{end}}
+{{- if .Time}}
+#$ -l h_rt={{.Time}}{{end}}
+{{- if .Queue}}
+#$ -q {{.Queue}}{{end}}
+{{- if .Account}}
+#$ -P {{.Account}}{{end}}
+#$ -cwd
+#$ -j y
+#$ -o {{.Dir}}/logs/
+{{template "finish" .}}`,
+		submit: `#!/bin/bash
+set -euo pipefail
+mkdir -p {{quote .Dir}}/logs
+cd {{quote .Scripts}}
+array=$(qsub -terse paup_array.sge.sh)
+qsub -hold_jid ${array%%.*} finish.sge.sh
+`,
+	},
+}
+
+// bodies shared by all schedulers
+const hpcCommon = `
+{{define "paup"}}
+set -euo pipefail
+{{.Prelude}}
+cd {{quote .Dir}}
+file=$(sed -n "${ {{- .Task -}} }p" {{quote .JobList}})
+if [ -z "$file" ]; then
+	echo "no input for array index ${ {{- .Task -}} } in {{.JobList}}" >&2
+	exit 1
+fi
+{{if eq .Backend "tnt" -}}
+{{quote .TNT}} {{.TNTArgs}} proc "$file;"
+{{- else -}}
+{{quote .PAUP}} {{.PAUPArgs}} "$file"
+{{- end}}
+{{end}}
+{{define "finish"}}
+set -euo pipefail
+{{.Prelude}}
+cd {{quote .Dir}}
+{{.Finish}}
+{{end}}`
+
+type hpcTask struct {
+	HPCSettings
+	Task string // environment variable with the array index
+}
+
+// Writes the mapping file and the array, finish and submit scripts into
+// settings.Scripts. Returns the names of the files written.
+func WriteHPCScripts(settings HPCSettings, nexFiles []string) ([]string, error) {
+	templates, exists := hpcSchedulers[settings.Scheduler]
+	if !exists {
+		return nil, fmt.Errorf("unknown scheduler %q (expected one of %s)", settings.Scheduler, strings.Join(hpcSchedulerNames(), ", "))
+	}
+	if len(nexFiles) == 0 {
+		return nil, errors.New("there are no runs left to do")
+	}
+	settings.NJobs = len(nexFiles)
+	funcs := template.FuncMap{
+		"quote": shellQuote,
+		"task":  func(s HPCSettings, variable string) hpcTask { return hpcTask{s, variable} },
+	}
+	written := make([]string, 0)
+	write := func(name, content string, mode os.FileMode) error {
+		written = append(written, filepath.Join(settings.Scripts, name))
+		return os.WriteFile(filepath.Join(settings.Scripts, name), []byte(content), mode)
+	}
+	relative := make([]string, len(nexFiles))
+	for k, file := range nexFiles {
+		relative[k] = filepath.Base(file)
+	}
+	if err := write(filepath.Base(settings.JobList), strings.Join(relative, "\n")+"\n", 0644); err != nil {
+		return written, err
+	}
+	for _, script := range []struct{ name, text string }{
+		{fmt.Sprintf("paup_array.%s.sh", settings.Scheduler), templates.array},
+		{fmt.Sprintf("finish.%s.sh", settings.Scheduler), templates.finish},
+		{fmt.Sprintf("submit_%s.sh", settings.Scheduler), templates.submit},
+	} {
+		t, err := template.New(script.name).Funcs(funcs).Parse(script.text + hpcCommon)
+		if err != nil { // only happens if the built in templates are broken
+			panic(err)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, settings); err != nil {
+			return written, fmt.Errorf("could not render %s: %w", script.name, err)
+		}
+		if err := write(script.name, b.String(), 0755); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func newHPCCommand() *cobra.Command {
+	var polytomyDir, alnFile, exe, outdir string
+	settings := HPCSettings{}
+	cmd := &cobra.Command{
+		Use:   "hpc -d DIR",
+		Short: "Write job array scripts running the PAUP* runs of a setup directory on a cluster",
+		Long: `Writes a SLURM, PBS or SGE job array running PAUP* on every subproblem of a
+setup directory that is not complete yet, a job running the finish step once
+the array is done, and a script submitting both. Directories set up with
+--backend tnt are run with TNT instead.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			if _, known := hpcSchedulers[settings.Scheduler]; !known {
+				return usageError{fmt.Errorf("unknown scheduler %q (expected one of %s)", settings.Scheduler, strings.Join(hpcSchedulerNames(), ", "))}
+			}
+			dir, err := filepath.Abs(polytomyDir)
+			if err != nil {
+				return err
+			}
+			manifest, err := subproblem.ReadManifest(dir)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if alnFile == "" {
+				if manifest == nil {
+					return usageError{fmt.Errorf("%s has no %s, so --alignment is required", dir, subproblem.ManifestFile)}
+				}
+				alnFile = manifest.Alignment.File
+			}
+			if alnFile, err = filepath.Abs(alnFile); err != nil {
+				return err
+			}
+			if exe == "" {
+				if exe, err = os.Executable(); err != nil {
+					return err
+				}
+			}
+			if outdir == "" {
+				outdir = dir
+			}
+			scripts, err := filepath.Abs(outdir)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(scripts, 0755); err != nil {
+				return err
+			}
+			settings.Backend = subproblem.SetupBackend(manifest).Name()
+			if settings.Backend == "iqtree" || settings.Backend == "raxml" {
+				return fmt.Errorf("%s is set up for the %s backend, which hpc cannot run; use serve and worker instead", dir, settings.Backend)
+			}
+			cache, err := subproblem.OpenSetupCache(dir, "")
+			if err != nil {
+				return err
+			}
+			jobs, err := subproblem.Status(dir, cache)
+			if err != nil {
+				return err
+			}
+			pending := make([]string, 0)
+			for _, job := range jobs {
+				if !job.Done() {
+					pending = append(pending, job.Input)
+				}
+			}
+			settings.Dir, settings.Scripts = dir, scripts
+			settings.JobList = filepath.Join(scripts, fmt.Sprintf("jobs_%s.txt", settings.Scheduler))
+			settings.Finish = fmt.Sprintf("%s finish -a %s -d %s", shellQuote(exe), shellQuote(alnFile), shellQuote(dir))
+			written, err := WriteHPCScripts(settings, pending)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d %s runs written to %s\n", len(pending), hpcProgram(settings.Backend), strings.Join(written, ", "))
+			fmt.Printf("submit with: bash %s\n", written[len(written)-1])
+			return nil
+		}),
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&polytomyDir, "dir", "d", "", "directory created by setup")
+	flags.StringVar(&settings.Scheduler, "scheduler", "slurm", "scheduler to write scripts for: "+strings.Join(hpcSchedulerNames(), ", "))
+	flags.StringVarP(&alnFile, "alignment", "a", "", "alignment file for the finish job (default: the one recorded in the manifest)")
+	flags.StringVar(&settings.PAUP, "paup", "paup4a168_centos64", "PAUP* executable on the compute nodes")
+	flags.StringVar(&settings.PAUPArgs, "paup-args", "-n", "arguments passed to PAUP* before the input file")
+	flags.StringVar(&settings.TNT, "tnt", "tnt", "TNT executable on the compute nodes, for directories set up with --backend tnt")
+	flags.StringVar(&settings.TNTArgs, "tnt-args", "bground", "arguments passed to TNT before the command running the run script")
+	flags.StringVar(&exe, "exe", "", "lv1-netest executable for the finish job (default: this executable)")
+	flags.StringVar(&settings.Name, "name", "lv1", "job name prefix")
+	flags.IntVar(&settings.CPUs, "cpus", 1, "cpus per PAUP* run")
+	flags.StringVar(&settings.Memory, "mem", "", "memory per job, e.g. 2G")
+	flags.StringVar(&settings.Time, "time", "", "wall time per job, e.g. 01:00:00")
+	flags.StringVar(&settings.Queue, "queue", "", "partition or queue")
+	flags.StringVar(&settings.Account, "account", "", "account or project to charge")
+	flags.IntVar(&settings.Throttle, "throttle", 0, "maximum number of PAUP* runs at once (0 for no limit)")
+	flags.StringVar(&settings.Prelude, "prelude", "", "commands run at the start of every job, e.g. \"module load paup\"")
+	flags.StringVarP(&outdir, "output", "o", "", "directory for the scripts (default: the setup directory)")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagDirname("dir")
+	cmd.RegisterFlagCompletionFunc("scheduler", completeValues(hpcSchedulerNames()...))
+	return cmd
+}
+
+// Name of the program the array runs for a backend.
+func hpcProgram(backend string) string {
+	if backend == "tnt" {
+		return "TNT"
+	}
+	return "PAUP*"
+}
+
+func hpcSchedulerNames() []string {
+	names := make([]string, 0, len(hpcSchedulers))
+	for name := range hpcSchedulers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes a fake scheduler command to bin that logs its arguments to log and
+// prints reply, as a submission prints the job ID.
+func fakeScheduler(t *testing.T, bin, name, reply, log string) {
+	t.Helper()
+	script := "#!/bin/sh\necho \"" + name + " $*\" >> " + shellQuote(log) + "\necho " + shellQuote(reply) + "\n"
+	if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteHPCScripts(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	tests := []struct {
+		scheduler, backend string
+		task               string // variable holding the array index
+		reply              string // what submitting the array prints
+		array, finish      []string
+		submitted          []string // commands the submit script runs, in order
+		ran                string   // what the array task runs for index 2
+	}{
+		{
+			scheduler: "slurm", task: "SLURM_ARRAY_TASK_ID", reply: "42;cluster",
+			array:     []string{"#SBATCH --job-name=test-paup", "#SBATCH --array=1-3%2", "#SBATCH --mem=2G", "#SBATCH --partition=short"},
+			finish:    []string{"#SBATCH --job-name=test-finish", "#SBATCH --mem=2G"},
+			submitted: []string{"sbatch --parsable paup_array.slurm.sh", "sbatch --dependency=afterok:42 finish.slurm.sh"},
+			ran:       "run b.nex",
+		},
+		{
+			scheduler: "pbs", task: "PBS_ARRAY_INDEX", reply: "42[].server",
+			array:     []string{"#PBS -N test-paup", "#PBS -J 1-3%2", "#PBS -l select=1:ncpus=1:mem=2G", "#PBS -q short"},
+			finish:    []string{"#PBS -N test-finish"},
+			submitted: []string{"qsub paup_array.pbs.sh", "qsub -W depend=afterok:42[].server finish.pbs.sh"},
+			ran:       "run b.nex",
+		},
+		{
+			scheduler: "sge", task: "SGE_TASK_ID", reply: "42.1-3:1",
+			array:     []string{"#$ -N test-paup", "#$ -t 1-3", "#$ -tc 2", "#$ -l h_vmem=2G", "#$ -q short"},
+			finish:    []string{"#$ -N test-finish"},
+			submitted: []string{"qsub -terse paup_array.sge.sh", "qsub -hold_jid 42 finish.sge.sh"},
+			ran:       "run b.nex",
+		},
+		{
+			scheduler: "slurm", backend: "tnt", task: "SLURM_ARRAY_TASK_ID", reply: "7",
+			submitted: []string{"sbatch --parsable paup_array.slurm.sh", "sbatch --dependency=afterok:7 finish.slurm.sh"},
+			ran:       "bground proc b.tnt;",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.scheduler+"/"+test.backend, func(t *testing.T) {
+			dir, scripts, bin := t.TempDir(), t.TempDir(), t.TempDir()
+			settings := HPCSettings{
+				Scheduler: test.scheduler, Dir: dir, Scripts: scripts, JobList: filepath.Join(scripts, "jobs.txt"),
+				Backend: test.backend, PAUP: "echo", PAUPArgs: "run", TNT: "echo", TNTArgs: "bground",
+				Finish: "echo finished", Name: "test", CPUs: 1, Memory: "2G", Queue: "short", Throttle: 2,
+			}
+			inputs := []string{filepath.Join(dir, "a.nex"), filepath.Join(dir, "b.nex"), filepath.Join(dir, "c.nex")}
+			if test.backend == "tnt" {
+				inputs = []string{filepath.Join(dir, "a.tnt"), filepath.Join(dir, "b.tnt"), filepath.Join(dir, "c.tnt")}
+			}
+			written, err := WriteHPCScripts(settings, inputs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(written) != 4 {
+				t.Fatalf("wrote %v, expected the job list and 3 scripts", written)
+			}
+			ext := filepath.Ext(inputs[0])
+			if list := readFile(t, filepath.Join(scripts, "jobs.txt")); list != "a"+ext+"\nb"+ext+"\nc"+ext+"\n" {
+				t.Errorf("job list is %q", list)
+			}
+			array := readFile(t, filepath.Join(scripts, "paup_array."+test.scheduler+".sh"))
+			finish := readFile(t, filepath.Join(scripts, "finish."+test.scheduler+".sh"))
+			for _, line := range test.array {
+				if !strings.Contains(array, line+"\n") {
+					t.Errorf("array script has no line %q:\n%s", line, array)
+				}
+			}
+			for _, line := range test.finish {
+				if !strings.Contains(finish, line+"\n") {
+					t.Errorf("finish script has no line %q:\n%s", line, finish)
+				}
+			}
+
+			task := exec.Command("bash", filepath.Join(scripts, "paup_array."+test.scheduler+".sh"))
+			task.Env = append(os.Environ(), test.task+"=2")
+			if out, err := task.CombinedOutput(); err != nil {
+				t.Errorf("array task failed: %v\n%s", err, out)
+			} else if got := strings.TrimSpace(string(out)); got != test.ran {
+				t.Errorf("array task 2 ran %q, expected %q", got, test.ran)
+			}
+			missing := exec.Command("bash", filepath.Join(scripts, "paup_array."+test.scheduler+".sh"))
+			missing.Env = append(os.Environ(), test.task+"=4")
+			if err := missing.Run(); err == nil {
+				t.Error("array task 4, which has no input, succeeded")
+			}
+			if out, err := exec.Command("bash", filepath.Join(scripts, "finish."+test.scheduler+".sh")).CombinedOutput(); err != nil || strings.TrimSpace(string(out)) != "finished" {
+				t.Errorf("finish script printed %q (%v)", out, err)
+			}
+
+			log := filepath.Join(t.TempDir(), "submitted.log")
+			for _, command := range []string{"sbatch", "qsub"} {
+				fakeScheduler(t, bin, command, test.reply, log)
+			}
+			submit := exec.Command("bash", filepath.Join(scripts, "submit_"+test.scheduler+".sh"))
+			submit.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			if out, err := submit.CombinedOutput(); err != nil {
+				t.Fatalf("submit script failed: %v\n%s", err, out)
+			}
+			if got := strings.Split(strings.TrimSpace(readFile(t, log)), "\n"); strings.Join(got, "\n") != strings.Join(test.submitted, "\n") {
+				t.Errorf("submit script ran\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(test.submitted, "\n"))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "logs")); err != nil {
+				t.Errorf("submit script did not create the log directory: %v", err)
+			}
+		})
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestWriteHPCScriptsErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduler string
+		inputs    []string
+		want      string
+	}{
+		{"unknown scheduler", "lsf", []string{"a.nex"}, `unknown scheduler "lsf"`},
+		{"nothing to run", "slurm", nil, "no runs left"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scripts := t.TempDir()
+			settings := HPCSettings{Scheduler: test.scheduler, Scripts: scripts, JobList: filepath.Join(scripts, "jobs.txt")}
+			if _, err := WriteHPCScripts(settings, test.inputs); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, expected one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"paup4a168_centos64", "paup4a168_centos64"},
+		{"/opt/paup/bin/paup", "/opt/paup/bin/paup"},
+		{"", "''"},
+		{"my dir", "'my dir'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.in); got != test.want {
+			t.Errorf("shellQuote(%q) = %q, expected %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Package atomicfile writes files so that readers never see them partially written.
+package atomicfile
+
+import "os"
+
+// Writes a file under a temporary name first, so an interrupted run never leaves a partial file.
+func Write(name string, content []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
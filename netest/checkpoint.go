@@ -0,0 +1,268 @@
+package netest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/cycle"
+	"lv1-netest/errs"
+	"lv1-netest/internal/atomicfile"
+	"lv1-netest/network"
+	"lv1-netest/sntree"
+	"lv1-netest/subproblem"
+)
+
+// Intermediate results of the finish step, kept in the "finish" subdirectory
+// of a setup directory so that a rerun resumes from the last completed stage.
+// Each polytomy goes through the stages below in order.
+type Checkpoint struct {
+	dir string
+}
+
+const (
+	stageBest       = "best.nwk"       // best candidate tree chosen from the search output
+	stageCandidates = "candidates.tsv" // scores of every candidate tree, written with the best tree
+	stageCycle      = "cycle.nwk"      // best tree with the removed taxon attached
+	stageHybrid     = "hybrid.tsv"     // support of the two parents of the removed taxon, written with the cycle
+	stageSplits     = "splits.txt"     // splits of the cycle expanded to the SN-tree taxa, one per line
+)
+
+var stages = []string{stageBest, stageCandidates, stageCycle, stageHybrid, stageSplits}
+
+const checkpointSettings = "settings.txt"
+
+// Opens the checkpoint of a setup directory. Checkpoints written with other
+// settings (e.g. a different ranking) are discarded, which is noted on log.
+func OpenCheckpoint(polytomyDir, settings string, log io.Writer) (*Checkpoint, error) {
+	c := &Checkpoint{dir: filepath.Join(polytomyDir, "finish")}
+	previous, err := os.ReadFile(filepath.Join(c.dir, checkpointSettings))
+	if err == nil && string(previous) == settings {
+		return c, nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		fmt.Fprintf(log, "finish settings changed (was %q), discarding checkpoint...\n", string(previous))
+	}
+	if err := os.RemoveAll(c.dir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(c.dir, 0755); err != nil {
+		return nil, err
+	}
+	return c, atomicfile.Write(filepath.Join(c.dir, checkpointSettings), []byte(settings))
+}
+
+func (c *Checkpoint) path(p sntree.Polytomy, stage string) string {
+	return filepath.Join(c.dir, p.Label()+"_"+stage)
+}
+
+func (c *Checkpoint) Has(p sntree.Polytomy, stage string) bool {
+	_, err := os.Stat(c.path(p, stage))
+	return err == nil
+}
+
+// Returns whether the candidates of polytomy p and its best tree are in the
+// checkpoint, so that its search output need not be read again.
+func (c *Checkpoint) HasCandidates(p sntree.Polytomy) bool {
+	return c.Has(p, stageBest) && c.Has(p, stageCandidates)
+}
+
+// Removes every stage of polytomy p, so that it is recomputed.
+func (c *Checkpoint) Reset(p sntree.Polytomy) error {
+	for _, stage := range stages {
+		if err := os.Remove(c.path(p, stage)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if search output for polytomy p is newer than its best tree checkpoint.
+func (c *Checkpoint) Stale(p sntree.Polytomy, polytomyDir string) (bool, error) {
+	best, err := os.Stat(c.path(p, stageBest))
+	if err != nil {
+		return false, nil
+	}
+	outputs, err := filepath.Glob(filepath.Join(polytomyDir, p.Label()+"_*_*"))
+	if err != nil {
+		return false, err
+	}
+	for _, output := range outputs {
+		if info, err := os.Stat(output); err == nil && info.ModTime().After(best.ModTime()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Runs the finish step for polytomy i, reusing completed stages, and returns
+// its cycle.
+func FinishPolytomy(c *Checkpoint, polytomyDir string, i int, p sntree.Polytomy, ranking subproblem.Ranking, backend subproblem.SearchBackend, aln align.Alignment, snTree *tree.Tree) (*Cycle, error) {
+	splits, computed, err := finishPolytomy(c, polytomyDir, i, p, ranking, backend, aln, snTree)
+	if err != nil {
+		return nil, errs.InPolytomy(i, err)
+	}
+	candidates, err := subproblem.ReadCandidateSummary(c.path(p, stageCandidates))
+	if err != nil {
+		return nil, errs.InPolytomy(i, err)
+	}
+	chosen := slices.IndexFunc(candidates, func(c *subproblem.CandidateScores) bool { return c.Chosen })
+	if chosen == -1 {
+		return nil, errs.InPolytomy(i, fmt.Errorf("%s has no chosen candidate", c.path(p, stageCandidates)))
+	}
+	closed, err := network.ReadTreeIndexed(c.path(p, stageCycle))
+	if err != nil {
+		return nil, errs.InPolytomy(i, err)
+	}
+	hybrid, err := readHybrid(c.path(p, stageHybrid))
+	if err != nil {
+		return nil, errs.InPolytomy(i, err)
+	}
+	return &Cycle{Polytomy: i, ID: p.ID, Taxa: p.Taxa, Candidates: candidates, Chosen: candidates[chosen], Tree: closed, Hybrid: hybrid, Splits: splits, Computed: computed}, nil
+}
+
+func finishPolytomy(c *Checkpoint, polytomyDir string, i int, p sntree.Polytomy, ranking subproblem.Ranking, backend subproblem.SearchBackend, aln align.Alignment, snTree *tree.Tree) ([][]string, []string, error) {
+	computed := make([]string, 0)
+	if !c.HasCandidates(p) {
+		if err := c.Reset(p); err != nil {
+			return nil, computed, err
+		}
+		bestTree, candidates, err := subproblem.ReadPolytomy(polytomyDir, i, p, ranking, backend)
+		if err != nil {
+			return nil, computed, err
+		}
+		tmp := c.path(p, stageCandidates) + ".tmp"
+		if err := subproblem.WriteCandidateSummary(tmp, [][]*subproblem.CandidateScores{candidates}, ""); err != nil {
+			return nil, computed, err
+		}
+		if err := os.Rename(tmp, c.path(p, stageCandidates)); err != nil {
+			return nil, computed, err
+		}
+		if err := writeTreeAtomic(c.path(p, stageBest), bestTree); err != nil {
+			return nil, computed, err
+		}
+		computed = append(computed, "best tree")
+	}
+	if !c.Has(p, stageCycle) || !c.Has(p, stageHybrid) {
+		// the best tree is always read back, so a resumed run sees exactly the same tree
+		best, err := network.ReadTreeIndexed(c.path(p, stageBest))
+		if err != nil {
+			return nil, computed, err
+		}
+		closed, hybrid, err := cycle.Close(best, p.Taxa, aln, i)
+		if err != nil {
+			return nil, computed, err
+		}
+		if err := writeTreeAtomic(c.path(p, stageCycle), closed); err != nil {
+			return nil, computed, err
+		}
+		if err := writeHybrid(c.path(p, stageHybrid), hybrid); err != nil {
+			return nil, computed, err
+		}
+		computed = append(computed, "cycle")
+	}
+	if !c.Has(p, stageSplits) {
+		closed, err := network.ReadTreeIndexed(c.path(p, stageCycle))
+		if err != nil {
+			return nil, computed, err
+		}
+		splits, err := network.ExpandCycleSplits(snTree, closed, i, p.ID)
+		if err != nil {
+			return nil, computed, err
+		}
+		lines := make([]string, len(splits))
+		for k, s := range splits {
+			lines[k] = strings.Join(s, "\t") + "\n"
+		}
+		if err := atomicfile.Write(c.path(p, stageSplits), []byte(strings.Join(lines, ""))); err != nil {
+			return nil, computed, err
+		}
+		computed = append(computed, "splits")
+	}
+	content, err := os.ReadFile(c.path(p, stageSplits))
+	if err != nil {
+		return nil, computed, err
+	}
+	splits := make([][]string, 0)
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if line != "" {
+			splits = append(splits, strings.Split(line, "\t"))
+		}
+	}
+	return splits, computed, nil
+}
+
+// Concatenates the candidate tables of every polytomy into one summary table.
+func MergeCandidateSummaries(c *Checkpoint, name string, polytomies []sntree.Polytomy, comment string) error {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	var header, first string
+	for _, p := range polytomies {
+		content, err := os.ReadFile(c.path(p, stageCandidates))
+		if err != nil {
+			return err
+		}
+		lines := strings.SplitAfterN(string(content), "\n", 2)
+		if header == "" {
+			header, first = lines[0], c.path(p, stageCandidates)
+			b.WriteString(header)
+		} else if lines[0] != header {
+			return fmt.Errorf("%s has header %q, but %s has %q", c.path(p, stageCandidates), strings.TrimSpace(lines[0]), first, strings.TrimSpace(header))
+		}
+		if len(lines) == 2 {
+			b.WriteString(lines[1])
+		}
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
+
+func writeHybrid(name string, h *cycle.Hybrid) error {
+	content := fmt.Sprintf("taxon\tattached\tother\tother_end\n%s\t%d\t%d\t%s\n", h.Taxon, h.Attached, h.Other, strings.Join(h.OtherEnd, ","))
+	return atomicfile.Write(name, []byte(content))
+}
+
+func readHybrid(name string) (*cycle.Hybrid, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	var fields []string
+	if len(lines) == 2 {
+		fields = strings.Split(lines[1], "\t")
+	}
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("%s: expected a header and one row of 4 fields", name)
+	}
+	h := &cycle.Hybrid{Taxon: fields[0]}
+	if h.Attached, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if h.Other, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if fields[3] != "" {
+		h.OtherEnd = strings.Split(fields[3], ",")
+	}
+	return h, nil
+}
+
+func writeTreeAtomic(name string, t *tree.Tree) error {
+	if err := atomicfile.Write(name, []byte(t.Newick())); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package netest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"lv1-netest/network"
+)
+
+// Adds to the network the reticulation of each cycle, from the other end of
+// its backbone to the node above its hybrid taxon, tagged #H1, #H2... in
+// polytomy order. A cycle whose reticulation cannot be added, or would make
+// the network other than level-1, keeps only its splits.
+func addReticulations(result *Result, log io.Writer) error {
+	for _, c := range result.Cycles {
+		h := c.Hybrid
+		hybrid, err := network.ExpandClade(result.SNTree, []string{h.Taxon}, c.Polytomy, c.ID)
+		if err != nil {
+			return err
+		}
+		slices.Sort(hybrid)
+		c.HybridTaxa = hybrid
+		if h.OtherEnd == nil {
+			fmt.Fprintf(log, "warning: polytomy %d (%s): no reticulation for %s: both of its parents are on the same edge\n", c.Polytomy, c.ID, h.Taxon)
+			continue
+		}
+		other, err := network.ExpandClade(result.SNTree, h.OtherEnd, c.Polytomy, c.ID)
+		if err != nil {
+			return err
+		}
+		tag := fmt.Sprintf("#H%d", reticulations(result)+1)
+		net, err := network.AddReticulation(result.Network, hybrid, other, tag)
+		if err == nil {
+			var networks []*network.Network
+			if networks, err = network.ParseNetworks(network.Newick(net, nil)); err == nil {
+				if report := network.Validate(networks[0]); !report.Holds(network.Level1) {
+					err = fmt.Errorf("the network would be level-%d", report.Level)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(log, "warning: polytomy %d (%s): no reticulation for %s: %v\n", c.Polytomy, c.ID, h.Taxon, err)
+			continue
+		}
+		result.Network, c.Reticulation = net, tag
+	}
+	return nil
+}
+
+// Returns the number of reticulations added to the network.
+func reticulations(result *Result) int {
+	count := 0
+	for _, c := range result.Cycles {
+		if c.Reticulation != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the inheritance probability of the arc of each reticulation from
+// the other end of its backbone, by #tag.
+func gammas(result *Result) map[string]float64 {
+	gammas := make(map[string]float64)
+	for _, c := range result.Cycles {
+		if c.Reticulation != "" {
+			gammas[c.Reticulation], _, _ = c.Hybrid.Gamma()
+		}
+	}
+	return gammas
+}
+
+// Writes the support of the parents of the hybrid taxon of each cycle and
+// the inheritance probability it gives, preceded by comment if it is not
+// empty.
+func writeHybrids(name string, cycles []*Cycle, comment string) error {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	b.WriteString("polytomy\tpolytomy_id\treticulation\thybrid\thybrid_taxa\tattached\tother\tgamma\tgamma_low\tgamma_high\n")
+	for _, c := range cycles {
+		gamma, low, high := c.Hybrid.Gamma()
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%.3f\t%.3f\t%.3f\n", c.Polytomy, c.ID, c.Reticulation, c.Hybrid.Taxon,
+			strings.Join(c.HybridTaxa, ","), c.Hybrid.Attached, c.Hybrid.Other, gamma, low, high)
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
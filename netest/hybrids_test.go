@@ -0,0 +1,126 @@
+package netest
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"lv1-netest/cycle"
+	"lv1-netest/network"
+)
+
+// Adds the reticulations of the cycles of result again, to the network of
+// their splits, and returns the log.
+func readdReticulations(t *testing.T, result *Result) string {
+	t.Helper()
+	var splits [][]string
+	for _, c := range result.Cycles {
+		splits = append(splits, c.Splits...)
+		c.Reticulation, c.HybridTaxa = "", nil
+	}
+	var err error
+	if result.Network, err = network.AddSplits(result.SNTree.Clone(), splits); err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	if err := addReticulations(result, &log); err != nil {
+		t.Fatal(err)
+	}
+	return log.String()
+}
+
+func TestAddReticulations(t *testing.T) {
+	tests := []struct {
+		alignment string
+		support   [][2]int // sites supporting the attached and the other end, by cycle
+		newick    string
+		gammas    map[string]float64
+		hybrids   string
+	}{
+		{"cycle.nex", [][2]int{{3, 1}},
+			"(taxon_1:1,(taxon_2:1,(taxon_3:1,(taxon_6)#H1:1::0.750)1:1)1:1,(taxon_4:1,(#H1:1::0.250,taxon_5:0.5):0.5)1:1)polytomy_79fdde35b08287a4;",
+			map[string]float64{"#H1": 0.25},
+			"0\t79fdde35b08287a4\t#H1\ttaxon_6\ttaxon_6\t3\t1\t0.250\t0.046\t0.699\n"},
+		{"twocyclesfull.nex", [][2]int{{1, 4}, {0, 0}},
+			"(taxon_1:1,(taxon_2:1,(taxon_3:1,((taxon_6:1,((taxon_10)#H1:1::0.200,taxon_7:1)1:1)polytomy_96536f0992899d67:1,(taxon_8:1,(#H1:1::0.800,taxon_9:0.5):0.5)1:1)#H2:1::0.500)1:1)1:1,(taxon_4:1,(#H2:1::0.500,taxon_5:0.5):0.5)1:1)polytomy_9f6acc5e3ccf7500;",
+			map[string]float64{"#H1": 0.8, "#H2": 0.5},
+			"0\t96536f0992899d67\t#H1\ttaxon_10\ttaxon_10\t1\t4\t0.800\t0.376\t0.964\n" +
+				"1\t9f6acc5e3ccf7500\t#H2\ttaxon_10\ttaxon_10,taxon_6,taxon_7,taxon_8,taxon_9\t0\t0\t0.500\t0.000\t1.000\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.alignment, func(t *testing.T) {
+			result, _ := estimate(t, test.alignment)
+			if len(result.Cycles) != len(test.support) {
+				t.Fatalf("%d cycles, expected %d", len(result.Cycles), len(test.support))
+			}
+			for i, c := range result.Cycles {
+				c.Hybrid.Attached, c.Hybrid.Other = test.support[i][0], test.support[i][1]
+			}
+			if log := readdReticulations(t, result); log != "" {
+				t.Errorf("log %q", log)
+			}
+			if got := result.Newick(); got != test.newick {
+				t.Errorf("network %s, expected %s", got, test.newick)
+			}
+			got := gammas(result)
+			if len(got) != len(test.gammas) {
+				t.Errorf("inheritance probabilities %v, expected %v", got, test.gammas)
+			}
+			for tag, gamma := range test.gammas {
+				if g, ok := got[tag]; !ok || g-gamma > 1e-9 || gamma-g > 1e-9 {
+					t.Errorf("inheritance probabilities %v, expected %v", got, test.gammas)
+				}
+			}
+			file := filepath.Join(t.TempDir(), "hybrids.tsv")
+			if err := writeHybrids(file, result.Cycles, "lv1-netest test"); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "# lv1-netest test\npolytomy\tpolytomy_id\treticulation\thybrid\thybrid_taxa\tattached\tother\tgamma\tgamma_low\tgamma_high\n" + test.hybrids
+			if string(content) != want {
+				t.Errorf("hybrids.tsv is\n%s\nexpected\n%s", content, want)
+			}
+		})
+	}
+}
+
+func TestAddReticulationsFallback(t *testing.T) {
+	// Each extra cycle of polytomy 0 comes after its own cycle, whose
+	// reticulation #H1 is added.
+	tests := []struct {
+		name   string
+		hybrid cycle.Hybrid
+		log    string
+	}{
+		{"backbone of one edge", cycle.Hybrid{Taxon: "taxon_3"}, "no reticulation for taxon_3: both of its parents are on the same edge"},
+		{"level-2", cycle.Hybrid{Taxon: "taxon_3", OtherEnd: []string{"taxon_4"}}, "no reticulation for taxon_3: the network would be level-2"},
+		{"hybrid taxon already a reticulation", cycle.Hybrid{Taxon: "taxon_6", OtherEnd: []string{"taxon_4"}}, "no reticulation for taxon_6: the node above the hybrid taxa taxon_6 is already a reticulation"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := estimate(t, "cycle.nex")
+			want := result.Newick()
+			extra := *result.Cycles[0]
+			extra.Hybrid = &test.hybrid
+			result.Cycles = append(result.Cycles, &extra)
+			log := readdReticulations(t, result)
+			if !strings.Contains(log, "warning: polytomy 0 (79fdde35b08287a4): "+test.log) {
+				t.Errorf("log %q, expected a warning containing %q", log, test.log)
+			}
+			if result.Cycles[0].Reticulation != "#H1" || extra.Reticulation != "" {
+				t.Errorf("reticulations %q and %q, expected #H1 and none", result.Cycles[0].Reticulation, extra.Reticulation)
+			}
+			if !slices.Equal(extra.HybridTaxa, []string{test.hybrid.Taxon}) {
+				t.Errorf("hybrid taxa %v, expected %s", extra.HybridTaxa, test.hybrid.Taxon)
+			}
+			if got := result.Newick(); got != want {
+				t.Errorf("network %s, expected the one without the extra cycle, %s", got, want)
+			}
+		})
+	}
+}
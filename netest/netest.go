@@ -0,0 +1,508 @@
+// Package netest estimates a level-1 phylogenetic network from an alignment
+// of two-state characters: it builds the SN-tree, sets up and solves the
+// leave-one-out subproblems of its polytomies, closes a cycle at each of them
+// and adds the cycles to the SN-tree.
+//
+// Estimate runs every step. Setup and Finish run the steps before and after
+// the subproblem searches, for searches run elsewhere.
+package netest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/cycle"
+	"lv1-netest/errs"
+	"lv1-netest/network"
+	"lv1-netest/sntree"
+	"lv1-netest/subproblem"
+)
+
+// Settings of an estimation. The zero value of every field but Dir picks a default.
+type Options struct {
+	Dir           string                   // setup directory; Estimate uses a temporary one, removed afterwards, if empty
+	AlignmentFile string                   // file the alignment was read from, recorded in the manifest; optional
+	Criterion     string                   // optimality criterion; unord in Setup, the one of the setup (or unord without a manifest) in Finish
+	Ranking       string                   // ranking of candidate trees; default depends on the criterion
+	Template      string                   // PAUP* preset or template file; default "default"
+	Seed          int64                    // seed of the subproblem searches; 0 picks one, or reuses the one of a resumed setup
+	Cache         string                   // directory caching subproblem results across runs; none if empty
+	Redo          string                   // polytomies Finish recomputes instead of resuming, by index or ID, e.g. "0,3f9a1c2e" or "all"
+	Backend       subproblem.SearchBackend // program that solves the subproblems; the built-in search if nil
+	Parallel      int                      // subproblems solved at once; the number of CPUs if 0
+	Log           io.Writer                // progress messages; discarded if nil
+}
+
+// The outcome of an estimation.
+type Result struct {
+	Dir        string            // setup directory, empty if it was temporary
+	Seed       int64             // seed of the subproblem searches
+	SNTree     *tree.Tree        // SN-tree of the alignment
+	Polytomies []sntree.Polytomy // every polytomy of the SN-tree, in index order
+	Cycles     []*Cycle          // cycle closed at every polytomy, in polytomy order
+	Network    *tree.Tree        // SN-tree with the splits and reticulation of every cycle added; see Newick
+	Validation *network.Report   // level and classes of the network
+	Parsimony  *ParsimonyScores  // parsimony scores of the network and trees, nil if the alignment is not binary
+	Sites      []SiteReport      // how the network explains each site, nil if the alignment is not binary
+	Provenance string            // tool version and settings, empty if the setup has no manifest
+}
+
+// The cycle closed at one polytomy of the SN-tree.
+type Cycle struct {
+	Polytomy     int
+	ID           string                        // stable ID of the polytomy, see sntree.PolytomyID
+	Taxa         []string                      // taxa of the polytomy
+	Candidates   []*subproblem.CandidateScores // one per subproblem, in candidate order, read back from the checkpoint without their trees
+	Chosen       *subproblem.CandidateScores   // best candidate under the ranking, whose taxon closes the cycle
+	Tree         *tree.Tree                    // best tree of the chosen subproblem, with its taxon attached
+	Hybrid       *cycle.Hybrid                 // other parent of the attached taxon, and the support of both
+	HybridTaxa   []string                      // SN-tree taxa the attached taxon stands for, sorted
+	Reticulation string                        // #tag of the reticulation of the cycle in the network, empty if it has none
+	Splits       [][]string                    // splits of the cycle, expanded to the SN-tree taxa
+	Computed     []string                      // finish stages computed, empty if all were resumed from the checkpoint
+}
+
+// Returns the network as extended Newick, with the reticulations of its
+// cycles and their inheritance probabilities.
+func (r *Result) Newick() string {
+	return network.Newick(r.Network, gammas(r))
+}
+
+func (o *Options) log() io.Writer {
+	if o.Log == nil {
+		return io.Discard
+	}
+	return o.Log
+}
+
+func (o *Options) criterion(fallback string) (subproblem.Criterion, error) {
+	name := o.Criterion
+	if name == "" {
+		name = fallback
+	}
+	c, err := subproblem.ParseCriterion(name)
+	if err != nil {
+		return c, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	return c, nil
+}
+
+func (o *Options) backend() subproblem.SearchBackend {
+	if o.Backend == nil {
+		return subproblem.BuiltinBackend{}
+	}
+	return o.Backend
+}
+
+func (o *Options) template() string {
+	if o.Template == "" {
+		return "default"
+	}
+	return o.Template
+}
+
+// Estimates the network of aln, running every step. A setup directory that
+// was set up earlier with the same alignment and settings is resumed.
+func Estimate(ctx context.Context, aln align.Alignment, opts Options) (*Result, error) {
+	log := opts.log()
+	opts.Backend = opts.backend()
+	criterion, err := opts.criterion("unord")
+	if err != nil {
+		return nil, err
+	}
+	if err := subproblem.CheckCriterion(opts.Backend, criterion); err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = runtime.NumCPU()
+	}
+	temporary := opts.Dir == ""
+	if temporary {
+		if opts.Dir, err = os.MkdirTemp("", "lv1-netest-"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(opts.Dir)
+	}
+	resume, err := resumable(aln, opts, criterion)
+	if err != nil {
+		return nil, err
+	}
+	if resume != nil {
+		opts.Seed = resume.Seed
+		fmt.Fprintf(log, "[1/3] setup: resuming %s (seed %d)...\n", opts.Dir, opts.Seed)
+	} else {
+		fmt.Fprintln(log, "[1/3] setup...")
+		if opts.Seed, err = Setup(aln, opts); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprint(log, "[2/3] ")
+	if err := subproblem.SolveAll(ctx, opts.Dir, opts.Backend, opts.Parallel, log); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(log, "[3/3] finish...")
+	opts.Criterion = criterion.Name
+	result, err := Finish(aln, opts)
+	if err != nil {
+		return nil, err
+	}
+	if temporary {
+		result.Dir = ""
+	}
+	return result, nil
+}
+
+// Writes the polytomy subproblems of aln, their PAUP* input and the SN-tree
+// to the setup directory, and returns the seed of the subproblem searches.
+func Setup(aln align.Alignment, opts Options) (int64, error) {
+	log := opts.log()
+	criterion, err := opts.criterion("unord")
+	if err != nil {
+		return 0, err
+	}
+	if err := subproblem.CheckCriterion(opts.backend(), criterion); err != nil {
+		return 0, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	tmpl, err := subproblem.LoadPAUPTemplate(opts.template())
+	if err != nil {
+		return 0, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	snTree, err := sntree.Build(aln)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintln(log, "SN-Tree generated...")
+	polytomies, err := sntree.Polytomies(snTree)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(log, "%d polytomies extracted...\n", len(polytomies))
+	settings := subproblem.SearchSettings{Criterion: criterion, Template: tmpl, Seed: opts.Seed, Backend: opts.backend()}
+	var cache *subproblem.Cache
+	if opts.Cache != "" {
+		if cache, err = subproblem.OpenCache(opts.Cache); err != nil {
+			return 0, err
+		}
+	}
+	jobs, err := subproblem.WritePolytomies(polytomies, aln, opts.Dir, settings, cache)
+	if err != nil {
+		return 0, err
+	}
+	if cache != nil {
+		cached := 0
+		for _, j := range jobs {
+			if j.Cached {
+				cached++
+			}
+		}
+		fmt.Fprintf(log, "%d of %d subproblems found in cache...\n", cached, len(jobs))
+	}
+	if err := network.WriteTree(filepath.Join(opts.Dir, "sntree.nwk"), snTree, false, ""); err != nil {
+		return 0, err
+	}
+	manifest, err := subproblem.NewManifest(opts.AlignmentFile, aln, polytomies, settings, jobs, opts.Cache)
+	if err != nil {
+		return 0, err
+	}
+	if err := subproblem.WriteManifest(opts.Dir, manifest); err != nil {
+		return 0, fmt.Errorf("could not write manifest: %w", err)
+	}
+	fmt.Fprintln(log, "done.")
+	return opts.Seed, nil
+}
+
+// Reads the subproblem results of the setup directory, closes the cycles and
+// writes the final network to final_network.nwk there.
+func Finish(aln align.Alignment, opts Options) (*Result, error) {
+	log := opts.log()
+	dir := opts.Dir
+	polytomies, err := subproblem.ReadPolytomies(dir)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidInput, dir, err)
+	}
+	result := &Result{Dir: dir, Seed: opts.Seed}
+	var setupManifest *subproblem.Manifest // nil if the setup has none
+	criterionName := "unord"               // the setup default, for setups without a manifest
+	if manifest, err := subproblem.ReadManifest(dir); errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(log, "warning: %s has no %s, so the alignment and parameters cannot be checked\n", dir, subproblem.ManifestFile)
+	} else if err != nil {
+		return nil, errs.New(errs.ErrInvalidInput, "", err)
+	} else if err := manifest.Verify(opts.AlignmentFile, aln, polytomies); err != nil {
+		return nil, errs.New(errs.ErrInvalidInput, "", err)
+	} else if opts.Criterion != "" && opts.Criterion != manifest.Parameters.Criterion {
+		return nil, errs.New(errs.ErrInvalidOption, "", fmt.Errorf("criterion %s does not match criterion %s used in setup", opts.Criterion, manifest.Parameters.Criterion))
+	} else {
+		if _, err := subproblem.ParseCriterion(manifest.Parameters.Criterion); err != nil {
+			return nil, errs.New(errs.ErrInvalidInput, subproblem.ManifestFile, err)
+		}
+		criterionName = manifest.Parameters.Criterion
+		result.Seed = manifest.Seed
+		result.Provenance = manifest.Provenance()
+		setupManifest = manifest
+	}
+	backend := subproblem.SetupBackend(setupManifest)
+	criterion, err := opts.criterion(criterionName)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := subproblem.OpenSetupCache(dir, opts.Cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil && setupManifest != nil {
+		if pulled, err := cache.Pull(dir, setupManifest); err != nil {
+			return nil, err
+		} else if pulled > 0 {
+			fmt.Fprintf(log, "%d search results taken from cache...\n", pulled)
+		}
+	}
+	rankingSpec := opts.Ranking
+	if rankingSpec == "" {
+		rankingSpec = criterion.Ranking
+	}
+	ranking, err := subproblem.ParseRanking(rankingSpec)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	redo, err := parseRedo(opts.Redo, polytomies)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	settings, err := checkpointKey(dir, opts.AlignmentFile, aln, setupManifest, polytomies)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, err := OpenCheckpoint(dir, fmt.Sprintf("criterion=%s ranking=%s %s", criterion.Name, ranking.Name, settings), log)
+	if err != nil {
+		return nil, fmt.Errorf("could not open checkpoint: %w", err)
+	}
+	// only the search output of polytomies whose candidates are recomputed is
+	// read, so only that output has to be there; it is checked before any
+	// checkpoint is reset
+	recompute := make([]bool, len(polytomies))
+	for i, polytomy := range polytomies {
+		stale, err := checkpoint.Stale(polytomy, dir)
+		if err != nil {
+			return nil, err
+		}
+		recompute[i] = redo[i] || stale || !checkpoint.HasCandidates(polytomy)
+	}
+	jobs, err := subproblem.Status(dir, cache)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidInput, "", err)
+	}
+	done := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Done() {
+			done[job.Stem] = true
+		} else if recompute[job.Polytomy] {
+			kind, file := errs.ErrMissingOutput, job.Input
+			if job.Malformed != "" {
+				kind, file = errs.ErrInvalidOutput, job.Malformed
+			}
+			return nil, &errs.Error{Kind: kind, Polytomy: job.Polytomy, File: file, Taxon: job.Removed, Err: errors.New(strings.Join(job.Problems, ", "))}
+		}
+	}
+	for i, polytomy := range polytomies {
+		if recompute[i] {
+			if err := checkpoint.Reset(polytomy); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if result.SNTree, err = network.ReadTree(filepath.Join(dir, "sntree.nwk")); err != nil {
+		return nil, errs.New(errs.ErrInvalidInput, "", err)
+	}
+	newSplits := make([][]string, 0)
+	for i, polytomy := range polytomies {
+		cycle, err := FinishPolytomy(checkpoint, dir, i, polytomy, ranking, backend, aln, result.SNTree)
+		if err != nil {
+			return nil, err
+		}
+		result.Polytomies = append(result.Polytomies, polytomy)
+		result.Cycles = append(result.Cycles, cycle)
+		newSplits = append(newSplits, cycle.Splits...)
+		if len(cycle.Computed) == 0 {
+			fmt.Fprintf(log, "polytomy %d (%s): resumed from checkpoint...\n", i, polytomy.ID)
+		} else {
+			fmt.Fprintf(log, "polytomy %d (%s): %s done...\n", i, polytomy.ID, strings.Join(cycle.Computed, ", "))
+		}
+	}
+	if err := MergeCandidateSummaries(checkpoint, filepath.Join(dir, "candidates.tsv"), polytomies, result.Provenance); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(log, "search results read and cycles closed...")
+	if cache != nil && setupManifest != nil {
+		for _, job := range setupManifest.Jobs {
+			if !done[job.Stem] {
+				continue
+			}
+			if err := cache.Store(job.Hash, dir, job.Stem, backend.Outputs(job.Stem)); err != nil {
+				return nil, fmt.Errorf("could not add %s to cache: %w", job.Stem, err)
+			}
+		}
+	}
+	if result.Network, err = network.AddSplits(result.SNTree.Clone(), newSplits); err != nil {
+		return nil, err
+	}
+	if err := addReticulations(result, log); err != nil {
+		return nil, err
+	}
+	for _, c := range result.Cycles {
+		if c.Reticulation != "" {
+			gamma, low, high := c.Hybrid.Gamma()
+			fmt.Fprintf(log, "reticulation %s: %s, inheritance probability %.3f (95%% interval %.3f to %.3f) from the other parent\n", c.Reticulation, strings.Join(c.HybridTaxa, ","), gamma, low, high)
+		}
+	}
+	if err := writeHybrids(filepath.Join(dir, "hybrids.tsv"), result.Cycles, result.Provenance); err != nil {
+		return nil, err
+	}
+	output := filepath.Join(dir, "final_network.nwk")
+	if err := network.WriteNewick(output, result.Newick(), result.Provenance); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(log, "result written to %s\n", output)
+	networks, err := network.ParseNetworks(result.Newick())
+	if err != nil {
+		return nil, errs.New(errs.ErrInternal, output, err)
+	}
+	result.Validation = network.Validate(networks[0])
+	fmt.Fprintf(log, "network checked: %s\n", result.Validation.Summary())
+	if !result.Validation.Holds(network.Level1) {
+		return nil, errs.New(errs.ErrInternal, output, fmt.Errorf("the network is not level-1: it is level-%d", result.Validation.Level))
+	}
+	if result.Parsimony, err = scoreParsimony(aln, networks[0], result.SNTree.Newick(), result.Seed); err != nil {
+		return nil, errs.New(errs.ErrInternal, "", fmt.Errorf("parsimony scoring: %w", err))
+	}
+	if result.Parsimony == nil {
+		fmt.Fprintln(log, "parsimony: not scored, the alignment is not binary")
+	} else if err := writeParsimony(filepath.Join(dir, "parsimony.tsv"), result.Parsimony, result.Provenance); err != nil {
+		return nil, err
+	} else {
+		fmt.Fprintf(log, "parsimony: %s\n", result.Parsimony)
+		if result.Sites, err = explainSites(aln, result, networks[0]); err != nil {
+			return nil, err
+		}
+		if err := writeSitesTSV(filepath.Join(dir, "sites.tsv"), result.Sites, result.Provenance); err != nil {
+			return nil, err
+		}
+		if err := writeSitesJSON(filepath.Join(dir, "sites.json"), result.Sites, result.Provenance); err != nil {
+			return nil, err
+		}
+		conflicting, explained, homoplastic := 0, 0, 0
+		for _, site := range result.Sites {
+			if site.Status == SiteConflicting {
+				conflicting++
+				if site.Cycle != "" {
+					explained++
+				}
+			}
+			if site.Homoplastic {
+				homoplastic++
+			}
+		}
+		fmt.Fprintf(log, "sites: %d conflicting, %d of them explained by a cycle; %d homoplastic on the network\n", conflicting, explained, homoplastic)
+	}
+	return result, nil
+}
+
+// Returns what the checkpoint depends on besides the criterion and ranking:
+// the alignment, the SN-tree and the IDs of its polytomies, so that running
+// setup again in the same directory discards the checkpoint.
+func checkpointKey(dir, alnFile string, aln align.Alignment, manifest *subproblem.Manifest, polytomies []sntree.Polytomy) (string, error) {
+	var alnHash string
+	if manifest != nil {
+		alnHash = manifest.Alignment.SHA256
+	} else if hash, err := subproblem.AlignmentSHA256(alnFile, aln); err != nil {
+		return "", err
+	} else {
+		alnHash = hash
+	}
+	snTreeHash, err := subproblem.FileSHA256(filepath.Join(dir, "sntree.nwk"))
+	if err != nil {
+		return "", errs.New(errs.ErrInvalidInput, "", err)
+	}
+	ids := make([]string, len(polytomies))
+	for i, p := range polytomies {
+		ids[i] = p.ID
+	}
+	return fmt.Sprintf("alignment=%s sntree=%s polytomies=%s", alnHash, snTreeHash, strings.Join(ids, ",")), nil
+}
+
+// Parses the redo list of polytomy indices or IDs.
+func parseRedo(redo string, polytomies []sntree.Polytomy) (map[int]bool, error) {
+	result := make(map[int]bool)
+	if redo == "" {
+		return result, nil
+	}
+	for _, field := range strings.Split(redo, ",") {
+		field = strings.TrimSpace(field)
+		if field == "all" {
+			for i := range polytomies {
+				result[i] = true
+			}
+			continue
+		}
+		i := slices.IndexFunc(polytomies, func(p sntree.Polytomy) bool { return p.ID == field })
+		if i == -1 {
+			var err error
+			if i, err = strconv.Atoi(field); err != nil || i < 0 || i >= len(polytomies) {
+				return nil, fmt.Errorf("redo: %q is not a polytomy index or ID (there are %d polytomies)", field, len(polytomies))
+			}
+		}
+		result[i] = true
+	}
+	return result, nil
+}
+
+// Returns the manifest of the setup directory if it was set up with the
+// same alignment and settings, or nil if there is no setup there yet.
+func resumable(aln align.Alignment, opts Options, criterion subproblem.Criterion) (*subproblem.Manifest, error) {
+	manifest, err := subproblem.ReadManifest(opts.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hash, err := subproblem.AlignmentSHA256(opts.AlignmentFile, aln)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := subproblem.LoadPAUPTemplate(opts.template())
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	switch {
+	case manifest.Alignment.SHA256 != hash:
+		err = fmt.Errorf("a different alignment (%s)", manifest.Alignment.File)
+	case manifest.Parameters.Criterion != criterion.Name:
+		err = fmt.Errorf("criterion %s", manifest.Parameters.Criterion)
+	case subproblem.SetupBackend(manifest).Name() != opts.backend().Name():
+		err = fmt.Errorf("backend %s", subproblem.SetupBackend(manifest).Name())
+	case manifest.Parameters.TemplateSHA256 != subproblem.TemplateSHA256(tmpl):
+		err = fmt.Errorf("PAUP* template %s", manifest.Parameters.Template)
+	case opts.Seed != 0 && manifest.Seed != opts.Seed:
+		err = fmt.Errorf("seed %d", manifest.Seed)
+	case manifest.Cache != opts.Cache:
+		err = fmt.Errorf("cache %q", manifest.Cache)
+	default:
+		return manifest, nil
+	}
+	return nil, fmt.Errorf("%s was set up with %s; use another output directory or remove it", opts.Dir, err)
+}
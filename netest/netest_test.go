@@ -0,0 +1,125 @@
+package netest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/alignio"
+	"lv1-netest/errs"
+	"lv1-netest/subproblem"
+)
+
+// Reads an alignment of testdata and returns it with its file name.
+func readTestAlignment(t *testing.T, name string) (align.Alignment, string) {
+	t.Helper()
+	file := filepath.Join("..", "testdata", name)
+	aln, err := alignio.Read(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aln, file
+}
+
+// Estimates the network of an alignment of testdata with the built-in
+// search in a temporary setup directory.
+func estimate(t *testing.T, name string) (*Result, Options) {
+	t.Helper()
+	aln, file := readTestAlignment(t, name)
+	opts := Options{Dir: filepath.Join(t.TempDir(), "setup"), AlignmentFile: file, Seed: 1, Backend: subproblem.BuiltinBackend{}}
+	result, err := Estimate(context.Background(), aln, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, opts
+}
+
+func TestFinishWithoutManifest(t *testing.T) {
+	estimated, opts := estimate(t, "cycle.nex")
+	if err := os.Remove(filepath.Join(opts.Dir, subproblem.ManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	aln, _ := readTestAlignment(t, "cycle.nex")
+	// as finish -a -d without -c: the criterion falls back to unord, the one of the setup
+	result, err := Finish(aln, Options{Dir: opts.Dir, AlignmentFile: opts.AlignmentFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Newick() != estimated.Newick() {
+		t.Errorf("network without the manifest is %s, expected %s", result.Newick(), estimated.Newick())
+	}
+	if result.Provenance != "" {
+		t.Errorf("provenance %q without a manifest", result.Provenance)
+	}
+	if _, err := Finish(aln, Options{Dir: opts.Dir, AlignmentFile: opts.AlignmentFile, Criterion: "nope"}); err == nil {
+		t.Error("finish took an unknown criterion")
+	}
+}
+
+func TestFinishResume(t *testing.T) {
+	estimated, opts := estimate(t, "twocyclesfull.nex")
+	if len(estimated.Polytomies) < 2 {
+		t.Fatalf("%d polytomies, expected two at least", len(estimated.Polytomies))
+	}
+	aln, _ := readTestAlignment(t, "twocyclesfull.nex")
+	outputs := func(i int) []string {
+		sub := subproblem.NewSubproblem(i, 0, estimated.Polytomies[i])
+		return subproblem.BuiltinBackend{}.Outputs(sub.Stem)
+	}
+	// Each step changes the setup directory, then finishes again.
+	tests := []struct {
+		name     string
+		change   func(t *testing.T)
+		redo     string
+		computed []int // polytomies computed rather than resumed
+		err      error
+	}{
+		{"unchanged", func(*testing.T) {}, "", nil, nil},
+		{"output removed", func(t *testing.T) {
+			if err := os.Remove(filepath.Join(opts.Dir, outputs(0)[0])); err != nil {
+				t.Fatal(err)
+			}
+		}, "", nil, nil},
+		{"redo by ID", func(*testing.T) {}, estimated.Polytomies[1].ID, []int{1}, nil},
+		{"redo without output", func(*testing.T) {}, "0", nil, errs.ErrMissingOutput},
+		{"output rewritten", func(t *testing.T) {
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(opts.Dir, outputs(1)[0]), later, later); err != nil {
+				t.Fatal(err)
+			}
+		}, "", []int{1}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.change(t)
+			result, err := Finish(aln, Options{Dir: opts.Dir, AlignmentFile: opts.AlignmentFile, Redo: test.redo})
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, expected %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, c := range result.Cycles {
+				if computed := len(c.Computed) > 0; computed != slices.Contains(test.computed, i) {
+					t.Errorf("polytomy %d computed %v, expected it to be computed: %t", i, c.Computed, !computed)
+				}
+				if c.Chosen.Removed != estimated.Cycles[i].Chosen.Removed || len(c.Candidates) != len(estimated.Cycles[i].Candidates) {
+					t.Errorf("polytomy %d chose %s of %d candidates, expected %s of %d", i, c.Chosen.Removed, len(c.Candidates),
+						estimated.Cycles[i].Chosen.Removed, len(estimated.Cycles[i].Candidates))
+				}
+			}
+			if result.Newick() != estimated.Newick() {
+				t.Errorf("network %s, expected %s", result.Newick(), estimated.Newick())
+			}
+		})
+	}
+}
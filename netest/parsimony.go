@@ -0,0 +1,107 @@
+package netest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/network"
+	"lv1-netest/subproblem"
+)
+
+// Parsimony scores of the network and of the trees it is compared with.
+type ParsimonyScores struct {
+	Network  *network.Parsimony // hardwired and softwired scores of the network
+	SNTree   []int              // score of the SN-tree, per site
+	BestTree []int              // score of the shortest tree found by the built-in search, per site
+	Best     string             // that tree, in Newick
+}
+
+func sum(scores []int) int {
+	total := 0
+	for _, s := range scores {
+		total += s
+	}
+	return total
+}
+
+// Returns how many fewer steps the softwired score of the network needs
+// than the SN-tree and than the best tree; negative if it needs more.
+func (p *ParsimonyScores) Improvement() (int, int) {
+	_, softwired := p.Network.Total()
+	return sum(p.SNTree) - softwired, sum(p.BestTree) - softwired
+}
+
+func (p *ParsimonyScores) String() string {
+	hardwired, softwired := p.Network.Total()
+	snTree, best := p.Improvement()
+	return fmt.Sprintf("network %d hardwired, %d softwired; SN-tree %d (improvement %d), best tree %d (improvement %d)",
+		hardwired, softwired, sum(p.SNTree), snTree, sum(p.BestTree), best)
+}
+
+// Scores the network, the SN-tree and the shortest tree the built-in
+// search finds on the whole alignment. Returns nil if the alignment is not
+// binary.
+func scoreParsimony(aln align.Alignment, net *network.Network, snTree string, seed int64) (*ParsimonyScores, error) {
+	scores := &ParsimonyScores{}
+	var err error
+	if scores.Network, err = network.ScoreParsimony(net, aln); errors.Is(err, network.ErrNotBinary) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if scores.SNTree, err = scoreTree(snTree, aln); err != nil {
+		return nil, fmt.Errorf("SN-tree: %w", err)
+	}
+	criterion, err := subproblem.ParseCriterion("unord")
+	if err != nil {
+		return nil, err
+	}
+	search, err := subproblem.NewBuiltinSearch(criterion, seed)
+	if err != nil {
+		return nil, err
+	}
+	names, seqs := make([]string, 0, aln.NbSequences()), make([]string, 0, aln.NbSequences())
+	aln.IterateChar(func(name string, seq []uint8) bool {
+		names, seqs = append(names, name), append(seqs, string(seq))
+		return false
+	})
+	trees, _, err := search.Search(context.Background(), names, seqs)
+	if err != nil {
+		return nil, fmt.Errorf("best tree: %w", err)
+	}
+	scores.Best = trees[0]
+	if scores.BestTree, err = scoreTree(scores.Best, aln); err != nil {
+		return nil, fmt.Errorf("best tree: %w", err)
+	}
+	return scores, nil
+}
+
+func scoreTree(newick string, aln align.Alignment) ([]int, error) {
+	trees, err := network.ParseNetworks(newick)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := network.ScoreParsimony(trees[0], aln)
+	if err != nil {
+		return nil, err
+	}
+	return scores.Softwired, nil
+}
+
+// Writes the scores of each site, preceded by comment if it is not empty.
+func writeParsimony(name string, p *ParsimonyScores, comment string) error {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	b.WriteString("site\thardwired\tsoftwired\tsntree\tbest_tree\n")
+	for site := range p.Network.Hardwired {
+		fmt.Fprintf(&b, "%d\t%d\t%d\t%d\t%d\n", site+1, p.Network.Hardwired[site], p.Network.Softwired[site], p.SNTree[site], p.BestTree[site])
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
@@ -0,0 +1,140 @@
+package netest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/network"
+	"lv1-netest/sntree"
+)
+
+// Status of the split of a site.
+const (
+	SiteUninformative = "uninformative" // fewer than two taxa on a side of the split
+	SiteSNSplit       = "sn-split"      // conflicts with no other split: an edge of the SN-tree
+	SiteConflicting   = "conflicting"   // conflicts with another split, so the SN-tree leaves it unresolved
+)
+
+// How the network explains a site of the alignment.
+type SiteReport struct {
+	Site        int            `json:"site"`             // from 1
+	Split       []string       `json:"split,omitempty"`  // taxa in state 1, unless the site is uninformative
+	Status      string         `json:"status"`           // one of the constants above
+	Conflicts   int            `json:"conflicts"`        // sites whose split conflicts with this one
+	Cycle       string         `json:"cycle,omitempty"`  // ID of the polytomy whose cycle has the split of a conflicting site
+	Hybrid      string         `json:"hybrid,omitempty"` // taxon closing that cycle
+	Hardwired   int            `json:"hardwired"`        // parsimony scores on the network
+	Softwired   int            `json:"softwired"`
+	Minimum     int            `json:"minimum"`        // least score on any tree
+	Kept        map[string]int `json:"kept,omitempty"` // parent (from 1, in Newick order) of each reticulation kept by every displayed tree of least score, if only one is
+	Homoplastic bool           `json:"homoplastic"`    // whether the softwired score is above the minimum
+}
+
+// Joins the split of each site, its conflicts, the cycle explaining it and
+// its parsimony scores on the network.
+func explainSites(aln align.Alignment, result *Result, net *network.Network) ([]SiteReport, error) {
+	siteSplits, err := sntree.SiteSplits(aln)
+	if err != nil {
+		return nil, err
+	}
+	taxa := make([]string, 0, aln.NbSequences())
+	aln.IterateChar(func(name string, _ []uint8) bool {
+		taxa = append(taxa, name)
+		return false
+	})
+	slices.Sort(taxa)
+	// a cycle split and its complement, keyed by their taxa
+	cycleOf := make(map[string]*Cycle)
+	for _, cycle := range result.Cycles {
+		for _, clade := range cycle.Splits {
+			for _, side := range [][]string{clade, complement(taxa, clade)} {
+				side = slices.Clone(side)
+				slices.Sort(side)
+				if key := strings.Join(side, ","); cycleOf[key] == nil {
+					cycleOf[key] = cycle
+				}
+			}
+		}
+	}
+	scores := result.Parsimony.Network
+	reports := make([]SiteReport, len(siteSplits))
+	for site, s := range siteSplits {
+		r := SiteReport{
+			Site:        site + 1,
+			Status:      SiteUninformative,
+			Conflicts:   s.Conflicts,
+			Hardwired:   scores.Hardwired[site],
+			Softwired:   scores.Softwired[site],
+			Minimum:     scores.Minimum[site],
+			Homoplastic: scores.Homoplastic(site),
+		}
+		if s.Split != nil {
+			r.Split = s.Split.Clade(taxa)
+			r.Status = SiteSNSplit
+		}
+		if !s.SN() && s.Split != nil {
+			r.Status = SiteConflicting
+			if cycle := cycleOf[strings.Join(r.Split, ",")]; cycle != nil {
+				r.Cycle, r.Hybrid = cycle.ID, cycle.Chosen.Removed
+			}
+		}
+		for k, retic := range scores.Reticulations {
+			if kept := scores.Kept[site][k]; kept != -1 {
+				if r.Kept == nil {
+					r.Kept = make(map[string]int)
+				}
+				r.Kept[net.Labels[retic]] = kept + 1
+			}
+		}
+		reports[site] = r
+	}
+	return reports, nil
+}
+
+// Returns the taxa not in clade.
+func complement(taxa, clade []string) []string {
+	result := make([]string, 0, len(taxa)-len(clade))
+	for _, t := range taxa {
+		if !slices.Contains(clade, t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// Writes the site reports to name as a table, preceded by comment if it
+// is not empty.
+func writeSitesTSV(name string, reports []SiteReport, comment string) error {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	b.WriteString("site\tsplit\tstatus\tconflicts\tcycle\thybrid\thardwired\tsoftwired\tminimum\tkept\thomoplastic\n")
+	for _, r := range reports {
+		kept := make([]string, 0, len(r.Kept))
+		for label, parent := range r.Kept {
+			kept = append(kept, fmt.Sprintf("%s:%d", label, parent))
+		}
+		slices.Sort(kept)
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t%s\t%t\n", r.Site, strings.Join(r.Split, ","), r.Status, r.Conflicts,
+			r.Cycle, r.Hybrid, r.Hardwired, r.Softwired, r.Minimum, strings.Join(kept, ","), r.Homoplastic)
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
+
+// Writes the site reports to name as JSON, with the provenance.
+func writeSitesJSON(name string, reports []SiteReport, provenance string) error {
+	content, err := json.MarshalIndent(struct {
+		Provenance string       `json:"provenance,omitempty"`
+		Sites      []SiteReport `json:"sites"`
+	}{provenance, reports}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(content, '\n'), 0644)
+}
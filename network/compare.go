@@ -0,0 +1,438 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// Displayed trees enumerated at most, one per choice of a parent for every
+// reticulation.
+const maxDisplayedTrees = 1 << 16
+
+// How the sets of some kind of feature (clusters, triplets, ...) of an
+// estimated network and a reference network overlap.
+type Overlap struct {
+	Shared    int // features of both networks
+	Estimated int // features of the estimated network only
+	Reference int // features of the reference network only
+}
+
+// Returns the size of the symmetric difference of the two sets.
+func (o Overlap) Distance() int {
+	return o.Estimated + o.Reference
+}
+
+// Returns the distance divided by the size of both sets together, 0 if
+// both are empty.
+func (o Overlap) Normalized() float64 {
+	if o.Shared+o.Distance() == 0 {
+		return 0
+	}
+	return float64(o.Distance()) / float64(o.Shared+o.Distance())
+}
+
+// Returns the fraction of the estimated features that are in the reference,
+// NaN if there are none.
+func (o Overlap) Precision() float64 {
+	return ratio(o.Shared, o.Shared+o.Estimated)
+}
+
+// Returns the fraction of the reference features that were estimated, NaN
+// if there are none.
+func (o Overlap) Recall() float64 {
+	return ratio(o.Shared, o.Shared+o.Reference)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return math.NaN()
+	}
+	return float64(a) / float64(b)
+}
+
+// Distances between an estimated network and a reference network on the
+// same taxa. Clusters, tripartitions and trees exclude the trivial ones, of
+// one taxon or all of them.
+type Comparison struct {
+	Taxa              int
+	HardwiredClusters Overlap // taxa below each node, following every arc
+	SoftwiredClusters Overlap // clusters of the displayed trees
+	Tripartitions     Overlap // for each arc, the taxa below it only through it, the other taxa below it
+	Triplets          Overlap // rooted triplets ab|c of the displayed trees
+	DisplayedTrees    Overlap // distinct displayed trees, as their clusters
+	DisplayedTreeRF   float64 // mean Robinson-Foulds distance of a displayed tree to the closest one of the other network
+	HybridTaxa        Overlap // taxa below a reticulation
+}
+
+// The comparison as a table with a row per measure.
+func (c *Comparison) String() string {
+	var b strings.Builder
+	b.WriteString("measure\tshared\testimated_only\treference_only\tdistance\tnormalized\tprecision\trecall\n")
+	for _, row := range []struct {
+		name    string
+		overlap Overlap
+	}{
+		{"hardwired_clusters", c.HardwiredClusters},
+		{"softwired_clusters", c.SoftwiredClusters},
+		{"tripartitions", c.Tripartitions},
+		{"triplets", c.Triplets},
+		{"displayed_trees", c.DisplayedTrees},
+		{"hybrid_taxa", c.HybridTaxa},
+	} {
+		o := row.overlap
+		fmt.Fprintf(&b, "%s\t%d\t%d\t%d\t%d\t%.6g\t%.6g\t%.6g\n", row.name, o.Shared, o.Estimated, o.Reference, o.Distance(), o.Normalized(), o.Precision(), o.Recall())
+	}
+	fmt.Fprintf(&b, "displayed_tree_rf\t\t\t\t%.6g\t\t\t\n", c.DisplayedTreeRF)
+	return b.String()
+}
+
+// Compares an estimated network with a reference network, which must have
+// the same taxa, each labelling one leaf.
+func Compare(estimated, reference *Network) (*Comparison, error) {
+	taxa, err := estimated.taxa()
+	if err != nil {
+		return nil, fmt.Errorf("estimated network: %w", err)
+	}
+	referenceTaxa, err := reference.taxa()
+	if err != nil {
+		return nil, fmt.Errorf("reference network: %w", err)
+	}
+	if !slices.Equal(taxa, referenceTaxa) {
+		return nil, fmt.Errorf("the networks have different taxa: %s, and %s in the reference", strings.Join(taxa, ","), strings.Join(referenceTaxa, ","))
+	}
+	e, r := newFeatures(estimated, taxa), newFeatures(reference, taxa)
+	if err := e.displayed(); err != nil {
+		return nil, fmt.Errorf("estimated network: %w", err)
+	}
+	if err := r.displayed(); err != nil {
+		return nil, fmt.Errorf("reference network: %w", err)
+	}
+	return &Comparison{
+		Taxa:              len(taxa),
+		HardwiredClusters: overlap(e.hardwired, r.hardwired),
+		SoftwiredClusters: overlap(e.softwired, r.softwired),
+		Tripartitions:     overlap(e.tripartitions(), r.tripartitions()),
+		Triplets:          overlap(e.triplets, r.triplets),
+		DisplayedTrees:    overlap(e.trees, r.trees),
+		DisplayedTreeRF:   (closestRF(e.treeClusters, r.treeClusters) + closestRF(r.treeClusters, e.treeClusters)) / 2,
+		HybridTaxa:        overlap(e.hybrids, r.hybrids),
+	}, nil
+}
+
+func overlap[K comparable](estimated, reference map[K]bool) Overlap {
+	var o Overlap
+	for k := range estimated {
+		if reference[k] {
+			o.Shared++
+		} else {
+			o.Estimated++
+		}
+	}
+	o.Reference = len(reference) - o.Shared
+	return o
+}
+
+// Returns the leaf labels of the network, sorted, checking that they are
+// not empty or repeated.
+func (n *Network) taxa() ([]string, error) {
+	taxa := make([]string, 0)
+	for v := range n.Len() {
+		if len(n.Children[v]) == 0 {
+			if n.Labels[v] == "" {
+				return nil, errors.New("a leaf has no label")
+			}
+			taxa = append(taxa, n.Labels[v])
+		}
+	}
+	slices.Sort(taxa)
+	for k := 1; k < len(taxa); k++ {
+		if taxa[k] == taxa[k-1] {
+			return nil, fmt.Errorf("taxon %s labels several leaves", taxa[k])
+		}
+	}
+	return taxa, nil
+}
+
+// A set of taxa, by their index in the sorted taxa.
+type taxonSet []uint64
+
+func newTaxonSet(n int) taxonSet {
+	return make(taxonSet, (n+63)/64)
+}
+
+func (s taxonSet) add(i int) {
+	s[i/64] |= 1 << (i % 64)
+}
+
+func (s taxonSet) has(i int) bool {
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s taxonSet) union(t taxonSet) {
+	for k := range s {
+		s[k] |= t[k]
+	}
+}
+
+func (s taxonSet) count() int {
+	c := 0
+	for _, w := range s {
+		c += bits.OnesCount64(w)
+	}
+	return c
+}
+
+func (s taxonSet) key() string {
+	b := make([]byte, 0, 8*len(s))
+	for _, w := range s {
+		b = binary.LittleEndian.AppendUint64(b, w)
+	}
+	return string(b)
+}
+
+// The features of a network compared by Compare.
+type features struct {
+	net           *Network
+	taxa          int
+	order         []int // nodes in topological order
+	leaf          []int // taxon index of each leaf, -1 for other nodes
+	hardwired     map[string]bool
+	softwired     map[string]bool
+	triplets      map[int]bool // ab|c as (a*taxa+b)*taxa+c with a < b
+	trees         map[string]bool
+	treeClusters  [][]string // nontrivial clusters of each distinct displayed tree, sorted
+	hybrids       map[string]bool
+	reticulations []int
+}
+
+func newFeatures(n *Network, taxa []string) *features {
+	f := &features{net: n, taxa: len(taxa), order: n.topologicalOrder(), leaf: make([]int, n.Len()),
+		hardwired: make(map[string]bool), softwired: make(map[string]bool), triplets: make(map[int]bool),
+		trees: make(map[string]bool), hybrids: make(map[string]bool)}
+	for v := range n.Len() {
+		f.leaf[v] = -1
+		if len(n.Children[v]) == 0 {
+			f.leaf[v], _ = slices.BinarySearch(taxa, n.Labels[v])
+		}
+		if len(n.Parents[v]) > 1 {
+			f.reticulations = append(f.reticulations, v)
+			for _, t := range n.taxaBelow(v) {
+				f.hybrids[t] = true
+			}
+		}
+	}
+	for _, cluster := range f.clusters(nil) {
+		f.hardwired[cluster.key()] = true
+	}
+	return f
+}
+
+// Returns the nontrivial clusters below each node, keeping only the parent
+// of each node in keptParent, or every parent if it is nil.
+func (f *features) clusters(keptParent []int) []taxonSet {
+	below := make([]taxonSet, f.net.Len())
+	for v := range below {
+		below[v] = newTaxonSet(f.taxa)
+		if f.leaf[v] != -1 {
+			below[v].add(f.leaf[v])
+		}
+	}
+	for k := len(f.order) - 1; k >= 0; k-- {
+		v := f.order[k]
+		if keptParent == nil {
+			for _, p := range f.net.Parents[v] {
+				below[p].union(below[v])
+			}
+		} else if keptParent[v] != -1 {
+			below[keptParent[v]].union(below[v])
+		}
+	}
+	clusters := make([]taxonSet, 0, len(below))
+	for _, s := range below {
+		if c := s.count(); c > 1 && c < f.taxa {
+			clusters = append(clusters, s)
+		}
+	}
+	return clusters
+}
+
+// Enumerates the displayed trees, collecting their clusters and triplets.
+func (f *features) displayed() error {
+	total := 1
+	for _, v := range f.reticulations {
+		if total *= len(f.net.Parents[v]); total > maxDisplayedTrees {
+			return fmt.Errorf("more than %d displayed trees (%d reticulations)", maxDisplayedTrees, len(f.reticulations))
+		}
+	}
+	keptParent := make([]int, f.net.Len())
+	for v := range keptParent {
+		keptParent[v] = -1
+		if len(f.net.Parents[v]) > 0 {
+			keptParent[v] = f.net.Parents[v][0]
+		}
+	}
+	choice := make([]int, len(f.reticulations))
+	for range total {
+		for k, v := range f.reticulations {
+			keptParent[v] = f.net.Parents[v][choice[k]]
+		}
+		clusters := f.clusters(keptParent)
+		keys := make([]string, 0, len(clusters))
+		for _, cluster := range clusters {
+			key := cluster.key()
+			f.softwired[key] = true
+			if !slices.Contains(keys, key) { // unary nodes repeat clusters
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		if tree := strings.Join(keys, "\x00"); !f.trees[tree] {
+			f.trees[tree] = true
+			f.treeClusters = append(f.treeClusters, keys)
+			f.addTriplets(clusters)
+		}
+		for k := range choice { // the next choice, as a mixed radix number
+			if choice[k]++; choice[k] < len(f.net.Parents[f.reticulations[k]]) {
+				break
+			}
+			choice[k] = 0
+		}
+	}
+	return nil
+}
+
+// Adds the triplets ab|c of a tree with the given clusters: c is outside
+// the smallest cluster holding a and b.
+func (f *features) addTriplets(clusters []taxonSet) {
+	clusters = slices.Clone(clusters)
+	slices.SortFunc(clusters, func(a, b taxonSet) int { return a.count() - b.count() })
+	n := f.taxa
+	lca := make([]taxonSet, n*n)
+	for _, cluster := range clusters {
+		for a := range n {
+			if !cluster.has(a) {
+				continue
+			}
+			for b := a + 1; b < n; b++ {
+				if cluster.has(b) && lca[a*n+b] == nil {
+					lca[a*n+b] = cluster
+				}
+			}
+		}
+	}
+	for a := range n {
+		for b := a + 1; b < n; b++ {
+			if lca[a*n+b] == nil {
+				continue // only the root holds both
+			}
+			for c := range n {
+				if !lca[a*n+b].has(c) {
+					f.triplets[(a*n+b)*n+c] = true
+				}
+			}
+		}
+	}
+}
+
+// Returns the nontrivial tripartitions of the arcs.
+func (f *features) tripartitions() map[string]bool {
+	n := f.net
+	result := make(map[string]bool)
+	for u := range n.Len() {
+		for k, v := range n.Children[u] {
+			reached := n.reachableWithout(u, k)
+			strict, other := newTaxonSet(f.taxa), newTaxonSet(f.taxa)
+			for w := range n.Len() {
+				if f.leaf[w] == -1 || !n.descends(w, v) {
+					continue
+				}
+				if reached[w] {
+					other.add(f.leaf[w])
+				} else {
+					strict.add(f.leaf[w])
+				}
+			}
+			if c := strict.count(); other.count() == 0 && (c <= 1 || c == f.taxa) {
+				continue
+			}
+			result[strict.key()+other.key()] = true
+		}
+	}
+	return result
+}
+
+// Returns the nodes reached from the root without the k-th arc out of u.
+func (n *Network) reachableWithout(u, k int) []bool {
+	reached := make([]bool, n.Len())
+	reached[n.Root] = true
+	stack := []int{n.Root}
+	for len(stack) > 0 {
+		w := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for l, c := range n.Children[w] {
+			if !reached[c] && (w != u || l != k) {
+				reached[c] = true
+				stack = append(stack, c)
+			}
+		}
+	}
+	return reached
+}
+
+// Returns the nodes in an order where parents come before their children.
+func (n *Network) topologicalOrder() []int {
+	parents := make([]int, n.Len())
+	for v := range n.Len() {
+		parents[v] = len(n.Parents[v])
+	}
+	order := make([]int, 0, n.Len())
+	for stack := []int{n.Root}; len(stack) > 0; {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, v)
+		for _, c := range n.Children[v] {
+			if parents[c]--; parents[c] == 0 {
+				stack = append(stack, c)
+			}
+		}
+	}
+	return order
+}
+
+// Returns the mean, over the trees of from, of the Robinson-Foulds distance
+// to the closest tree of to, each tree given by its sorted clusters.
+func closestRF(from, to [][]string) float64 {
+	if len(from) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, a := range from {
+		closest := math.MaxInt
+		for _, b := range to {
+			closest = min(closest, rf(a, b))
+		}
+		sum += closest
+	}
+	return float64(sum) / float64(len(from))
+}
+
+// Returns the size of the symmetric difference of two sorted sets.
+func rf(a, b []string) int {
+	d, i, j := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		switch strings.Compare(a[i], b[j]) {
+		case -1:
+			d, i = d+1, i+1
+		case 1:
+			d, j = d+1, j+1
+		default:
+			i, j = i+1, j+1
+		}
+	}
+	return d + len(a) - i + len(b) - j
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	// Scored by hand. In the one-cycle network #H1 is b, between a and c:
+	// it displays ((a,b),c),d and (a,(b,c)),d, with clusters ab, bc and abc
+	// (hardwired and softwired alike), the triplets of both trees, 5 for its
+	// 4 triples, and the arc tripartitions abc|, a|b, c|b and |b. The
+	// two-cycle network adds #H2, f between e and g, and displays 4 trees.
+	const (
+		tree        = "(((a,b),c),d);"
+		oneCycle    = "(((a,(b)#H1),(#H1,c)),d);"
+		bigTree     = "((((a,b),c),d),((e,f),g));"
+		twoCycles   = "((((a,(b)#H1),(#H1,c)),d),((e,(f)#H2),(#H2,g)));"
+		otherCycles = "((((a,b),(c)#H1),(#H1,d)),((e,(f)#H2),(#H2,g)));"
+	)
+	tests := []struct {
+		name                 string
+		estimated, reference string
+		want                 Comparison
+	}{
+		{"tree with itself", tree, tree, Comparison{
+			Taxa:              4,
+			HardwiredClusters: Overlap{Shared: 2},
+			SoftwiredClusters: Overlap{Shared: 2},
+			Tripartitions:     Overlap{Shared: 2},
+			Triplets:          Overlap{Shared: 4},
+			DisplayedTrees:    Overlap{Shared: 1},
+		}},
+		{"network with itself", twoCycles, twoCycles, Comparison{
+			Taxa:              7,
+			HardwiredClusters: Overlap{Shared: 7},
+			SoftwiredClusters: Overlap{Shared: 7},
+			Tripartitions:     Overlap{Shared: 9},
+			Triplets:          Overlap{Shared: 37},
+			DisplayedTrees:    Overlap{Shared: 4},
+			HybridTaxa:        Overlap{Shared: 2},
+		}},
+		{"one cycle against a tree", oneCycle, tree, Comparison{
+			Taxa:              4,
+			HardwiredClusters: Overlap{Shared: 2, Estimated: 1},
+			SoftwiredClusters: Overlap{Shared: 2, Estimated: 1},
+			Tripartitions:     Overlap{Shared: 1, Estimated: 3, Reference: 1},
+			Triplets:          Overlap{Shared: 4, Estimated: 1},
+			DisplayedTrees:    Overlap{Shared: 1, Estimated: 1},
+			DisplayedTreeRF:   0.5, // 0 and 2 to the tree, and 0 back
+			HybridTaxa:        Overlap{Estimated: 1},
+		}},
+		{"tree against one cycle", tree, oneCycle, Comparison{
+			Taxa:              4,
+			HardwiredClusters: Overlap{Shared: 2, Reference: 1},
+			SoftwiredClusters: Overlap{Shared: 2, Reference: 1},
+			Tripartitions:     Overlap{Shared: 1, Estimated: 1, Reference: 3},
+			Triplets:          Overlap{Shared: 4, Reference: 1},
+			DisplayedTrees:    Overlap{Shared: 1, Reference: 1},
+			DisplayedTreeRF:   0.5,
+			HybridTaxa:        Overlap{Reference: 1},
+		}},
+		{"two cycles against a tree", twoCycles, bigTree, Comparison{
+			Taxa:              7,
+			HardwiredClusters: Overlap{Shared: 5, Estimated: 2}, // bc and fg
+			SoftwiredClusters: Overlap{Shared: 5, Estimated: 2}, // bc and fg
+			Tripartitions:     Overlap{Shared: 3, Estimated: 6, Reference: 2},
+			Triplets:          Overlap{Shared: 35, Estimated: 2}, // bc|a and fg|e
+			DisplayedTrees:    Overlap{Shared: 1, Estimated: 3},
+			DisplayedTreeRF:   1, // 0, 2, 2 and 4 to the tree, and 0 back
+			HybridTaxa:        Overlap{Estimated: 2},
+		}},
+		{"two cycles against other two cycles", twoCycles, otherCycles, Comparison{
+			Taxa: 7,
+			// ab, bc, abc, abcd against ab, abc, cd, abcd, and ef, fg, efg in both
+			HardwiredClusters: Overlap{Shared: 6, Estimated: 1, Reference: 1},
+			SoftwiredClusters: Overlap{Shared: 6, Estimated: 1, Reference: 1},
+			// abcd|, efg| and the three of #H2 in both
+			Tripartitions: Overlap{Shared: 5, Estimated: 4, Reference: 4},
+			// bc|a against cd|a and cd|b
+			Triplets:        Overlap{Shared: 36, Estimated: 1, Reference: 2},
+			DisplayedTrees:  Overlap{Shared: 2, Estimated: 2, Reference: 2}, // the ones with ((a,b),c),d
+			DisplayedTreeRF: 1,                                              // 0, 0, 2 and 2 either way
+			HybridTaxa:      Overlap{Shared: 1, Estimated: 1, Reference: 1},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			estimated, err := ParseNetworks(test.estimated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reference, err := ParseNetworks(test.reference)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Compare(estimated[0], reference[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != test.want {
+				t.Errorf("got\n%+v\nexpected\n%+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareDifferentTaxa(t *testing.T) {
+	a, _ := ParseNetworks("((a,b),c);")
+	b, _ := ParseNetworks("((a,b),d);")
+	if _, err := Compare(a[0], b[0]); err == nil || !strings.Contains(err.Error(), "different taxa") {
+		t.Errorf("got error %v, expected the taxa to differ", err)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		overlap                       Overlap
+		distance                      int
+		normalized, precision, recall float64
+	}{
+		{Overlap{}, 0, 0, math.NaN(), math.NaN()},
+		{Overlap{Shared: 2}, 0, 0, 1, 1},
+		{Overlap{Shared: 2, Estimated: 1}, 1, 1.0 / 3, 2.0 / 3, 1},
+		{Overlap{Shared: 1, Estimated: 3, Reference: 1}, 4, 0.8, 0.25, 0.5},
+		{Overlap{Estimated: 1}, 1, 1, 0, math.NaN()},
+	}
+	same := func(a, b float64) bool { return math.IsNaN(a) && math.IsNaN(b) || math.Abs(a-b) < 1e-12 }
+	for _, test := range tests {
+		o := test.overlap
+		if o.Distance() != test.distance || !same(o.Normalized(), test.normalized) || !same(o.Precision(), test.precision) || !same(o.Recall(), test.recall) {
+			t.Errorf("%+v: distance %d, normalized %g, precision %g, recall %g; expected %d, %g, %g, %g", o,
+				o.Distance(), o.Normalized(), o.Precision(), o.Recall(), test.distance, test.normalized, test.precision, test.recall)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A rooted phylogenetic network read from extended Newick. The occurrences of
+// a reticulation, nodes labelled with the same #tag such as #H1 or A#LGT2,
+// are a single node with one parent per occurrence.
+type Network struct {
+	Root     int
+	Labels   []string // node labels, possibly empty
+	Children [][]int  // children of each node
+	Parents  [][]int  // parents of each node; a node with several is a reticulation
+}
+
+// Returns the number of nodes of the network.
+func (n *Network) Len() int {
+	return len(n.Labels)
+}
+
+// Reads every network of an extended Newick file.
+func ReadNetworks(name string) ([]*Network, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	networks, err := ParseNetworks(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return networks, nil
+}
+
+// Parses networks in extended Newick, each ended by ";". Comments in square
+// brackets are skipped, and so are branch lengths, supports and inheritance
+// probabilities.
+func ParseNetworks(s string) ([]*Network, error) {
+	networks := make([]*Network, 0, 1)
+	for p := (&extendedParser{s: s}); ; {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos == len(p.s) {
+			break
+		}
+		p.net, p.reticulations = &Network{}, make(map[string]int)
+		root, err := p.subtree()
+		if err != nil {
+			return nil, fmt.Errorf("network %d: %w", len(networks)+1, err)
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() != ';' {
+			return nil, fmt.Errorf("network %d: %s", len(networks)+1, p.expected(";"))
+		}
+		p.pos++
+		p.net.Root = root
+		if err := p.net.checkAcyclic(); err != nil {
+			return nil, fmt.Errorf("network %d: %w", len(networks)+1, err)
+		}
+		networks = append(networks, p.net)
+	}
+	if len(networks) == 0 {
+		return nil, errors.New("no network")
+	}
+	return networks, nil
+}
+
+type extendedParser struct {
+	s             string
+	pos           int
+	net           *Network
+	reticulations map[string]int // node of each reticulation tag
+}
+
+// Returns the next byte, or 0 at the end of the input.
+func (p *extendedParser) peek() byte {
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *extendedParser) expected(what string) error {
+	if p.pos == len(p.s) {
+		return fmt.Errorf("expected %s, found the end of the input", what)
+	}
+	return fmt.Errorf("position %d: expected %s, found %q", p.pos+1, what, p.s[p.pos])
+}
+
+// Skips blanks and comments.
+func (p *extendedParser) skip() error {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == '[':
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end == -1 {
+				return fmt.Errorf("position %d: unterminated comment", p.pos+1)
+			}
+			p.pos += end + 1
+		case c <= ' ':
+			p.pos++
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Parses a node with its descendants and its branch, and returns it.
+func (p *extendedParser) subtree() (int, error) {
+	var children []int
+	if err := p.skip(); err != nil {
+		return -1, err
+	}
+	if p.peek() == '(' {
+		p.pos++
+		for {
+			child, err := p.subtree()
+			if err != nil {
+				return -1, err
+			}
+			children = append(children, child)
+			if err := p.skip(); err != nil {
+				return -1, err
+			}
+			if c := p.peek(); c == ')' {
+				p.pos++
+				break
+			} else if c != ',' {
+				return -1, p.expected(", or )")
+			}
+			p.pos++
+		}
+	}
+	label, err := p.label()
+	if err != nil {
+		return -1, err
+	}
+	for {
+		if err := p.skip(); err != nil {
+			return -1, err
+		}
+		if p.peek() != ':' {
+			break
+		}
+		p.pos++
+		if err := p.skip(); err != nil {
+			return -1, err
+		}
+		p.token()
+	}
+	node := p.node(label)
+	for _, child := range children {
+		p.net.Children[node] = append(p.net.Children[node], child)
+		p.net.Parents[child] = append(p.net.Parents[child], node)
+	}
+	return node, nil
+}
+
+// Reads an unquoted label or a branch field, up to the next delimiter.
+func (p *extendedParser) token() string {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] > ' ' && !strings.ContainsRune("(),:;[]", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// Reads a label, either unquoted or in single quotes with ” for a quote.
+func (p *extendedParser) label() (string, error) {
+	if err := p.skip(); err != nil {
+		return "", err
+	}
+	if p.peek() != '\'' {
+		return p.token(), nil
+	}
+	var label strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		if p.s[p.pos] != '\'' {
+			label.WriteByte(p.s[p.pos])
+		} else if p.pos+1 < len(p.s) && p.s[p.pos+1] == '\'' {
+			label.WriteByte('\'')
+			p.pos++
+		} else {
+			p.pos++
+			return label.String(), nil
+		}
+	}
+	return "", errors.New("unterminated quoted label")
+}
+
+// Returns the node of a label: the one of its reticulation if it has a #tag
+// that was seen before, a new node otherwise.
+func (p *extendedParser) node(label string) int {
+	tag := ""
+	if k := strings.LastIndexByte(label, '#'); k != -1 && k+1 < len(label) {
+		tag = label[k+1:]
+	}
+	if node, seen := p.reticulations[tag]; tag != "" && seen {
+		if !strings.HasPrefix(label, "#") { // the taxon name may be given at one occurrence only
+			p.net.Labels[node] = label
+		}
+		return node
+	}
+	node := p.net.Len()
+	p.net.Labels = append(p.net.Labels, label)
+	p.net.Children = append(p.net.Children, nil)
+	p.net.Parents = append(p.net.Parents, nil)
+	if tag != "" {
+		p.reticulations[tag] = node
+	}
+	return node
+}
+
+// Checks that the network has no directed cycle, which a reticulation inside
+// one of its own occurrences makes.
+func (n *Network) checkAcyclic() error {
+	if len(n.Parents[n.Root]) > 0 {
+		return fmt.Errorf("the root %s is a descendant of itself", n.describe(n.Root))
+	}
+	if order := n.topologicalOrder(); len(order) < n.Len() {
+		sorted := make([]bool, n.Len())
+		for _, v := range order {
+			sorted[v] = true
+		}
+		v := slices.Index(sorted, false)
+		return fmt.Errorf("a directed cycle reaches %s", n.describe(v))
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+// Package network adds the cycles of the polytomies to the SN-tree to make
+// the level-1 network, and reads and writes trees and networks as Newick.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/gotree/io/newick"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+	"lv1-netest/sntree"
+	"lv1-netest/splits"
+)
+
+// Adds the closed cycle of every polytomy, in polytomy order, to the SN-tree.
+func Assemble(snTree *tree.Tree, polytomies []sntree.Polytomy, cycles []*tree.Tree) (*tree.Tree, error) {
+	// var cur *tree.Tree
+	// fmt.Println(cycles)
+	newSplits := make([][]string, 0)
+	for i, c := range cycles {
+		splits, err := ExpandCycleSplits(snTree, c, i, polytomies[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		newSplits = append(newSplits, splits...)
+	}
+	return AddSplits(snTree, newSplits)
+}
+
+// Expands the splits of the closed cycle of polytomy n, whose ID is id, to
+// the full taxa set of the SN-tree.
+func ExpandCycleSplits(sntree *tree.Tree, cycle *tree.Tree, n int, id string) ([][]string, error) {
+	sntree.ReinitIndexes()
+	taxaNames := sntree.AllTipNames()
+	slices.Sort(taxaNames)
+	nameToID := make(map[string]int)
+	for i, t := range taxaNames {
+		nameToID[t] = i
+	}
+	cycle.ReinitIndexes()
+	cycleSplits := splits.FromTree(cycle)
+	// PrintSplits(cycleSplits)
+	newSplits := make([][]string, 0, len(cycleSplits))
+	for _, s := range cycleSplits {
+		// fmt.Println("split", s.Clade(cycle.AllTipNames()))
+		// fmt.Println("expand", expandSplits(sntree, nameToID, s.Clade(cycle.AllTipNames()), n))
+		expanded, err := expandSplits(sntree, nameToID, s.Clade(cycle.AllTipNames()), n, id)
+		if err != nil {
+			return nil, err
+		}
+		newSplits = append(newSplits, expanded)
+	}
+	return newSplits, nil
+}
+
+// Expands a clade of the taxa of polytomy n, whose ID is id, to the full
+// taxa set of the SN-tree: each taxon stands for its subtree around the
+// polytomy.
+func ExpandClade(sntree *tree.Tree, clade []string, n int, id string) ([]string, error) {
+	sntree.ReinitIndexes()
+	taxaNames := sntree.AllTipNames()
+	slices.Sort(taxaNames)
+	nameToID := make(map[string]int)
+	for i, t := range taxaNames {
+		nameToID[t] = i
+	}
+	return expandSplits(sntree, nameToID, clade, n, id)
+}
+
+// Returns a copy of a network built by AddSplits with a reticulation added:
+// the node above the hybrid taxa keeps its parent and gains a second one, a
+// new node on the edge separating the other taxa, with or without the
+// hybrid ones, from the rest. Both are labelled with the #tag, e.g. "#H1".
+// Fails if no such node or edge is in the network.
+func AddReticulation(t *tree.Tree, hybrid, other []string, tag string) (*tree.Tree, error) {
+	t = t.Clone()
+	below := func(taxa []string) *tree.Node { // node above exactly taxa, nil if none but the root
+		node, _, all := lowestAbove(t.Root(), nil, taxa)
+		if node == nil || node == t.Root() || all > len(taxa) {
+			return nil
+		}
+		return node
+	}
+	node := below(hybrid)
+	if node == nil {
+		return nil, fmt.Errorf("the hybrid taxa %s are not below a node other than the root", strings.Join(hybrid, ","))
+	} else if strings.Contains(node.Name(), "#") {
+		return nil, fmt.Errorf("the node above the hybrid taxa %s is already a reticulation", strings.Join(hybrid, ","))
+	}
+	rest := make([]string, 0)
+	for _, tip := range t.AllTipNames() {
+		if taxon := taxonOf(tip); taxon != "" && !slices.Contains(other, taxon) && !slices.Contains(hybrid, taxon) {
+			rest = append(rest, taxon)
+		}
+	}
+	var parent *tree.Node
+	for _, side := range [][]string{other, append(slices.Clone(other), hybrid...), rest, append(slices.Clone(rest), hybrid...)} {
+		if parent = below(side); parent != nil {
+			break
+		}
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("no edge separates %s from the other taxa", strings.Join(other, ","))
+	}
+	edge, err := parent.ParentEdge()
+	if err != nil {
+		return nil, err
+	}
+	occurrence := t.NewNode()
+	occurrence.SetName(tag)
+	if _, _, _, err := t.GraftTipOnEdge(occurrence, edge); err != nil {
+		return nil, err
+	}
+	node.SetName(node.Name() + tag)
+	return t, nil
+}
+
+// Returns the lowest node above all of taxa, below node v whose parent is
+// prev, with the number of taxa in all below it; nil if there is none, with
+// the number of the taxa and of all taxa below v.
+func lowestAbove(v, prev *tree.Node, taxa []string) (*tree.Node, int, int) {
+	found, all := 0, 0
+	if taxon := taxonOf(v.Name()); v.Tip() && taxon != "" {
+		all++
+		if slices.Contains(taxa, taxon) {
+			found++
+		}
+	}
+	for _, w := range v.Neigh() {
+		if w == prev {
+			continue
+		}
+		lowest, f, a := lowestAbove(w, v, taxa)
+		if lowest != nil {
+			return lowest, f, a
+		}
+		found, all = found+f, all+a
+	}
+	if found == len(taxa) {
+		return v, found, all
+	}
+	return nil, found, all
+}
+
+// Returns the taxon of a node name, without its #tag: empty for the
+// occurrences AddReticulation grafts.
+func taxonOf(name string) string {
+	if k := strings.IndexByte(name, '#'); k != -1 {
+		return name[:k]
+	}
+	return name
+}
+
+// Adds expanded splits (clades on the SN-tree taxa) to the SN-tree.
+func AddSplits(sntree *tree.Tree, newSplits [][]string) (*tree.Tree, error) {
+	sntree.ReinitIndexes()
+	for _, clade := range newSplits {
+		poly, edges, _, err := sntree.LeastCommonAncestorUnrooted(nil, clade...)
+		if err != nil {
+			return nil, errs.New(errs.ErrIncompatibleSplits, "", fmt.Errorf("clade %s: %w", strings.Join(clade, ","), err))
+		}
+		// TODO check that poly is correct vertex
+		sntree.AddBipartition(poly, edges, 1, 1)
+	}
+	return sntree, nil
+}
+
+func expandSplits(snTree *tree.Tree, nameToID map[string]int, ogSplit []string, n int, id string) ([]string, error) {
+	//get polytomy
+	//map each taxon to an edge
+	//expand split to include new taxa - consider root edge case
+	poly, err := sntree.FindPolytomy(snTree, id)
+	if err != nil {
+		return nil, errs.Polytomy(errs.ErrInvalidInput, n, "", err)
+	}
+	result := make([]string, 0)
+	for _, taxon := range ogSplit {
+		if _, known := nameToID[taxon]; !known {
+			return nil, &errs.Error{Kind: errs.ErrUnknownTaxon, Polytomy: n, Taxon: taxon, Err: errors.New("not in the SN-tree")}
+		}
+		subtaxa := make([]string, 0)
+		var parentEdge *tree.Edge
+		for _, e := range poly.Edges() {
+			if e.Left() == poly && e.Bitset().Test(uint(nameToID[taxon])) {
+				subtaxa = append(subtaxa, splits.GetClade(*e.Bitset(), snTree.AllTipNames(), false)...)
+				// } else if e.Bitset().Test(uint(nameToID[taxon])) {
+				// 	result = append(result, GetClade(*e.Bitset(), snTree.AllTipNames(), false)...)
+			}
+			if e.Right() == poly {
+				parentEdge = e
+			}
+		}
+		if len(subtaxa) == 0 {
+			if parentEdge == nil {
+				return nil, &errs.Error{Kind: errs.ErrInternal, Polytomy: n, Taxon: taxon, Err: errors.New("taxon is below no edge of the polytomy")}
+			}
+			subtaxa = append(subtaxa, splits.GetClade(*parentEdge.Bitset(), snTree.AllTipNames(), true)...)
+		}
+		result = append(result, subtaxa...)
+	}
+	return result, nil
+}
+
+// Returns the extended Newick of a network built by AddSplits and
+// AddReticulation. gammas gives, for each #tag, the inheritance probability
+// of the arc AddReticulation added into the reticulation, and the other arc
+// has the rest; a reticulation missing from it has none. Comments of the
+// trees it was built from are left out.
+func Newick(t *tree.Tree, gammas map[string]float64) string {
+	var b strings.Builder
+	writeNewick(&b, t.Root(), nil, gammas)
+	b.WriteString(";")
+	return b.String()
+}
+
+// Writes the subtree of v, whose parent is prev, as gotree does, but with a
+// taxon that is a reticulation below it, so that its label stays the taxon
+// name, and the inheritance probability of each arc into a reticulation.
+func writeNewick(b *strings.Builder, v, prev *tree.Node, gammas map[string]float64) {
+	if taxon := taxonOf(v.Name()); v.Tip() && taxon != "" && taxon != v.Name() {
+		fmt.Fprintf(b, "(%s)%s", taxon, strings.TrimPrefix(v.Name(), taxon))
+		return
+	}
+	neighbours, edges := v.Neigh(), v.Edges()
+	if len(neighbours) > 1 {
+		b.WriteString("(")
+	}
+	children := 0
+	for i, w := range neighbours {
+		if w == prev {
+			continue
+		}
+		if children > 0 {
+			b.WriteString(",")
+		}
+		children++
+		writeNewick(b, w, v, gammas)
+		e := edges[i]
+		if e.Support() != tree.NIL_SUPPORT && w.Name() == "" {
+			b.WriteString(strconv.FormatFloat(e.Support(), 'f', -1, 64))
+			if e.PValue() != tree.NIL_PVALUE {
+				b.WriteString("/" + strconv.FormatFloat(e.PValue(), 'f', -1, 64))
+			}
+		}
+		length := ""
+		if e.Length() != tree.NIL_LENGTH {
+			length = strconv.FormatFloat(e.Length(), 'f', -1, 64)
+		}
+		tag := strings.TrimPrefix(w.Name(), taxonOf(w.Name()))
+		if gamma, ok := gammas[tag]; ok && tag != "" {
+			if taxonOf(w.Name()) != "" || !w.Tip() { // the original parent arc, not the occurrence AddReticulation grafted
+				gamma = 1 - gamma
+			}
+			fmt.Fprintf(b, ":%s::%.3f", length, gamma)
+		} else if length != "" {
+			b.WriteString(":" + length)
+		}
+	}
+	if len(neighbours) > 1 {
+		b.WriteString(")")
+	}
+	b.WriteString(v.Name())
+}
+
+// Writes a tree as Newick, preceded by comment in square brackets if it is not empty.
+func WriteTree(name string, t *tree.Tree, network bool, comment string) error {
+	if network {
+		return WriteNewick(name, Newick(t, nil), comment)
+	}
+	return WriteNewick(name, t.Newick(), comment)
+}
+
+// Writes a Newick string to name, preceded by comment in square brackets
+// if it is not empty.
+func WriteNewick(name, nwk, comment string) error {
+	if comment != "" {
+		nwk = fmt.Sprintf("[%s]\n%s", comment, nwk)
+	}
+	err := os.WriteFile(name, []byte(nwk), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+func ReadTree(name string) (*tree.Tree, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := newick.NewParser(f).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Reads a tree and indexes its tips, which closing a cycle needs.
+func ReadTreeIndexed(name string) (*tree.Tree, error) {
+	t, err := ReadTree(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.UpdateTipIndex(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// func getSplits(sntree, cycle *tree.Tree, n int) []string {
+// 	// for each edge in the polytomy in the sntree - get the taxa names corresponding each branch
+// 	// get all the splits from the cycle tree, then exapnd them to the full taxa set
+// 	taxa := sntree.Tips()
+// 	search, err := sntree.SelectNodes(fmt.Sprintf("polytomy_%d", n))
+// 	fmt.Println(fmt.Sprintf("polytomy_%d", n))
+// 	if err != nil {
+// 		panic(err)
+// 	}
+// 	if len(search) != 1 {
+// 		fmt.Println(search)
+// 		panic("multiple nodes match polytomy name")
+// 	}
+// 	poly := search[0]
+// 	for _, e := range poly.Edges() {
+// 		bs := e.Bitset()
+// 		for t := range
+// 	}
+// 	p, err := poly.Parent()
+// 	if err != nil && poly != sntree.Root() {
+// 		panic(err)
+// 	}
+// 	result := make(map[string]*tree.Tree)
+// 	for _, t := range cycle.Tips() {
+// 		for _, e := range poly.Edges() {
+// 			if e.Left() == p || e.Right() == p {
+// 				remainder := sntree.Clone()
+// 				remainder.RemoveTips(true, sntree.SubTree(poly).AllTipNames()...)
+// 				result[t.Name()] = remainder
+// 			} else {
+// 				if e.Right() != poly {
+// 					result[t.Name()] = sntree.SubTree(e.Right())
+// 				} else {
+// 					result[t.Name()] = sntree.SubTree(e.Left())
+// 				}
+// 			}
+// 		}
+// 	}
+// 	return result
+// }
+
+// func AssembleNetwork(sntree *tree.Tree, cycles []*tree.Tree) *tree.Tree {
+// 	var cur *tree.Tree
+// 	fmt.Println(cycles)
+// 	for i, c := range cycles {
+// 		cur = c
+// 		subtrees := getSubtrees(sntree, c, i)
+// 		fmt.Println(c.Newick())
+// 		for _, t := range cur.Tips() {
+// 			fmt.Print(i)
+// 			cur.GraftTreeOnTip(t.Name(), subtrees[t.Name()])
+// 		}
+// 	}
+// 	return cur
+// }
+
+// func getSubtrees(sntree, cycle *tree.Tree, n int) map[string]*tree.Tree {
+// 	search, err := sntree.SelectNodes(fmt.Sprintf("polytomy_%d", n))
+// 	fmt.Println(fmt.Sprintf("polytomy_%d", n))
+// 	if err != nil {
+// 		panic(err)
+// 	}
+// 	if len(search) != 1 {
+// 		fmt.Println(search)
+// 		panic("multiple nodes match polytomy name")
+// 	}
+// 	poly := search[0]
+// 	p, err := poly.Parent()
+// 	if err != nil && poly != sntree.Root() {
+// 		panic(err)
+// 	}
+// 	result := make(map[string]*tree.Tree)
+// 	for _, t := range cycle.Tips() {
+// 		for _, e := range poly.Edges() {
+// 			if e.Left() == p || e.Right() == p {
+// 				remainder := sntree.Clone()
+// 				remainder.RemoveTips(true, sntree.SubTree(poly).AllTipNames()...)
+// 				result[t.Name()] = remainder
+// 			} else {
+// 				if e.Right() != poly {
+// 					result[t.Name()] = sntree.SubTree(e.Right())
+// 				} else {
+// 					result[t.Name()] = sntree.SubTree(e.Left())
+// 				}
+// 			}
+// 		}
+// 	}
+// 	return result
+// }
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evolbioinfo/gotree/io/newick"
+	"github.com/evolbioinfo/gotree/tree"
+)
+
+func TestAddReticulation(t *testing.T) {
+	const base = "((a:1,b:1)1:1,(c:1,(d:1,e:1)1:1)1:1);"
+	type reticulation struct {
+		hybrid, other string
+		tag           string
+	}
+	tests := []struct {
+		name          string
+		reticulations []reticulation
+		gammas        map[string]float64
+		want          string // the grafted arc with the inheritance probability, the other arc the rest
+	}{
+		{"hybrid taxon", []reticulation{{"e", "a", "#H1"}}, map[string]float64{"#H1": 0.3}, "(((#H1:1::0.300,a:0.5):0.5,b:1)1:1,(c:1,(d:1,(e)#H1:1::0.700)1:1)1:1);"},
+		{"hybrid subtree", []reticulation{{"d,e", "a,b", "#H1"}}, map[string]float64{"#H1": 0.3}, "((#H1:1::0.300,(a:1,b:1):0.5)1:0.5,(c:1,(d:1,e:1)#H1:1::0.700)1:1);"},
+		{"other side with the hybrid taxa", []reticulation{{"e", "c,d", "#H1"}}, map[string]float64{"#H1": 0.3}, "((a:1,b:1)1:1,(#H1:1::0.300,(c:1,(d:1,(e)#H1:1::0.700)1:1):0.5)1:0.5);"},
+		{"tags from #H10", []reticulation{{"b", "a", "#H1"}, {"e", "d", "#H10"}, {"c", "d,e", "#H11"}}, map[string]float64{"#H1": 0.2, "#H10": 0.4, "#H11": 0.9}, "(((#H1:1::0.200,a:0.5):0.5,(b)#H1:1::0.800)1:1,((c)#H11:1::0.100,(#H11:1::0.900,((#H10:1::0.400,d:0.5):0.5,(e)#H10:1::0.600):0.5)1:0.5)1:1);"},
+		{"no inheritance probability", []reticulation{{"e", "a", "#H1"}}, nil, "(((#H1:1,a:0.5):0.5,b:1)1:1,(c:1,(d:1,(e)#H1:1)1:1)1:1);"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			net := parseTree(t, base)
+			for _, r := range test.reticulations {
+				var err error
+				if net, err = AddReticulation(net, strings.Split(r.hybrid, ","), strings.Split(r.other, ","), r.tag); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := Newick(net, test.gammas)
+			if got != test.want {
+				t.Errorf("got %s, expected %s", got, test.want)
+			}
+			networks, err := ParseNetworks(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report := Validate(networks[0]); !report.Holds(Level1) || report.Reticulations != len(test.reticulations) || report.Taxa != 5 {
+				t.Errorf("%s: %s", got, report.Summary())
+			}
+		})
+	}
+}
+
+func TestAddReticulationErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		hybrid, other string
+		err           string
+	}{
+		{"hybrid taxa at the root", "a,b,c,d,e", "a", "not below a node other than the root"},
+		{"hybrid taxa not a clade", "b,c", "a", "not below a node other than the root"},
+		{"already a reticulation", "e", "c", "already a reticulation"},
+		{"no edge", "d", "a,c", "no edge separates a,c"},
+	}
+	for _, test := range tests {
+		net, err := AddReticulation(parseTree(t, "((a:1,b:1)1:1,(c:1,(d:1,e:1)1:1)1:1);"), []string{"e"}, []string{"a"}, "#H1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := Newick(net, nil)
+		_, err = AddReticulation(net, strings.Split(test.hybrid, ","), strings.Split(test.other, ","), "#H2")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected one containing %q", test.name, err, test.err)
+		}
+		if after := Newick(net, nil); after != before {
+			t.Errorf("%s: the network changed from %s to %s", test.name, before, after)
+		}
+	}
+}
+
+func parseTree(t *testing.T, nwk string) *tree.Tree {
+	t.Helper()
+	parsed, err := newick.NewParser(strings.NewReader(nwk)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
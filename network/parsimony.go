@@ -0,0 +1,257 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/evolbioinfo/goalign/align"
+)
+
+// Parsimony scores of the sites of a binary alignment on a network.
+type Parsimony struct {
+	Hardwired     []int   // per site: least number of arcs whose ends differ, over all states of the nodes
+	Softwired     []int   // per site: least tree score over the displayed trees
+	Minimum       []int   // per site: least score on any tree, the number of states seen less one
+	Reticulations []int   // the reticulations of the network, one per cycle
+	Kept          [][]int // per site: for each reticulation, the index of the parent the displayed trees of least score keep, or -1 if either
+}
+
+// Returns whether a site needs more changes on the network than on any
+// tree: more than one change for a binary character.
+func (p *Parsimony) Homoplastic(site int) bool {
+	return p.Softwired[site] > p.Minimum[site]
+}
+
+// Returns the hardwired and softwired scores summed over the sites.
+func (p *Parsimony) Total() (int, int) {
+	hardwired, softwired := 0, 0
+	for site := range p.Hardwired {
+		hardwired += p.Hardwired[site]
+		softwired += p.Softwired[site]
+	}
+	return hardwired, softwired
+}
+
+// Scores every site of a binary alignment, with states 0 and 1 and ? or -
+// for missing data, on a level-1 network, or a tree, whose leaves are the
+// taxa of the alignment. Both scores are computed by dynamic programming
+// from the leaves up, fixing for each cycle either the state of its
+// reticulation (hardwired) or the parent arc the reticulation keeps
+// (softwired), so each cycle only doubles the work of its nodes.
+func ScoreParsimony(n *Network, aln align.Alignment) (*Parsimony, error) {
+	if report := Validate(n); !report.Holds(Level1) {
+		return nil, fmt.Errorf("parsimony scoring needs a level-1 network, not a level-%d one", report.Level)
+	}
+	seqs := make(map[string][]uint8)
+	aln.IterateChar(func(name string, seq []uint8) bool {
+		seqs[name] = seq
+		return false
+	})
+	leaves := make(map[int][]uint8)
+	for v := range n.Len() {
+		if len(n.Children[v]) > 0 {
+			continue
+		}
+		seq, ok := seqs[n.Labels[v]]
+		if !ok {
+			return nil, fmt.Errorf("leaf %q is not in the alignment", n.Labels[v])
+		}
+		leaves[v] = seq
+		delete(seqs, n.Labels[v])
+	}
+	if len(seqs) > 0 {
+		missing := make([]string, 0, len(seqs))
+		for name := range seqs {
+			missing = append(missing, name)
+		}
+		slices.Sort(missing)
+		return nil, fmt.Errorf("taxa %v of the alignment are not leaves of the network", missing)
+	}
+	s := newScorer(n)
+	p := &Parsimony{
+		Hardwired:     make([]int, aln.Length()),
+		Softwired:     make([]int, aln.Length()),
+		Minimum:       make([]int, aln.Length()),
+		Reticulations: s.retics,
+		Kept:          make([][]int, aln.Length()),
+	}
+	type scores struct {
+		hardwired, softwired int
+		kept                 []int
+	}
+	patterns := make(map[string]scores) // scores of each site pattern already seen
+	column := make([]byte, 0, len(leaves))
+	for site := range aln.Length() {
+		column = column[:0]
+		var states [2]bool
+		for v := range n.Len() {
+			if seq, ok := leaves[v]; ok {
+				switch c := seq[site]; c {
+				case '0', '1':
+					s.leaf[v] = [2]int{}
+					s.leaf[v]['1'-c] = infinite
+					states[c-'0'] = true
+				case '?', '-':
+					s.leaf[v] = [2]int{}
+				default:
+					return nil, fmt.Errorf("taxon %s has %q at site %d: %w", n.Labels[v], c, site+1, ErrNotBinary)
+				}
+				column = append(column, seq[site])
+			}
+		}
+		if states[0] && states[1] {
+			p.Minimum[site] = 1
+		}
+		scored, seen := patterns[string(column)]
+		if !seen {
+			scored = scores{hardwired: s.score(true, -1, 0), softwired: s.score(false, -1, 0), kept: make([]int, len(s.retics))}
+			for cycle := range s.retics {
+				scored.kept[cycle] = -1
+				for kept := range 2 {
+					if s.score(false, cycle, 1-kept) > scored.softwired {
+						scored.kept[cycle] = 1 - kept // keeping parent kept costs more
+					}
+				}
+			}
+			patterns[string(column)] = scored
+		}
+		p.Hardwired[site], p.Softwired[site], p.Kept[site] = scored.hardwired, scored.softwired, scored.kept
+	}
+	return p, nil
+}
+
+// Returned, wrapped, by ScoreParsimony for alignments with other states than 0 and 1.
+var ErrNotBinary = errors.New("parsimony scoring needs a binary alignment")
+
+// Larger than any score, and small enough to add a few of them.
+const infinite = 1 << 40
+
+// The cycles of a level-1 network and the costs of one site.
+type scorer struct {
+	n         *Network
+	cycleOf   map[[2]int]int // cycle of each arc, for arcs of a cycle
+	inside    []int          // cycle of each node strictly below the top of a cycle, other than its reticulation, or -1
+	retics    []int          // reticulation of each cycle
+	leaf      [][2]int       // cost of each state at each leaf, for the current site
+	hardwired bool
+	forced    int               // cycle whose choice is fixed, or -1
+	choice    int               // choice of the forced cycle
+	memo      map[[2]int][2]int // costs of (node, choice of its cycle, 0 outside cycles)
+}
+
+func newScorer(n *Network) *scorer {
+	s := &scorer{n: n, cycleOf: make(map[[2]int]int), inside: make([]int, n.Len()), leaf: make([][2]int, n.Len())}
+	for v := range s.inside {
+		s.inside[v] = -1
+	}
+	for _, arcs := range n.biconnectedComponents() {
+		if len(arcs) < 2 {
+			continue
+		}
+		c := len(s.retics)
+		parents := make(map[int]int)
+		for _, arc := range arcs {
+			s.cycleOf[arc] = c
+			parents[arc[1]]++
+		}
+		retic := -1
+		for v, count := range parents {
+			if count > 1 {
+				retic = v
+			}
+		}
+		for v := range parents {
+			if v != retic {
+				s.inside[v] = c
+			}
+		}
+		s.retics = append(s.retics, retic)
+	}
+	return s
+}
+
+// Returns the score of the current site, with the choice of cycle forced
+// fixed unless it is -1.
+func (s *scorer) score(hardwired bool, forced, choice int) int {
+	s.hardwired, s.forced, s.choice, s.memo = hardwired, forced, choice, make(map[[2]int][2]int)
+	costs := s.costs(s.n.Root, 0)
+	return min(costs[0], costs[1])
+}
+
+// Returns the least cost of the network below v for each state of v, with
+// choice fixed for the cycle v is strictly inside: the state of its
+// reticulation (hardwired) or the index of the parent arc the reticulation
+// loses (softwired).
+func (s *scorer) costs(v, choice int) [2]int {
+	if s.inside[v] == -1 {
+		choice = 0
+	}
+	key := [2]int{v, choice}
+	if costs, ok := s.memo[key]; ok {
+		return costs
+	}
+	if len(s.n.Children[v]) == 0 {
+		s.memo[key] = s.leaf[v]
+		return s.leaf[v]
+	}
+	var costs [2]int
+	tops := make([]int, 0) // cycles whose top is v
+	for _, c := range s.n.Children[v] {
+		cycle, inCycle := s.cycleOf[[2]int{v, c}]
+		if inCycle && cycle != s.inside[v] {
+			if !slices.Contains(tops, cycle) {
+				tops = append(tops, cycle)
+			}
+			continue
+		}
+		arc := s.arc(v, c, choice)
+		costs[0], costs[1] = costs[0]+arc[0], costs[1]+arc[1]
+	}
+	for _, cycle := range tops {
+		best := [2]int{infinite, infinite}
+		for choice := range 2 {
+			if cycle == s.forced && choice != s.choice {
+				continue
+			}
+			var sum [2]int
+			if s.hardwired {
+				retic := s.costs(s.retics[cycle], 0)
+				sum = [2]int{retic[choice], retic[choice]}
+			}
+			for _, c := range s.n.Children[v] {
+				if arcCycle, inCycle := s.cycleOf[[2]int{v, c}]; inCycle && arcCycle == cycle {
+					arc := s.arc(v, c, choice)
+					sum[0], sum[1] = sum[0]+arc[0], sum[1]+arc[1]
+				}
+			}
+			best[0], best[1] = min(best[0], sum[0]), min(best[1], sum[1])
+		}
+		costs[0], costs[1] = costs[0]+best[0], costs[1]+best[1]
+	}
+	s.memo[key] = costs
+	return costs
+}
+
+// Returns the least cost of the arc from v to c and the network below c,
+// for each state of v, with choice fixed for the cycle of the arc.
+func (s *scorer) arc(v, c, choice int) [2]int {
+	cycle, inCycle := s.cycleOf[[2]int{v, c}]
+	if inCycle && c == s.retics[cycle] {
+		if s.hardwired { // the cost below the reticulation is counted once, at the top
+			return [2]int{changes(0, choice), changes(1, choice)}
+		}
+		if s.n.Parents[c][choice] == v {
+			return [2]int{} // the arc is not in the displayed tree
+		}
+	}
+	below := s.costs(c, choice)
+	return [2]int{min(below[0], below[1]+1), min(below[0]+1, below[1])}
+}
+
+func changes(a, b int) int {
+	if a == b {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,172 @@
+package network
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/evolbioinfo/goalign/align"
+)
+
+func alignment(seqs map[string]string) align.Alignment {
+	aln := align.NewAlign(align.UNKNOWN)
+	names := make([]string, 0, len(seqs))
+	for name := range seqs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		aln.AddSequence(name, seqs[name], "")
+	}
+	return aln
+}
+
+func TestScoreParsimony(t *testing.T) {
+	// Scored by hand. In the networks each reticulation sits between two
+	// leaves, so a displayed tree puts it with one or the other; kept names
+	// the leaf whose parent the reticulation keeps at each site, or is empty
+	// if either does.
+	fourTaxa := map[string]string{
+		// sites: 1100 0110 1000 1010 0000 1?00
+		"a": "101101",
+		"b": "11000?",
+		"c": "010100",
+		"d": "000000",
+	}
+	tests := []struct {
+		name                          string
+		network                       string
+		seqs                          map[string]string
+		hardwired, softwired, minimum []int
+		kept                          map[string][]string // per reticulation label, per site
+	}{
+		{
+			name:    "tree",
+			network: "((a,b),(c,d));",
+			seqs:    fourTaxa,
+			// a tree is its only displayed tree, and both scores are its Fitch score
+			hardwired: []int{1, 2, 1, 2, 0, 1},
+			softwired: []int{1, 2, 1, 2, 0, 1},
+			minimum:   []int{1, 1, 1, 1, 0, 1},
+		},
+		{
+			name:    "one cycle",
+			network: "(((a,(b)#H1),(#H1,c)),d);",
+			seqs:    fourTaxa,
+			// ab and bc each fit one displayed tree with one change, while
+			// the edge-disjoint paths b-H1-c and a-d (or b-H1-a and c-d)
+			// make any labelling of the network change twice
+			hardwired: []int{2, 2, 1, 2, 0, 1},
+			softwired: []int{1, 1, 1, 2, 0, 1},
+			minimum:   []int{1, 1, 1, 1, 0, 1},
+			kept:      map[string][]string{"#H1": {"a", "c", "", "", "", ""}},
+		},
+		{
+			name:    "two cycles",
+			network: "((((a,(b)#H1),(#H1,c)),d),((e,(f)#H2),(#H2,g)));",
+			seqs: map[string]string{
+				// sites: ab, fg, bf
+				"a": "100",
+				"b": "101",
+				"c": "000",
+				"d": "000",
+				"e": "000",
+				"f": "011",
+				"g": "010",
+			},
+			hardwired: []int{2, 2, 2},
+			softwired: []int{1, 1, 2},
+			minimum:   []int{1, 1, 1},
+			kept:      map[string][]string{"#H1": {"a", "", ""}, "#H2": {"", "g", ""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks, err := ParseNetworks(test.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := networks[0]
+			p, err := ScoreParsimony(n, alignment(test.seqs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.Hardwired, test.hardwired) {
+				t.Errorf("hardwired %v, expected %v", p.Hardwired, test.hardwired)
+			}
+			if !slices.Equal(p.Softwired, test.softwired) {
+				t.Errorf("softwired %v, expected %v", p.Softwired, test.softwired)
+			}
+			if !slices.Equal(p.Minimum, test.minimum) {
+				t.Errorf("minimum %v, expected %v", p.Minimum, test.minimum)
+			}
+			if len(p.Reticulations) != len(test.kept) {
+				t.Fatalf("%d reticulations, expected %d", len(p.Reticulations), len(test.kept))
+			}
+			for k, retic := range p.Reticulations {
+				want, ok := test.kept[n.Labels[retic]]
+				if !ok {
+					t.Fatalf("unexpected reticulation %q", n.Labels[retic])
+				}
+				for site := range p.Kept {
+					got := ""
+					if parent := p.Kept[site][k]; parent != -1 {
+						for _, c := range n.Children[n.Parents[retic][parent]] {
+							if len(n.Children[c]) == 0 {
+								got = n.Labels[c]
+							}
+						}
+					}
+					if got != want[site] {
+						t.Errorf("site %d: %s keeps the parent of %q, expected %q", site+1, n.Labels[retic], got, want[site])
+					}
+				}
+			}
+			hardwired, softwired := p.Total()
+			if want := sum(test.hardwired); hardwired != want {
+				t.Errorf("total hardwired %d, expected %d", hardwired, want)
+			}
+			if want := sum(test.softwired); softwired != want {
+				t.Errorf("total softwired %d, expected %d", softwired, want)
+			}
+		})
+	}
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func TestScoreParsimonyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		seqs    map[string]string
+		err     string
+	}{
+		{"not binary", "((a,b),c);", map[string]string{"a": "0", "b": "2", "c": "1"}, "taxon b has '2' at site 1"},
+		{"leaf not in the alignment", "((a,b),c);", map[string]string{"a": "0", "b": "1"}, `leaf "c" is not in the alignment`},
+		{"taxon not in the network", "(a,b);", map[string]string{"a": "0", "b": "1", "c": "1"}, "taxa [c] of the alignment are not leaves"},
+		{"level 2", "((((a)#H1,(b)#H2),(#H1,c)),(#H2,d));", map[string]string{"a": "0", "b": "1", "c": "1", "d": "0"}, "needs a level-1 network"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks, err := ParseNetworks(test.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ScoreParsimony(networks[0], alignment(test.seqs))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, expected one containing %q", err, test.err)
+			}
+			if test.name == "not binary" && !errors.Is(err, ErrNotBinary) {
+				t.Errorf("error %v does not wrap ErrNotBinary", err)
+			}
+		})
+	}
+}
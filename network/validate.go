@@ -0,0 +1,309 @@
+package network
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Network classes checked by Validate.
+const (
+	TreeChild  = "tree-child"  // every non-leaf node has a child that is not a reticulation
+	TreeBased  = "tree-based"  // a spanning tree of the network has the same leaves
+	Normal     = "normal"      // tree-child, and no reticulation arc is a shortcut of a path
+	GalledTree = "galled tree" // every blob is a cycle with one reticulation
+	Level1     = "level-1"     // no blob has more than one reticulation
+)
+
+// What Validate found about a network.
+type Report struct {
+	Taxa          int        // number of leaves
+	Reticulations int        // number of nodes with several parents
+	Blobs         []Blob     // biconnected components with more than one arc, ancestors first
+	Level         int        // largest reticulation number of a blob, 0 for a tree
+	Properties    []Property // one per class, in the order of the constants above
+}
+
+// A biconnected component of a network with more than one arc.
+type Blob struct {
+	Nodes         int
+	Arcs          int
+	Reticulations int      // parents of its reticulations beyond the first of each
+	Taxa          []string // leaves below it, sorted
+}
+
+// Whether a network is in one of the classes of Validate.
+type Property struct {
+	Name   string
+	Holds  bool
+	Reason string // why it does not hold, empty if it does
+}
+
+// Returns whether the property with the given name holds.
+func (r *Report) Holds(name string) bool {
+	for _, p := range r.Properties {
+		if p.Name == name {
+			return p.Holds
+		}
+	}
+	return false
+}
+
+// Returns the properties that hold on one line, after the size and level.
+func (r *Report) Summary() string {
+	holds := make([]string, 0, len(r.Properties))
+	for _, p := range r.Properties {
+		if p.Holds {
+			holds = append(holds, p.Name)
+		}
+	}
+	if len(holds) == 0 {
+		holds = append(holds, "none of the classes")
+	}
+	return fmt.Sprintf("%d taxa, %d reticulations, level %d; %s", r.Taxa, r.Reticulations, r.Level, strings.Join(holds, ", "))
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "taxa: %d\nreticulations: %d\nlevel: %d\n", r.Taxa, r.Reticulations, r.Level)
+	for k, blob := range r.Blobs {
+		fmt.Fprintf(&b, "blob %d: %d nodes, %d arcs, %d reticulations, above %s\n", k+1, blob.Nodes, blob.Arcs, blob.Reticulations, strings.Join(blob.Taxa, ","))
+	}
+	for _, p := range r.Properties {
+		if p.Holds {
+			fmt.Fprintf(&b, "%s: yes\n", p.Name)
+		} else {
+			fmt.Fprintf(&b, "%s: no (%s)\n", p.Name, p.Reason)
+		}
+	}
+	return b.String()
+}
+
+// Computes the blobs and level of a network and checks whether it is
+// tree-child, tree-based, normal, a galled tree and level-1.
+func Validate(n *Network) *Report {
+	r := &Report{}
+	for v := range n.Len() {
+		if len(n.Children[v]) == 0 {
+			r.Taxa++
+		}
+		if len(n.Parents[v]) > 1 {
+			r.Reticulations++
+		}
+	}
+	for _, arcs := range n.biconnectedComponents() {
+		if len(arcs) < 2 {
+			continue
+		}
+		nodes, parents := make(map[int]bool), make(map[int]int) // parents in the blob
+		for _, arc := range arcs {
+			nodes[arc[0]], nodes[arc[1]] = true, true
+			parents[arc[1]]++
+		}
+		blob := Blob{Arcs: len(arcs)}
+		top := -1
+		for v := range nodes {
+			if parents[v] == 0 {
+				top = v
+			}
+			blob.Reticulations += max(parents[v]-1, 0)
+		}
+		blob.Nodes, blob.Taxa = len(nodes), n.taxaBelow(top)
+		r.Blobs = append(r.Blobs, blob)
+		r.Level = max(r.Level, blob.Reticulations)
+	}
+	slices.Reverse(r.Blobs) // Tarjan's algorithm finds descendants first
+	slices.SortStableFunc(r.Blobs, func(a, b Blob) int { return len(b.Taxa) - len(a.Taxa) })
+
+	treeChild := n.checkTreeChild()
+	normal := treeChild
+	if normal == "" {
+		normal = n.checkShortcuts()
+	}
+	galled := ""
+	for k, blob := range r.Blobs {
+		if blob.Reticulations != 1 || blob.Arcs != blob.Nodes {
+			galled = fmt.Sprintf("blob %d is not a cycle with one reticulation", k+1)
+			break
+		}
+	}
+	level1 := ""
+	if r.Level > 1 {
+		level1 = fmt.Sprintf("it is level-%d", r.Level)
+	}
+	for _, p := range []Property{
+		{Name: TreeChild, Reason: treeChild},
+		{Name: TreeBased, Reason: n.checkTreeBased()},
+		{Name: Normal, Reason: normal},
+		{Name: GalledTree, Reason: galled},
+		{Name: Level1, Reason: level1},
+	} {
+		p.Holds = p.Reason == ""
+		r.Properties = append(r.Properties, p)
+	}
+	return r
+}
+
+// Returns the biconnected components of the undirected graph of the
+// network, as their arcs (parent, child), with Tarjan's algorithm.
+func (n *Network) biconnectedComponents() [][][2]int {
+	type neighbour struct{ node, arc int }
+	arcs := make([][2]int, 0, n.Len())
+	adjacent := make([][]neighbour, n.Len())
+	for v := range n.Len() {
+		for _, c := range n.Children[v] {
+			adjacent[v] = append(adjacent[v], neighbour{c, len(arcs)})
+			adjacent[c] = append(adjacent[c], neighbour{v, len(arcs)})
+			arcs = append(arcs, [2]int{v, c})
+		}
+	}
+	discovered, low := make([]int, n.Len()), make([]int, n.Len())
+	for v := range discovered {
+		discovered[v] = -1
+	}
+	components := make([][][2]int, 0)
+	stack := make([]int, 0)
+	time := 0
+	var visit func(v, from int)
+	visit = func(v, from int) {
+		discovered[v], low[v] = time, time
+		time++
+		for _, u := range adjacent[v] {
+			if u.arc == from {
+				continue
+			}
+			if discovered[u.node] == -1 {
+				stack = append(stack, u.arc)
+				visit(u.node, u.arc)
+				low[v] = min(low[v], low[u.node])
+				if low[u.node] >= discovered[v] { // v separates the component of u.arc from the root
+					component := make([][2]int, 0)
+					for {
+						arc := stack[len(stack)-1]
+						stack = stack[:len(stack)-1]
+						component = append(component, arcs[arc])
+						if arc == u.arc {
+							break
+						}
+					}
+					components = append(components, component)
+				}
+			} else if discovered[u.node] < discovered[v] {
+				stack = append(stack, u.arc)
+				low[v] = min(low[v], discovered[u.node])
+			}
+		}
+	}
+	visit(n.Root, -1)
+	return components
+}
+
+// Returns why the network is not tree-child, or "" if it is.
+func (n *Network) checkTreeChild() string {
+	for v := range n.Len() {
+		if len(n.Children[v]) > 0 && !slices.ContainsFunc(n.Children[v], func(c int) bool { return len(n.Parents[c]) == 1 }) {
+			return fmt.Sprintf("every child of %s is a reticulation", n.describe(v))
+		}
+	}
+	return ""
+}
+
+// Returns why the network has a shortcut, a reticulation arc (u, v) with
+// another path from u to v, or "" if it has none.
+func (n *Network) checkShortcuts() string {
+	for v := range n.Len() {
+		for k, u := range n.Parents[v] {
+			for l, w := range n.Parents[v] {
+				if k != l && (u == w || n.descends(w, u)) {
+					return fmt.Sprintf("the arc from %s to %s is a shortcut", n.describe(u), n.describe(v))
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Returns why the network is not tree-based, or "" if it is. A spanning
+// tree keeps one parent of each node, and has no other leaves than the
+// network if every non-leaf node keeps a child of its own: a matching of
+// the non-leaf nodes into their children covering all of them.
+func (n *Network) checkTreeBased() string {
+	matched := make([]int, n.Len()) // parent matched to each node, or -1
+	for v := range matched {
+		matched[v] = -1
+	}
+	var augment func(v int, seen []bool) bool
+	augment = func(v int, seen []bool) bool {
+		for _, c := range n.Children[v] {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			if matched[c] == -1 || augment(matched[c], seen) {
+				matched[c] = v
+				return true
+			}
+		}
+		return false
+	}
+	for v := range n.Len() {
+		if len(n.Children[v]) > 0 && !augment(v, make([]bool, n.Len())) {
+			return fmt.Sprintf("no spanning tree keeps a child of %s without adding leaves", n.describe(v))
+		}
+	}
+	return ""
+}
+
+// Returns whether v is a descendant of u, or u itself.
+func (n *Network) descends(v, u int) bool {
+	visited := make([]bool, n.Len())
+	stack := []int{u}
+	for len(stack) > 0 {
+		w := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w == v {
+			return true
+		}
+		for _, c := range n.Children[w] {
+			if !visited[c] {
+				visited[c] = true
+				stack = append(stack, c)
+			}
+		}
+	}
+	return false
+}
+
+// Returns the labels of the leaves below v, sorted.
+func (n *Network) taxaBelow(v int) []string {
+	taxa := make([]string, 0)
+	visited := make([]bool, n.Len())
+	stack := []int{v}
+	for len(stack) > 0 {
+		w := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if len(n.Children[w]) == 0 {
+			taxa = append(taxa, n.Labels[w])
+		}
+		for _, c := range n.Children[w] {
+			if !visited[c] {
+				visited[c] = true
+				stack = append(stack, c)
+			}
+		}
+	}
+	slices.Sort(taxa)
+	return taxa
+}
+
+// Names a node in messages: by its label, or by the leaves below it.
+func (n *Network) describe(v int) string {
+	if n.Labels[v] != "" {
+		return n.Labels[v]
+	}
+	taxa := n.taxaBelow(v)
+	if len(taxa) > 3 {
+		taxa = append(taxa[:3], "...")
+	}
+	return "the node above " + strings.Join(taxa, ",")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/netest"
+)
+
+func newRunCommand() *cobra.Command {
+	var f pipelineFlags
+	var b backendFlags
+	var parallel int
+	cmd := &cobra.Command{
+		Use:   "run -a ALIGNMENT -d DIR",
+		Short: "Estimate a network in one step: setup, subproblem searches and finish",
+		Long: `Sets up the output directory, solves every subproblem with the built-in
+parsimony search, PAUP* or another backend, and reads the results into the
+final network. A
+directory set up earlier with the same alignment and settings is resumed.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			opts, aln, err := f.resolve(cmd)
+			if err != nil {
+				return err
+			}
+			if opts.Backend, err = b.backend(); err != nil {
+				return err
+			}
+			opts.Parallel = parallel
+			_, err = netest.Estimate(cmd.Context(), aln, opts)
+			return err
+		}),
+	}
+	f.addInput(cmd)
+	f.addSetup(cmd)
+	f.addFinish(cmd)
+	cmd.Flags().Lookup("seed").Usage = "random seed for the subproblem searches (0, the default, picks one from the clock, or reuses the one of a resumed directory)"
+	b.add(cmd, "builtin", true)
+	cmd.Flags().IntVarP(&parallel, "jobs", "j", runtime.NumCPU(), "subproblems solved at once")
+	return cmd
+}
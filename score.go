@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/alignio"
+	"lv1-netest/network"
+)
+
+func newScoreCommand() *cobra.Command {
+	var alignment string
+	var sites bool
+	cmd := &cobra.Command{
+		Use:   "score -a ALIGNMENT NETWORK...",
+		Short: "Compute the hardwired and softwired parsimony scores of networks",
+		Long: `Scores the level-1 networks of each file, in extended Newick, on a binary
+alignment: the hardwired score counts every arc whose ends differ, the
+softwired score is the best score of a displayed tree. Trees are scored too.
+finish scores its network, the SN-tree and the best tree found by the
+built-in search the same way, in parsimony.tsv.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runE(func(cmd *cobra.Command, args []string) error {
+			aln, err := alignio.Read(alignment)
+			if err != nil {
+				return fmt.Errorf("could not read alignment %s: %w", alignment, err)
+			}
+			if sites {
+				fmt.Println("network\tsite\thardwired\tsoftwired\tminimum\tkept\thomoplastic")
+			}
+			for _, file := range args {
+				networks, err := network.ReadNetworks(file)
+				if err != nil {
+					return err
+				}
+				for k, n := range networks {
+					name := file
+					if len(networks) > 1 {
+						name = fmt.Sprintf("%s network %d", file, k+1)
+					}
+					scores, err := network.ScoreParsimony(n, aln)
+					if err != nil {
+						return fmt.Errorf("%s: %w", name, err)
+					}
+					if !sites {
+						hardwired, softwired := scores.Total()
+						fmt.Printf("%s: %d hardwired, %d softwired\n", name, hardwired, softwired)
+						continue
+					}
+					for site := range scores.Hardwired {
+						kept := make([]string, 0)
+						for k, retic := range scores.Reticulations {
+							if parent := scores.Kept[site][k]; parent != -1 {
+								kept = append(kept, fmt.Sprintf("%s:%d", n.Labels[retic], parent+1))
+							}
+						}
+						slices.Sort(kept)
+						fmt.Printf("%s\t%d\t%d\t%d\t%d\t%s\t%t\n", name, site+1, scores.Hardwired[site], scores.Softwired[site],
+							scores.Minimum[site], strings.Join(kept, ","), scores.Homoplastic(site))
+					}
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&alignment, "alignment", "a", "", "alignment file")
+	cmd.Flags().BoolVar(&sites, "sites", false, "print a table of the scores of each site, its least score on any tree, the parent of each reticulation kept by its best displayed trees (from 1, in Newick order) and whether it is homoplastic")
+	cmd.MarkFlagRequired("alignment")
+	cmd.MarkFlagFilename("alignment", "nex", "nexus", "nxs", "fasta", "fa", "phy")
+	return cmd
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/alignio"
+	"lv1-netest/simulate"
+)
+
+func newSimulateCommand() *cobra.Command {
+	var dir string
+	var replicates int
+	settings := simulate.Settings{}
+	cmd := &cobra.Command{
+		Use:   "simulate -d DIR",
+		Short: "Simulate level-1 networks and two-state characters evolved along them",
+		Long: `Generates random rooted level-1 networks, a Yule tree with reticulation arcs
+added between its branches, and evolves two-state characters along them: each
+character follows a displayed tree, taking the reticulation arc of each cycle
+with probability --gamma. Every replicate is written to the output directory
+as replicate_<k>.nex and its true network as replicate_<k>_network.nwk, in
+extended Newick. Replicate k (from 1) uses the seed plus k-1.`,
+		Args: cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			if replicates < 1 {
+				return usageError{fmt.Errorf("%d replicates, expected at least 1", replicates)}
+			}
+			if settings.Seed == 0 {
+				settings.Seed = time.Now().UnixNano()
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			for k := range replicates {
+				s := settings
+				s.Seed += int64(k)
+				replicate, err := simulate.Simulate(s)
+				if err != nil {
+					return err
+				}
+				stem := filepath.Join(dir, fmt.Sprintf("replicate_%d", k+1))
+				if err := os.WriteFile(stem+".nex", []byte(alignio.WriteNexus(replicate.Alignment)), 0644); err != nil {
+					return err
+				}
+				if err := os.WriteFile(stem+"_network.nwk", []byte(fmt.Sprintf("[simulated %s]\n%s\n", s, replicate.Network)), 0644); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("%d replicates of %d taxa and %d sites written to %s (seed %d)\n", replicates, settings.Taxa, settings.Sites, dir, settings.Seed)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "output directory")
+	cmd.Flags().IntVarP(&replicates, "replicates", "n", 1, "number of replicates")
+	cmd.Flags().IntVar(&settings.Taxa, "taxa", 12, "number of taxa")
+	cmd.Flags().IntVar(&settings.Cycles, "cycles", 1, "number of reticulation cycles")
+	cmd.Flags().IntVar(&settings.Sites, "sites", 500, "number of characters")
+	cmd.Flags().StringVar(&settings.Model, "model", "cf", modelUsage)
+	cmd.Flags().Float64Var(&settings.Rate, "rate", 1, rateUsage)
+	addSimulationFlags(cmd, &settings)
+	cmd.Flags().Int64Var(&settings.Seed, "seed", 0, "random seed (0 picks one; it is recorded in each network file)")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagDirname("dir")
+	cmd.RegisterFlagCompletionFunc("model", completeValues(simulate.Models...))
+	return cmd
+}
+
+var (
+	modelUsage = "character model: " + strings.Join(simulate.Models, " (Cavender-Farris) or ") + " (one gain, then losses)"
+	rateUsage  = "changes (cf) or losses (dollo) per unit of branch length"
+)
+
+// Adds the simulation flags shared by simulate and benchmark.
+func addSimulationFlags(cmd *cobra.Command, settings *simulate.Settings) {
+	cmd.Flags().IntVar(&settings.MinCycle, "min-cycle", 4, "least number of nodes in a cycle, including the reticulation")
+	cmd.Flags().IntVar(&settings.MaxCycle, "max-cycle", 6, "largest number of nodes in a cycle")
+	cmd.Flags().Float64Var(&settings.BranchLength, "branch-length", 0.1, "mean branch length")
+	cmd.Flags().Float64Var(&settings.Gamma, "gamma", 0.5, "probability that a character follows the reticulation arc of a cycle")
+	cmd.Flags().BoolVar(&settings.Informative, "informative", false, "keep only characters with each state in at least two taxa")
+}
@@ -0,0 +1,436 @@
+// Package simulate generates random rooted level-1 networks and evolves
+// two-state characters along their displayed trees, as ground truth for the
+// estimator.
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/errs"
+	"lv1-netest/network"
+)
+
+// Models characters evolve under.
+var Models = []string{"cf", "dollo"}
+
+// Settings of a simulation.
+type Settings struct {
+	Taxa         int     // number of taxa, named taxon_1, taxon_2, ...
+	Cycles       int     // number of reticulation cycles
+	MinCycle     int     // least number of nodes in a cycle, at least 3
+	MaxCycle     int     // largest number of nodes in a cycle
+	Sites        int     // number of characters
+	Model        string  // "cf" (Cavender-Farris: symmetric changes) or "dollo" (a single gain, then losses)
+	Rate         float64 // changes (cf) or losses (dollo) per unit of branch length
+	BranchLength float64 // mean branch length, lengths being exponential
+	Gamma        float64 // probability that a character follows the reticulation arc added by a cycle
+	Informative  bool    // keep only characters with each state in at least two taxa
+	Seed         int64
+}
+
+// Returns the settings as key=value pairs, for provenance comments.
+func (s Settings) String() string {
+	return fmt.Sprintf("taxa=%d cycles=%d cycle_size=%d-%d sites=%d model=%s rate=%g branch_length=%g gamma=%g informative=%t seed=%d",
+		s.Taxa, s.Cycles, s.MinCycle, s.MaxCycle, s.Sites, s.Model, s.Rate, s.BranchLength, s.Gamma, s.Informative, s.Seed)
+}
+
+func (s Settings) check() error {
+	switch {
+	case s.Taxa < 3:
+		return fmt.Errorf("%d taxa, expected at least 3", s.Taxa)
+	case s.Cycles < 0:
+		return fmt.Errorf("%d cycles, expected at least 0", s.Cycles)
+	case s.MinCycle < 3 || s.MaxCycle < s.MinCycle:
+		return fmt.Errorf("cycle sizes %d-%d, expected at least 3 nodes", s.MinCycle, s.MaxCycle)
+	case s.Sites < 1:
+		return fmt.Errorf("%d sites, expected at least 1", s.Sites)
+	case !slices.Contains(Models, s.Model):
+		return fmt.Errorf("unknown model %q (expected one of %s)", s.Model, strings.Join(Models, ", "))
+	case s.Rate < 0 || s.BranchLength <= 0:
+		return fmt.Errorf("rate %g and branch length %g, expected a rate of at least 0 and a positive length", s.Rate, s.BranchLength)
+	case s.Gamma < 0 || s.Gamma > 1:
+		return fmt.Errorf("gamma %g, expected a probability", s.Gamma)
+	}
+	return nil
+}
+
+// A simulated data set.
+type Replicate struct {
+	Network   string // the true network, in extended Newick with branch lengths and inheritance probabilities
+	Alignment align.Alignment
+}
+
+// Generates a network and evolves characters along it.
+func Simulate(settings Settings) (*Replicate, error) {
+	if err := settings.check(); err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	rng := rand.New(rand.NewSource(settings.Seed))
+	g := yuleTree(settings.Taxa, settings.BranchLength, rng)
+	for c := range settings.Cycles {
+		if err := g.addCycle(settings, rng); err != nil {
+			return nil, errs.New(errs.ErrInvalidOption, "", fmt.Errorf("cycle %d: %w", c+1, err))
+		}
+	}
+	nwk := g.newick()
+	if parsed, err := network.ParseNetworks(nwk); err != nil {
+		return nil, errs.New(errs.ErrInternal, "", err)
+	} else if report := network.Validate(parsed[0]); !report.Holds(network.Level1) {
+		return nil, errs.New(errs.ErrInternal, "", fmt.Errorf("simulated network is level-%d", report.Level))
+	}
+	sites, err := g.evolve(settings, rng)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidOption, "", err)
+	}
+	aln := align.NewAlign(align.UNKNOWN)
+	for k, leaf := range g.leaves {
+		seq := make([]uint8, len(sites))
+		for i, site := range sites {
+			seq[i] = '0' + site[k]
+		}
+		aln.AddSequence(g.names[leaf], string(seq), "")
+	}
+	return &Replicate{Network: nwk, Alignment: aln}, nil
+}
+
+// A network under construction. Arcs are identified by their (parent,
+// child) nodes, as there are no parallel arcs.
+type graph struct {
+	names    []string // taxon names of the leaves, empty for other nodes
+	leaves   []int    // leaves in taxon order
+	parents  [][]int
+	children [][]int
+	length   map[[2]int]float64
+	gamma    map[[2]int]float64 // inheritance probability of the arcs into reticulations
+	inCycle  map[[2]int]bool
+}
+
+func (g *graph) newNode() int {
+	g.names = append(g.names, "")
+	g.parents = append(g.parents, nil)
+	g.children = append(g.children, nil)
+	return len(g.names) - 1
+}
+
+func (g *graph) addArc(u, v int, length float64) {
+	g.children[u] = append(g.children[u], v)
+	g.parents[v] = append(g.parents[v], u)
+	g.length[[2]int{u, v}] = length
+}
+
+// Returns a random rooted binary tree grown by splitting random leaves,
+// with node 0 as the root.
+func yuleTree(taxa int, meanLength float64, rng *rand.Rand) *graph {
+	g := &graph{length: make(map[[2]int]float64), gamma: make(map[[2]int]float64), inCycle: make(map[[2]int]bool)}
+	root := g.newNode()
+	leaves := []int{root}
+	for len(leaves) < taxa {
+		k := rng.Intn(len(leaves))
+		split := leaves[k]
+		if len(leaves) == 1 {
+			leaves = leaves[:0]
+		} else {
+			leaves[k] = leaves[len(leaves)-1]
+			leaves = leaves[:len(leaves)-1]
+		}
+		for range 2 {
+			child := g.newNode()
+			g.addArc(split, child, rng.ExpFloat64()*meanLength)
+			leaves = append(leaves, child)
+		}
+	}
+	for _, v := range rng.Perm(len(leaves)) {
+		g.names[leaves[v]] = "taxon_" + strconv.Itoa(len(g.leaves)+1)
+		g.leaves = append(g.leaves, leaves[v])
+	}
+	return g
+}
+
+// Adds a reticulation arc from a new node on one arc to a new node on
+// another, closing a cycle with the path between them. Only arcs on no cycle
+// are split, and the path must avoid the arcs of the other cycles, so the
+// cycles share no arc and the network stays level-1.
+func (g *graph) addCycle(settings Settings, rng *rand.Rand) error {
+	free := make([][2]int, 0)
+	for u := range g.children {
+		for _, v := range g.children[u] {
+			if !g.inCycle[[2]int{u, v}] {
+				free = append(free, [2]int{u, v})
+			}
+		}
+	}
+	type pair struct{ from, to [2]int }
+	candidates := make([]pair, 0)
+	for _, from := range free {
+		distance := [2][]int{g.freeDistances(from[0]), g.freeDistances(from[1])}
+		for _, to := range free {
+			if to == from || g.descends(from[0], to[1]) { // the new arc would close a directed cycle
+				continue
+			}
+			d := math.MaxInt
+			for _, x := range distance {
+				for _, y := range to {
+					if x[y] != -1 {
+						d = min(d, x[y])
+					}
+				}
+			}
+			if size := d + 3; d != math.MaxInt && size >= settings.MinCycle && size <= settings.MaxCycle {
+				candidates = append(candidates, pair{from, to})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no room for a cycle of %d to %d nodes", settings.MinCycle, settings.MaxCycle)
+	}
+	chosen := candidates[rng.Intn(len(candidates))]
+	source, hybrid := g.subdivide(chosen.from, rng), g.subdivide(chosen.to, rng)
+	for _, arc := range g.freePath(source, hybrid) {
+		g.inCycle[arc] = true
+	}
+	g.addArc(source, hybrid, rng.ExpFloat64()*settings.BranchLength)
+	g.inCycle[[2]int{source, hybrid}] = true
+	g.gamma[[2]int{source, hybrid}] = settings.Gamma
+	g.gamma[[2]int{g.parents[hybrid][0], hybrid}] = 1 - settings.Gamma
+	return nil
+}
+
+// Puts a new node at a random point of an arc, and returns it.
+func (g *graph) subdivide(arc [2]int, rng *rand.Rand) int {
+	u, v := arc[0], arc[1]
+	x := g.newNode()
+	length, f := g.length[arc], rng.Float64()
+	g.children[u][slices.Index(g.children[u], v)] = x
+	g.parents[v][slices.Index(g.parents[v], u)] = x
+	g.children[x], g.parents[x] = []int{v}, []int{u}
+	delete(g.length, arc)
+	g.length[[2]int{u, x}], g.length[[2]int{x, v}] = f*length, (1-f)*length
+	if gamma, reticulate := g.gamma[arc]; reticulate {
+		delete(g.gamma, arc)
+		g.gamma[[2]int{x, v}] = gamma
+	}
+	return x
+}
+
+// Returns the nodes adjacent to v through arcs on no cycle.
+func (g *graph) freeNeighbours(v int) []int {
+	neighbours := make([]int, 0, 3)
+	for _, c := range g.children[v] {
+		if !g.inCycle[[2]int{v, c}] {
+			neighbours = append(neighbours, c)
+		}
+	}
+	for _, p := range g.parents[v] {
+		if !g.inCycle[[2]int{p, v}] {
+			neighbours = append(neighbours, p)
+		}
+	}
+	return neighbours
+}
+
+// Returns the number of arcs on no cycle between v and every node, -1 for
+// the nodes such arcs do not reach.
+func (g *graph) freeDistances(v int) []int {
+	distance := make([]int, len(g.names))
+	for w := range distance {
+		distance[w] = -1
+	}
+	distance[v] = 0
+	for queue := []int{v}; len(queue) > 0; queue = queue[1:] {
+		for _, w := range g.freeNeighbours(queue[0]) {
+			if distance[w] == -1 {
+				distance[w] = distance[queue[0]] + 1
+				queue = append(queue, w)
+			}
+		}
+	}
+	return distance
+}
+
+// Returns the arcs on the path of arcs on no cycle from u to v.
+func (g *graph) freePath(u, v int) [][2]int {
+	previous := make([]int, len(g.names))
+	for w := range previous {
+		previous[w] = -1
+	}
+	previous[u] = u
+	for queue := []int{u}; len(queue) > 0 && previous[v] == -1; queue = queue[1:] {
+		for _, w := range g.freeNeighbours(queue[0]) {
+			if previous[w] == -1 {
+				previous[w] = queue[0]
+				queue = append(queue, w)
+			}
+		}
+	}
+	path := make([][2]int, 0)
+	for w := v; w != u; w = previous[w] {
+		if p := previous[w]; slices.Contains(g.children[p], w) {
+			path = append(path, [2]int{p, w})
+		} else {
+			path = append(path, [2]int{w, p})
+		}
+	}
+	return path
+}
+
+// Returns whether v is a descendant of u, or u itself.
+func (g *graph) descends(v, u int) bool {
+	if v == u {
+		return true
+	}
+	for _, c := range g.children[u] {
+		if g.descends(v, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the nodes in an order where parents come before their children.
+func (g *graph) order() []int {
+	parents := make([]int, len(g.names))
+	for v := range parents {
+		parents[v] = len(g.parents[v])
+	}
+	order := make([]int, 0, len(g.names))
+	for stack := []int{0}; len(stack) > 0; {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, v)
+		for _, c := range g.children[v] {
+			if parents[c]--; parents[c] == 0 {
+				stack = append(stack, c)
+			}
+		}
+	}
+	return order
+}
+
+// Returns the states (0 or 1) of the taxa, in taxon order, for each
+// site. Each site follows a displayed tree, where every reticulation keeps
+// its parent with the probability of the arc from it.
+func (g *graph) evolve(settings Settings, rng *rand.Rand) ([][]uint8, error) {
+	order, leaves := g.order(), g.leaves
+	sites := make([][]uint8, 0, settings.Sites)
+	state := make([]uint8, len(g.names))
+	kept := make([]int, len(g.names))
+	for attempts := 0; len(sites) < settings.Sites; attempts++ {
+		if attempts == 1000*settings.Sites {
+			return nil, fmt.Errorf("only %d informative sites in %d attempts; try a higher rate or longer branches", len(sites), attempts)
+		}
+		for v := range g.names {
+			kept[v] = -1
+			if len(g.parents[v]) == 1 {
+				kept[v] = g.parents[v][0]
+			} else if len(g.parents[v]) > 1 {
+				kept[v] = g.parents[v][0]
+				if r := rng.Float64(); r < g.gamma[[2]int{g.parents[v][1], v}] {
+					kept[v] = g.parents[v][1]
+				}
+			}
+		}
+		switch settings.Model {
+		case "cf":
+			state[0] = uint8(rng.Intn(2))
+			for _, v := range order[1:] {
+				p := (1 - math.Exp(-2*settings.Rate*g.length[[2]int{kept[v], v}])) / 2
+				state[v] = state[kept[v]]
+				if rng.Float64() < p {
+					state[v] = 1 - state[v]
+				}
+			}
+		case "dollo":
+			g.dollo(settings.Rate, order, kept, state, rng)
+		}
+		site := make([]uint8, len(leaves))
+		ones := 0
+		for k, v := range leaves {
+			site[k] = state[v]
+			ones += int(state[v])
+		}
+		if settings.Informative && (ones < 2 || ones > len(leaves)-2) {
+			continue
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// Evolves a Dollo character on the displayed tree kept: it is gained once,
+// at a point drawn uniformly on the tree, and each lineage below can lose it.
+func (g *graph) dollo(rate float64, order, kept []int, state []uint8, rng *rand.Rand) {
+	total := 0.0
+	for _, v := range order[1:] {
+		total += g.length[[2]int{kept[v], v}]
+	}
+	gain, gained := rng.Float64()*total, -1
+	remaining := 0.0 // length of the gain arc below the gain
+	for _, v := range order[1:] {
+		length := g.length[[2]int{kept[v], v}]
+		if gain < length {
+			gained, remaining = v, length-gain
+			break
+		}
+		gain -= length
+	}
+	state[0] = 0
+	for _, v := range order[1:] {
+		length := g.length[[2]int{kept[v], v}]
+		state[v] = state[kept[v]]
+		if v == gained {
+			state[v], length = 1, remaining
+		}
+		if state[v] == 1 && rng.Float64() < 1-math.Exp(-rate*length) {
+			state[v] = 0
+		}
+	}
+}
+
+// Writes the network as extended Newick. Each reticulation is written where
+// it is first reached, and as #H<k> at its other parents; the arcs into it
+// carry their inheritance probability.
+func (g *graph) newick() string {
+	tags := make(map[int]string)
+	for v := range g.names {
+		if len(g.parents[v]) > 1 {
+			tags[v] = "#H" + strconv.Itoa(len(tags)+1)
+		}
+	}
+	written := make(map[int]bool)
+	var b strings.Builder
+	var write func(v, parent int)
+	write = func(v, parent int) {
+		if !written[v] {
+			written[v] = true
+			if len(g.children[v]) > 0 {
+				b.WriteByte('(')
+				for k, c := range g.children[v] {
+					if k > 0 {
+						b.WriteByte(',')
+					}
+					write(c, v)
+				}
+				b.WriteByte(')')
+			}
+			b.WriteString(g.names[v])
+		}
+		b.WriteString(tags[v])
+		if parent != -1 {
+			arc := [2]int{parent, v}
+			b.WriteString(":" + strconv.FormatFloat(g.length[arc], 'g', 6, 64))
+			if gamma, reticulate := g.gamma[arc]; reticulate {
+				b.WriteString("::" + strconv.FormatFloat(gamma, 'g', 6, 64))
+			}
+		}
+	}
+	write(0, -1)
+	b.WriteByte(';')
+	return b.String()
+}
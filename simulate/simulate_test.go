@@ -0,0 +1,132 @@
+package simulate
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+
+	"lv1-netest/network"
+)
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{"tree", Settings{Taxa: 8, Cycles: 0, MinCycle: 3, MaxCycle: 3, Sites: 50, Model: "cf", Rate: 1, BranchLength: 0.2, Gamma: 0.3, Informative: true, Seed: 1}},
+		{"triangles", Settings{Taxa: 10, Cycles: 2, MinCycle: 3, MaxCycle: 3, Sites: 50, Model: "cf", Rate: 1, BranchLength: 0.2, Gamma: 0.3, Informative: true, Seed: 2}},
+		{"larger cycles", Settings{Taxa: 16, Cycles: 3, MinCycle: 4, MaxCycle: 6, Sites: 100, Model: "cf", Rate: 1, BranchLength: 0.2, Gamma: 0.25, Informative: true, Seed: 3}},
+		{"dollo", Settings{Taxa: 12, Cycles: 2, MinCycle: 4, MaxCycle: 8, Sites: 80, Model: "dollo", Rate: 0.5, BranchLength: 0.3, Gamma: 0.4, Informative: true, Seed: 4}},
+		{"uninformative sites kept", Settings{Taxa: 6, Cycles: 1, MinCycle: 3, MaxCycle: 5, Sites: 200, Model: "cf", Rate: 0.1, BranchLength: 0.1, Gamma: 0.5, Seed: 5}},
+	}
+	// an occurrence of a reticulation with the inheritance probability of its arc
+	reticulation := regexp.MustCompile(`(#H\d+):[^,();:]*::([^,();:]+)`)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := test.settings
+			replicate, err := Simulate(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := Simulate(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Network != replicate.Network || again.Alignment.String() != replicate.Alignment.String() {
+				t.Error("the same seed gave another replicate")
+			}
+
+			networks, err := network.ParseNetworks(replicate.Network)
+			if err != nil {
+				t.Fatalf("%s: %v", replicate.Network, err)
+			}
+			report := network.Validate(networks[0])
+			if !report.Holds(network.Level1) || report.Taxa != s.Taxa || report.Reticulations != s.Cycles || len(report.Blobs) != s.Cycles {
+				t.Errorf("%s: %s, %d blobs; expected a level-1 network of %d taxa and %d cycles", replicate.Network, report.Summary(), len(report.Blobs), s.Taxa, s.Cycles)
+			}
+			for k, blob := range report.Blobs {
+				if blob.Nodes != blob.Arcs || blob.Reticulations != 1 || blob.Nodes < s.MinCycle || blob.Nodes > s.MaxCycle {
+					t.Errorf("blob %d has %d nodes, %d arcs and %d reticulations, expected a cycle of %d to %d nodes", k+1, blob.Nodes, blob.Arcs, blob.Reticulations, s.MinCycle, s.MaxCycle)
+				}
+			}
+			gammas := make(map[string][]float64)
+			for _, match := range reticulation.FindAllStringSubmatch(replicate.Network, -1) {
+				gamma, err := strconv.ParseFloat(match[2], 64)
+				if err != nil {
+					t.Fatalf("%s: invalid inheritance probability %q", match[1], match[2])
+				}
+				gammas[match[1]] = append(gammas[match[1]], gamma)
+			}
+			if len(gammas) != s.Cycles {
+				t.Errorf("%d reticulations with inheritance probabilities, expected %d", len(gammas), s.Cycles)
+			}
+			for tag, values := range gammas {
+				if len(values) != 2 || !(values[0] == s.Gamma && values[1] == 1-s.Gamma || values[0] == 1-s.Gamma && values[1] == s.Gamma) {
+					t.Errorf("arcs into %s have inheritance probabilities %v, expected %g and %g", tag, values, s.Gamma, 1-s.Gamma)
+				}
+			}
+
+			aln := replicate.Alignment
+			if aln.NbSequences() != s.Taxa || aln.Length() != s.Sites {
+				t.Fatalf("%d sequences of %d sites, expected %d of %d", aln.NbSequences(), aln.Length(), s.Taxa, s.Sites)
+			}
+			ones, uninformative := make([]int, s.Sites), 0
+			aln.IterateChar(func(name string, seq []uint8) bool {
+				for i, c := range seq {
+					if c != '0' && c != '1' {
+						t.Fatalf("%s has %q at site %d", name, c, i+1)
+					}
+					ones[i] += int(c - '0')
+				}
+				return false
+			})
+			for _, n := range ones {
+				if n < 2 || n > s.Taxa-2 {
+					uninformative++
+				}
+			}
+			if s.Informative && uninformative > 0 {
+				t.Errorf("%d uninformative sites, expected none", uninformative)
+			} else if !s.Informative && uninformative == 0 {
+				t.Error("no uninformative sites at a low rate without the filter")
+			}
+		})
+	}
+}
+
+func TestSimulateSeeds(t *testing.T) {
+	s := Settings{Taxa: 10, Cycles: 2, MinCycle: 3, MaxCycle: 6, Sites: 20, Model: "cf", Rate: 1, BranchLength: 0.2, Gamma: 0.3, Informative: true, Seed: 1}
+	first, err := Simulate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Seed = 2
+	second, err := Simulate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Network == second.Network {
+		t.Errorf("seeds 1 and 2 gave the same network %s", first.Network)
+	}
+}
+
+func TestSimulateErrors(t *testing.T) {
+	valid := Settings{Taxa: 6, Cycles: 1, MinCycle: 3, MaxCycle: 4, Sites: 10, Model: "cf", Rate: 1, BranchLength: 0.2, Gamma: 0.3, Seed: 1}
+	tests := []struct {
+		name   string
+		change func(*Settings)
+	}{
+		{"too few taxa", func(s *Settings) { s.Taxa = 2 }},
+		{"cycle of 2 nodes", func(s *Settings) { s.MinCycle = 2 }},
+		{"unknown model", func(s *Settings) { s.Model = "jc" }},
+		{"gamma above 1", func(s *Settings) { s.Gamma = 1.5 }},
+		{"no room for the cycles", func(s *Settings) { s.Taxa, s.Cycles, s.MinCycle, s.MaxCycle = 3, 3, 8, 8 }},
+	}
+	for _, test := range tests {
+		s := valid
+		test.change(&s)
+		if _, err := Simulate(s); err == nil {
+			t.Errorf("%s: no error for %s", test.name, s)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package sntree builds the SN-tree of an alignment, the tree of its splits
+// that conflict with no other split, and finds its polytomies.
+package sntree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+	"lv1-netest/splits"
+)
+
+func Build(aln align.Alignment) (*tree.Tree, error) {
+	// filter out sites with more than two characters
+	// create tree, then add bipartitions per unique, non-conflicting site.
+	aln.Sort()
+	taxaNames := make([]string, len(aln.Sequences()))
+	for i, seq := range aln.Sequences() {
+		taxaNames[i] = seq.Name()
+	}
+	snSplits, err := snSplits(aln)
+	if err != nil {
+		return nil, err
+	}
+	// fmt.Printf("sn-splits %v\n", snSplits)
+	// PrintSplits(snSplits)
+	snTree, err := splits.BuildTree(snSplits, taxaNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not build the SN-tree: %w", err)
+	}
+	// fmt.Println(snTree)
+	return snTree, nil
+}
+
+func snSplits(aln align.Alignment) ([]*splits.Split, error) {
+	all, err := splits.FromAlignment(aln, nil)
+	// PrintSplits(all)
+	if err != nil { // shouldn't happen
+		return nil, err
+	}
+	conflicts := make(map[int]bool)
+	// fmt.Printf("%d\n", aln.Length())
+	for i := 0; i < len(all); i++ {
+		// if !conflicts[i] {
+		for j := i + 1; j < len(all); j++ {
+			// fmt.Println("pair", i, j)
+			if b, err := all[i].Conflict(all[j]); err != nil {
+				return nil, errs.New(errs.ErrInternal, "", err)
+			} else if b {
+				conflicts[i] = true
+				conflicts[j] = true
+				// break
+			}
+		}
+		// }
+	}
+	result := []*splits.Split{}
+	for i := range len(all) {
+		if !conflicts[i] {
+			// fmt.Println(i)
+			result = append(result, all[i])
+		}
+	}
+	return result, nil
+}
+
+// The split of a site of an alignment and the sites it conflicts with.
+type SiteSplit struct {
+	Split     *splits.Split // taxa in state 1, in sorted order; nil unless both sides have at least two taxa
+	Conflicts int           // number of sites whose split conflicts with this one
+}
+
+// Returns whether the split is an SN-split, one that conflicts with no
+// other split and is an edge of the SN-tree.
+func (s SiteSplit) SN() bool {
+	return s.Split != nil && s.Conflicts == 0
+}
+
+// Returns the split of every site of the alignment, with the conflicts
+// snSplits uses to choose the SN-splits.
+func SiteSplits(aln align.Alignment) ([]SiteSplit, error) {
+	result := make([]SiteSplit, aln.Length())
+	for site := range result {
+		ss, err := splits.FromAlignment(aln, []int{site})
+		if err != nil {
+			return nil, errs.New(errs.ErrInternal, "", err)
+		}
+		if len(ss) == 1 {
+			result[site].Split = ss[0]
+		}
+	}
+	for i := range result {
+		for j := i + 1; j < len(result); j++ {
+			if result[i].Split == nil || result[j].Split == nil {
+				continue
+			}
+			if conflict, err := result[i].Split.Conflict(result[j].Split); err != nil {
+				return nil, errs.New(errs.ErrInternal, "", err)
+			} else if conflict {
+				result[i].Conflicts++
+				result[j].Conflicts++
+			}
+		}
+	}
+	return result, nil
+}
+
+// A polytomy of the SN-tree.
+type Polytomy struct {
+	ID   string   // stable ID, see PolytomyID
+	Taxa []string // one taxon per subtree around the polytomy, the first of it by name
+}
+
+// Returns the label of the node of the polytomy with the given ID.
+func (p Polytomy) Label() string {
+	return "polytomy_" + p.ID
+}
+
+// Finds the polytomies of the SN-tree in post-order, labels each of their
+// nodes (see Polytomy.Label) and returns them.
+func Polytomies(snTree *tree.Tree) ([]Polytomy, error) {
+	// the tip index too: the star tree the SN-tree is built from indexes its
+	// tips by their initial names, not in the order of SortedTips
+	if err := snTree.ReinitIndexes(); err != nil {
+		return nil, errs.New(errs.ErrInternal, "", err)
+	}
+	poly := make([]Polytomy, 0)
+	taxaNames := snTree.SortedTips()
+	var err error
+	snTree.PostOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
+		if cur.Nneigh() > 3 {
+			var taxa []string
+			if taxa, err = polytomyTaxa(cur, taxaNames); err != nil {
+				err = errs.Polytomy(errs.ErrInternal, len(poly), "", err)
+				return false
+			}
+			p := Polytomy{ID: PolytomyID(taxa), Taxa: taxa}
+			cur.SetName(p.Label())
+			poly = append(poly, p)
+		}
+		return true
+	})
+	return poly, err
+}
+
+// Returns the first taxon of each subtree around a polytomy node, in edge order.
+func polytomyTaxa(cur *tree.Node, taxaNames []*tree.Node) ([]string, error) {
+	taxa := make([]string, 0, cur.Nneigh())
+	for _, edge := range cur.Edges() {
+		split := edge.Bitset()
+		if !split.Any() || split.All() {
+			return nil, errors.New("edge isn't a split") // really don't think this should happen
+		}
+		for i := range split.Len() {
+			if edge.Left() == cur && split.Test(i) {
+				taxa = append(taxa, taxaNames[i].Name())
+				break
+			} else if edge.Right() == cur && !split.Test(i) {
+				taxa = append(taxa, taxaNames[i].Name())
+				break
+			}
+		}
+	}
+	return taxa, nil
+}
+
+// Returns the ID of the polytomy with the given taxa, the first taxon by name
+// of each of its subtrees: a 64-bit hash of them, sorted, in 16 hexadecimal
+// digits, long enough for IDs not to collide. These are the taxa its
+// subproblems search, so polytomies with the same ID have the same
+// subproblems. Unlike the position of the polytomy in the SN-tree, the ID
+// does not change when taxa are added elsewhere; only when the polytomy
+// gains or loses a subtree or an added taxon becomes the first of one, which
+// changes its subproblems too. A hash of every taxon of each subtree would
+// change with any added taxon, as the subtrees around a node hold them all.
+// No two polytomies of a tree have the same taxa.
+func PolytomyID(taxa []string) string {
+	sorted := slices.Clone(taxa)
+	slices.Sort(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Finds the node of the polytomy with the given ID in an SN-tree whose
+// indexes are initialized, by the taxa around each polytomy rather than by
+// node labels. Polytomies set up before IDs existed have their index as
+// their ID, and are found by label.
+func FindPolytomy(snTree *tree.Tree, id string) (*tree.Node, error) {
+	taxaNames := snTree.SortedTips()
+	found := make([]*tree.Node, 0, 1)
+	for _, node := range snTree.Nodes() {
+		if node.Nneigh() <= 3 {
+			continue
+		}
+		taxa, err := polytomyTaxa(node, taxaNames)
+		if err != nil {
+			return nil, err
+		}
+		if PolytomyID(taxa) == id {
+			found = append(found, node)
+		}
+	}
+	if len(found) == 0 {
+		label := Polytomy{ID: id}.Label()
+		for _, node := range snTree.Nodes() {
+			if node.Name() == label {
+				found = append(found, node)
+			}
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%d polytomies of the SN-tree have ID %s, expected 1", len(found), id)
+	}
+	return found[0], nil
+}
+
+// func snSplits(aln align.Alignment) []int {
+// 	splits, err := CreateSplits(aln, nil)
+// 	if err != nil { // shouldn't happen
+// 		panic(err)
+// 	}
+// 	conflicts := make(map[int]bool)
+// 	fmt.Printf("%d\n", aln.Length())
+// 	for i := 0; i < aln.Length(); i++ {
+// 		if !conflicts[i] {
+// 			for j := i + 1; j < aln.Length(); j++ {
+// 				if conflict(i, j, aln) {
+// 					conflicts[i] = true
+// 					conflicts[j] = true
+// 					break
+// 				}
+// 			}
+// 		}
+// 	}
+// 	result := []int{}
+// 	for i := range aln.Length() {
+// 		if !conflicts[i] {
+// 			result = append(result, i)
+// 		}
+// 	}
+// 	return result
+// }
+
+// // could probably be optimized further
+// func conflict(i, j int, aln align.Alignment) bool {
+// 	sitePair, err := aln.SelectSites([]int{i, j})
+// 	if err != nil { // should never happen
+// 		panic(err)
+// 	}
+// 	comb := [4]bool{} // check whether four combinations of 00 01 10 11 are present
+// 	conflict := false
+// 	for _, pair := range sitePair.Sequences() {
+// 		// TODO: make sure I've checked that we're using the correct character set
+// 		comb[((pair.CharAt(0)-'0')*2)+(pair.CharAt(1)-'0')] = true
+// 		conflict = comb[0] && comb[1] && comb[2] && comb[3]
+// 		if conflict {
+// 			break
+// 		}
+// 	}
+// 	return conflict
+// }
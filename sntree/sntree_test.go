@@ -0,0 +1,59 @@
+package sntree
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/evolbioinfo/gotree/io/newick"
+)
+
+func TestPolytomyID(t *testing.T) {
+	// Each tree has one polytomy; same tells whether its ID is the one of
+	// the polytomy of the first tree.
+	tests := []struct {
+		name   string
+		newick string
+		taxa   string // the first taxon of each subtree, sorted
+		same   bool
+	}{
+		{"base", "(a,b,(c,d),(e,f));", "a,b,c,e", true},
+		{"taxon added inside a subtree", "(a,b,(c,(d,g)),(e,f));", "a,b,c,e", true},
+		{"taxon added as a subtree's first", "(a,b,(0,(c,d)),(e,f));", "0,a,b,e", false},
+		{"subtree added", "(a,b,g,(c,d),(e,f));", "a,b,c,e,g", false},
+		{"subtrees joined", "(a,(b,(c,d)),(e,f),g);", "a,b,e,g", false},
+		{"polytomy moved", "(a,b,(c,d,(e,f)));", "a,c,d,e", false},
+		{"rerooted", "((c,d),(e,f),a,b);", "a,b,c,e", true},
+	}
+	hex := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	var base string
+	for k, test := range tests {
+		snTree, err := newick.NewParser(strings.NewReader(test.newick)).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		polytomies, err := Polytomies(snTree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(polytomies) != 1 {
+			t.Fatalf("%s: %d polytomies, expected 1", test.name, len(polytomies))
+		}
+		p := polytomies[0]
+		if k == 0 {
+			base = p.ID
+		}
+		if !hex.MatchString(p.ID) {
+			t.Errorf("%s: ID %q is not 16 hexadecimal digits", test.name, p.ID)
+		}
+		if got := PolytomyID(strings.Split(test.taxa, ",")); got != p.ID {
+			t.Errorf("%s: ID %s, expected the one of taxa %s, %s", test.name, p.ID, test.taxa, got)
+		}
+		if (p.ID == base) != test.same {
+			t.Errorf("%s: ID %s, the one of the base tree %s; expected the same: %t", test.name, p.ID, base, test.same)
+		}
+		if node, err := FindPolytomy(snTree, p.ID); err != nil || node.Name() != p.Label() {
+			t.Errorf("%s: found %v (%v) rather than the polytomy", test.name, node, err)
+		}
+	}
+}
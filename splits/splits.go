@@ -1,21 +1,32 @@
-package main
+// Package splits represents bipartitions of taxa, built from the sites of a
+// two-state alignment or from the edges of a tree.
+package splits
 
 import (
+	"errors"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/evolbioinfo/goalign/align"
 	"github.com/evolbioinfo/gotree/tree"
 	"github.com/fredericlemoine/bitset"
+
+	"lv1-netest/errs"
 )
 
 type Split struct {
 	split *bitset.BitSet
 }
 
+// Returns the split of the taxa set in bs, indexed in sorted taxon order.
+func New(bs *bitset.BitSet) *Split {
+	return &Split{split: bs}
+}
+
 // Creates list of splits from alignment.
 // Selects all sites if sites is nil.
-func CreateSplits(aln align.Alignment, sites []int) ([]*Split, error) {
+func FromAlignment(aln align.Alignment, sites []int) ([]*Split, error) {
 	aln.Sort()
 	if sites != nil {
 		var err error
@@ -44,7 +55,7 @@ func CreateSplits(aln align.Alignment, sites []int) ([]*Split, error) {
 	return result, nil
 }
 
-func SplitsFromTree(tree *tree.Tree) []*Split {
+func FromTree(tree *tree.Tree) []*Split {
 	result := make([]*Split, 0)
 	for _, e := range tree.Edges() {
 		result = append(result, &Split{split: e.Bitset()})
@@ -135,9 +146,9 @@ func GetClade(bs bitset.BitSet, taxa []string, complement bool) []string {
 }
 
 // TODO: optimize this later
-func CountMatches(ss []*Split, split *Split) int {
-	if ss[0].Length() != split.Length() {
-		panic("split lengths not equal")
+func CountMatches(ss []*Split, split *Split) (int, error) {
+	if len(ss) > 0 && ss[0].Length() != split.Length() {
+		return 0, errs.New(errs.ErrInternal, "", fmt.Errorf("split lengths %d and %d do not match", ss[0].Length(), split.Length()))
 	}
 	// fmt.Println("split", split.split.String())
 	count := 0
@@ -148,13 +159,13 @@ func CountMatches(ss []*Split, split *Split) int {
 			count++
 		}
 	}
-	return count
+	return count, nil
 }
 
 func BuildTree(splits []*Split, taxa []string) (*tree.Tree, error) {
 	starTree, err := tree.StarTree(len(taxa))
-	if err != nil { // only happens if there is less than two taxa, which shouldn't happen
-		panic(err)
+	if err != nil { // only happens if there is less than two taxa
+		return nil, errs.New(errs.ErrInvalidInput, "", fmt.Errorf("cannot build a tree on %d taxa: %w", len(taxa), err))
 	}
 	i := 0
 	starTree.PostOrder(func(cur, prev *tree.Node, e *tree.Edge) (keep bool) {
@@ -165,7 +176,7 @@ func BuildTree(splits []*Split, taxa []string) (*tree.Tree, error) {
 		return true
 	})
 	if len(taxa) != i {
-		panic("there should be as many leaves in the star tree as taxa")
+		return nil, errs.New(errs.ErrInternal, "", errors.New("there should be as many leaves in the star tree as taxa"))
 	}
 	// fmt.Print("build tree")
 	// PrintSplits(splits)
@@ -181,7 +192,7 @@ func BuildTree(splits []*Split, taxa []string) (*tree.Tree, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error building tree: %w", err)
 		} else if !monophyletic {
-			return nil, fmt.Errorf("splits are not compatible")
+			return nil, errs.New(errs.ErrIncompatibleSplits, "", fmt.Errorf("clade %s", strings.Join(clade, ",")))
 		}
 		starTree.AddBipartition(node, edges, 1.0, 1.0)
 	}
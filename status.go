@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/subproblem"
+)
+
+func newStatusCommand() *cobra.Command {
+	var polytomyDir, listFile, cacheDir string
+	cmd := &cobra.Command{
+		Use:   "status -d DIR",
+		Short: "Show which subproblem runs of a setup directory are complete",
+		Args:  cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			cache, err := subproblem.OpenSetupCache(polytomyDir, cacheDir)
+			if err != nil {
+				return err
+			}
+			jobs, err := subproblem.Status(polytomyDir, cache)
+			if err != nil {
+				return err
+			}
+			pending := make([]string, 0)
+			for k, job := range jobs {
+				if k == 0 || jobs[k-1].Polytomy != job.Polytomy {
+					total, done, cached := 0, 0, 0
+					for _, other := range jobs {
+						if other.Polytomy == job.Polytomy {
+							total++
+							if other.Done() {
+								done++
+							}
+							if other.Cached {
+								cached++
+							}
+						}
+					}
+					fmt.Printf("polytomy %d (%s): %d/%d runs complete (%d from cache)\n", job.Polytomy, job.PolytomyID, done, total, cached)
+				}
+				if !job.Done() {
+					fmt.Printf("\t%s (without %s): %s\n", filepath.Base(job.Input), job.Removed, strings.Join(job.Problems, ", "))
+					pending = append(pending, job.Input)
+				}
+			}
+			fmt.Printf("%d of %d runs still need to be run\n", len(pending), len(jobs))
+			if listFile != "" {
+				content := strings.Join(pending, "\n")
+				if len(pending) > 0 {
+					content += "\n"
+				}
+				return os.WriteFile(listFile, []byte(content), 0644)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&polytomyDir, "dir", "d", "", "directory created by setup")
+	cmd.Flags().StringVarP(&listFile, "output", "o", "", "write the input files that still need to be run to this file, one per line")
+	cmd.Flags().StringVar(&cacheDir, "cache", "", "subproblem cache (default: the one used in setup, if any)")
+	cmd.MarkFlagRequired("dir")
+	cmd.MarkFlagDirname("dir")
+	return cmd
+}
@@ -0,0 +1,151 @@
+package subproblem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+	"lv1-netest/sntree"
+)
+
+// One leave-one-out subproblem of a polytomy: its taxa but one, searched for
+// their best trees.
+type Subproblem struct {
+	Polytomy   int      // index of the polytomy, in SN-tree post-order
+	PolytomyID string   // stable ID of the polytomy, see sntree.PolytomyID
+	Candidate  int      // index of Removed in taxa_<PolytomyID>.txt
+	Removed    string   // taxon left out of this subproblem
+	Taxa       []string // taxa searched, in polytomy order
+	Stem       string   // file stem, e.g. polytomy_3f9a1c2e_3
+	Seed       int64    // zero if not known, e.g. when only reading results
+	Criterion  Criterion
+}
+
+// Returns subproblem j of polytomy i, the one without its taxon j.
+func NewSubproblem(i, j int, polytomy sntree.Polytomy) Subproblem {
+	taxa := make([]string, 0, len(polytomy.Taxa)-1)
+	taxa = append(taxa, polytomy.Taxa[:j]...)
+	taxa = append(taxa, polytomy.Taxa[j+1:]...)
+	return Subproblem{Polytomy: i, PolytomyID: polytomy.ID, Candidate: j, Removed: polytomy.Taxa[j], Taxa: taxa,
+		Stem: fmt.Sprintf("%s_%d", polytomy.Label(), j)}
+}
+
+// Trees found by the search of a subproblem. Scores.Tree[k] is the position
+// in Trees, counting from 1, of the tree scored in row k.
+type ScoredTrees struct {
+	Scores   *ScoreTable
+	Trees    []*tree.Tree
+	TreeFile string // file the trees were read from
+}
+
+// A tree search program solving subproblems. The rest of the pipeline only
+// sees the scored trees it returns, so closing cycles and assembling the
+// network do not depend on the program.
+type SearchBackend interface {
+	Name() string
+	Inputs(stem string) []string  // files Prepare writes, relative to the setup directory
+	Outputs(stem string) []string // files a search writes, which Results reads
+	// Writes the input of sub, with its sequences taken from aln, to dir.
+	Prepare(dir string, sub Subproblem, aln align.Alignment, settings SearchSettings) error
+	// Searches the subproblem prepared in dir, writing its outputs there.
+	Solve(ctx context.Context, dir string, sub Subproblem) error
+	// Reads the output of sub in dir. Missing output is an error of kind
+	// errs.ErrMissingOutput, output that cannot be used of errs.ErrInvalidOutput.
+	Results(dir string, sub Subproblem) (*ScoredTrees, error)
+}
+
+// Names of the backends NewBackend returns.
+var Backends = []string{"builtin", "paup", "tnt", "iqtree", "raxml", "command"}
+
+// Programs run by the backends.
+type Programs struct {
+	PAUP      string   // PAUP* executable; "paup" if empty
+	PAUPArgs  []string // arguments given to PAUP* before the input file
+	TNT       string   // TNT executable; "tnt" if empty
+	TNTArgs   []string // arguments given to TNT before the run script
+	IQTree    string   // IQ-TREE executable; "iqtree2" if empty
+	IQArgs    []string // arguments given to IQ-TREE after the backend's own
+	RAxML     string   // RAxML-NG executable; "raxml-ng" if empty
+	RAxMLArgs []string // arguments given to RAxML-NG after the backend's own
+	Command   string   // command of the command backend, see CommandBackend
+}
+
+// Returns the backend called name, running the given programs.
+func NewBackend(name string, programs Programs) (SearchBackend, error) {
+	switch name {
+	case "builtin":
+		return BuiltinBackend{}, nil
+	case "paup":
+		return PAUPBackend{Program: programs.PAUP, Args: programs.PAUPArgs}, nil
+	case "tnt":
+		return TNTBackend{Program: programs.TNT, Args: programs.TNTArgs}, nil
+	case "iqtree":
+		return IQTreeBackend{Program: programs.IQTree, Args: programs.IQArgs}, nil
+	case "raxml":
+		return RAxMLBackend{Program: programs.RAxML, Args: programs.RAxMLArgs}, nil
+	case "command":
+		return CommandBackend{Command: programs.Command}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q (expected one of %s)", name, strings.Join(Backends, ", "))
+}
+
+// Returns the backend a setup directory was prepared for, as recorded in its
+// manifest, which may be nil. It runs no programs, but reads their results.
+func SetupBackend(m *Manifest) SearchBackend {
+	b, err := NewBackend(m.backend(), Programs{})
+	if err != nil {
+		return PAUPBackend{}
+	}
+	return b
+}
+
+// Returns an error if b cannot search with criterion c.
+func CheckCriterion(b SearchBackend, c Criterion) error {
+	switch b.(type) {
+	case BuiltinBackend:
+		_, err := NewBuiltinSearch(c, 0)
+		return err
+	case TNTBackend:
+		if _, ok := tntCharacterCodes[c.Name]; !ok {
+			return fmt.Errorf("the tnt backend only supports the unord and wagner criteria, not %s", c.Name)
+		}
+	case IQTreeBackend, RAxMLBackend:
+		if _, ok := iqtreeModels[c.Name]; !ok {
+			return fmt.Errorf("the %s backend only supports the mk and gtr2 criteria, not %s", b.Name(), c.Name)
+		}
+	case PAUPBackend:
+		if c.Name == "gtr2" {
+			return fmt.Errorf("the gtr2 criterion needs the iqtree or raxml backend")
+		}
+	}
+	return nil
+}
+
+// Returns the taxa of sub with their sequences in aln.
+func subAlignment(aln align.Alignment, sub Subproblem) (align.Alignment, error) {
+	out := align.NewAlign(align.UNKNOWN)
+	for _, name := range sub.Taxa {
+		seq, exists := aln.GetSequenceByName(name)
+		if !exists {
+			return nil, errs.NoSequence(sub.Polytomy, name)
+		}
+		out.AddSequence(name, seq.Sequence(), "")
+	}
+	return out, nil
+}
+
+// Returns an error if a file a search should have written to dir is not there.
+func checkOutputs(dir string, b SearchBackend, stem string) error {
+	for _, file := range b.Outputs(stem) {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return fmt.Errorf("%s did not write %s", b, file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,605 @@
+package subproblem
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"lv1-netest/internal/atomicfile"
+)
+
+// Solves subproblems with BuiltinSearch, on the same files as PAUPBackend.
+type BuiltinBackend struct {
+	nexusFiles
+	Preset string // PAUP* preset whose maxtrees and nreps the search uses; default if empty
+}
+
+// maxtrees and nreps of the PAUP* presets.
+var builtinPresets = map[string][2]int{"fast": {10, 5}, "default": {100, 25}, "thorough": {1000, 100}}
+
+func (BuiltinBackend) Name() string { return "builtin" }
+
+func (BuiltinBackend) String() string { return "the built-in search" }
+
+func (b BuiltinBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	search, err := NewBuiltinSearch(sub.Criterion, sub.Seed)
+	if err != nil {
+		return err
+	}
+	if preset, ok := builtinPresets[b.Preset]; ok {
+		search.MaxTrees, search.Replicates = preset[0], preset[1]
+	} else if b.Preset != "" {
+		return fmt.Errorf("%q is not a preset (%s)", b.Preset, strings.Join(Presets, ", "))
+	}
+	return search.Run(ctx, filepath.Join(dir, sub.Stem+".nex"), sub.Stem+"_scores.tsv", sub.Stem+"_trees.nex")
+}
+
+// Parsimony search of a polytomy subproblem without PAUP*. It reads the data
+// block of the subproblem's .nex file and writes score and tree files in the
+// format PAUP* writes them, so the finish step reads both the same way. The
+// PAUP* block is ignored: the search is exhaustive for small subproblems and
+// otherwise uses random addition sequence replicates followed by SPR swapping.
+type BuiltinSearch struct {
+	Criterion  Criterion
+	Seed       int64
+	MaxTrees   int // most equally parsimonious trees kept, like PAUP*'s maxtrees
+	Replicates int // random addition sequence replicates of the heuristic search
+	Exhaustive int // subproblems with at most this many taxa are searched exhaustively
+}
+
+// Returns a search with the settings of the default PAUP* preset.
+func NewBuiltinSearch(criterion Criterion, seed int64) (BuiltinSearch, error) {
+	if criterion.Name != "unord" && criterion.Name != "wagner" {
+		return BuiltinSearch{}, fmt.Errorf("the built-in engine only supports the unord and wagner criteria, not %s; use PAUP* instead", criterion.Name)
+	}
+	return BuiltinSearch{Criterion: criterion, Seed: seed, MaxTrees: 100, Replicates: 25, Exhaustive: 8}, nil
+}
+
+// Searches the subproblem in input and writes scoreFile and treeFile next to it.
+func (s BuiltinSearch) Run(ctx context.Context, input, scoreFile, treeFile string) error {
+	names, seqs, err := readMatrix(input)
+	if err != nil {
+		return err
+	}
+	data, best, err := s.search(ctx, seqs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	length := data.length(best[0])
+	dir := filepath.Dir(input)
+	var scores strings.Builder
+	scores.WriteString("Tree\tLength\tCI\tRI\tRC\tHI\n")
+	ci, ri := data.indices(length)
+	for k := range best {
+		fmt.Fprintf(&scores, "%d\t%d\t%f\t%f\t%f\t%f\n", k+1, length, ci, ri, ci*ri, 1-ci)
+	}
+	if err := atomicfile.Write(filepath.Join(dir, scoreFile), []byte(scores.String())); err != nil {
+		return err
+	}
+	var trees strings.Builder
+	trees.WriteString("#NEXUS\n\nBegin trees;  [Treefile saved by lv1-netest built-in search]\n\tTranslate\n")
+	for k, name := range names {
+		sep := ","
+		if k == len(names)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&trees, "\t\t%d %s%s\n", k+1, nexusName(name), sep)
+	}
+	trees.WriteString("\t\t;\n")
+	for k, t := range best {
+		fmt.Fprintf(&trees, "tree PAUP_%d = [&U] %s;\n", k+1, t.newick(nil))
+	}
+	trees.WriteString("End;\n")
+	return atomicfile.Write(filepath.Join(dir, treeFile), []byte(trees.String()))
+}
+
+// Searches the sequences of the given taxa and returns the shortest trees
+// found, in Newick, and their length.
+func (s BuiltinSearch) Search(ctx context.Context, names, seqs []string) ([]string, int, error) {
+	data, best, err := s.search(ctx, seqs)
+	if err != nil {
+		return nil, 0, err
+	}
+	trees := make([]string, len(best))
+	for k, t := range best {
+		trees[k] = t.newick(names) + ";"
+	}
+	return trees, data.length(best[0]), nil
+}
+
+func (s BuiltinSearch) search(ctx context.Context, seqs []string) (*parsimonyData, []*ptree, error) {
+	if len(seqs) < 3 {
+		return nil, nil, fmt.Errorf("a tree search needs at least 3 taxa, found %d", len(seqs))
+	}
+	data, err := newParsimonyData(seqs, s.Criterion.Name == "wagner")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(seqs) <= s.Exhaustive {
+		return data, s.exhaustive(data, len(seqs)), nil
+	}
+	best, err := s.heuristic(ctx, data, len(seqs))
+	return data, best, err
+}
+
+// Reads the taxa and sequences in the matrix command of a NEXUS file written by WritePolytomies.
+func readMatrix(input string) ([]string, []string, error) {
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	text := string(content)
+	start := strings.Index(strings.ToLower(text), "matrix")
+	if start == -1 {
+		return nil, nil, fmt.Errorf("%s: no matrix", input)
+	}
+	end := strings.Index(text[start:], ";")
+	if end == -1 {
+		return nil, nil, fmt.Errorf("%s: matrix is not terminated", input)
+	}
+	names, seqs := make([]string, 0), make([]string, 0)
+	for _, line := range strings.Split(text[start+len("matrix"):start+end], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		names = append(names, strings.Trim(fields[0], "'"))
+		seqs = append(seqs, strings.Join(fields[1:], ""))
+		if len(seqs[len(seqs)-1]) != len(seqs[0]) {
+			return nil, nil, fmt.Errorf("%s: sequence of %s has %d characters, expected %d", input, fields[0], len(seqs[len(seqs)-1]), len(seqs[0]))
+		}
+	}
+	return names, seqs, nil
+}
+
+func nexusName(name string) string {
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !(r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) == -1 {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+const missingState = 0xff
+
+// Site patterns of a matrix, scored with Fitch parsimony for unordered
+// characters and Farris' interval method for ordered ones.
+type parsimonyData struct {
+	ordered bool
+	tips    [][]uint16 // state set of each taxon at each variable pattern; for ordered characters lo<<8|hi
+	weights []int      // number of sites with each pattern
+	min     int        // least possible length, over all sites
+	max     int        // length on a star tree, over all sites
+	sets    [][]uint16 // state sets of each node, reused across trees
+}
+
+func newParsimonyData(seqs []string, ordered bool) (*parsimonyData, error) {
+	d := &parsimonyData{ordered: ordered, tips: make([][]uint16, len(seqs))}
+	index := make(map[string]int)
+	column := make([]byte, len(seqs))
+	for site := range len(seqs[0]) {
+		for k, seq := range seqs {
+			switch c := seq[site]; {
+			case c >= '0' && c <= '9':
+				column[k] = c - '0'
+			case c == '?' || c == '-':
+				column[k] = missingState
+			default:
+				return nil, fmt.Errorf("unsupported character %q at site %d", c, site+1)
+			}
+		}
+		lo, hi := d.addSite(column)
+		if lo == hi {
+			continue // constant sites have no length on any tree
+		}
+		if p, exists := index[string(column)]; exists {
+			d.weights[p]++
+			continue
+		}
+		index[string(column)] = len(d.weights)
+		d.weights = append(d.weights, 1)
+		for k, state := range column {
+			d.tips[k] = append(d.tips[k], d.tipSet(state, lo, hi))
+		}
+	}
+	return d, nil
+}
+
+// Adds the least and star tree lengths of a site and returns its state range.
+func (d *parsimonyData) addSite(column []byte) (byte, byte) {
+	counts := make([]int, 10)
+	lo, hi, known := byte(9), byte(0), 0
+	for _, state := range column {
+		if state != missingState {
+			counts[state]++
+			lo, hi, known = min(lo, state), max(hi, state), known+1
+		}
+	}
+	if known == 0 {
+		return 0, 0
+	}
+	if d.ordered {
+		d.min += int(hi - lo)
+		star := math.MaxInt
+		for median := lo; median <= hi; median++ {
+			steps := 0
+			for state, n := range counts {
+				steps += n * max(state-int(median), int(median)-state)
+			}
+			star = min(star, steps)
+		}
+		d.max += star
+	} else {
+		d.min += countNonZero(counts) - 1
+		d.max += known - slices.Max(counts)
+	}
+	return lo, hi
+}
+
+func countNonZero(counts []int) int {
+	n := 0
+	for _, c := range counts {
+		if c > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *parsimonyData) tipSet(state, lo, hi byte) uint16 {
+	if d.ordered {
+		if state == missingState {
+			return uint16(lo)<<8 | uint16(hi)
+		}
+		return uint16(state)<<8 | uint16(state)
+	}
+	if state == missingState {
+		return 0x3ff
+	}
+	return 1 << state
+}
+
+// Length of a tree, computing state sets from the tips up.
+func (d *parsimonyData) length(t *ptree) int {
+	if len(d.sets) != len(t.parent) {
+		d.sets = make([][]uint16, len(t.parent))
+		for node := range d.sets {
+			d.sets[node] = make([]uint16, len(d.weights))
+		}
+	}
+	sets := d.sets
+	var visit func(node int) int
+	visit = func(node int) int {
+		if t.left[node] == -1 {
+			copy(sets[node], d.tips[node])
+			return 0
+		}
+		steps := visit(t.left[node]) + visit(t.right[node])
+		a, b, out := sets[t.left[node]], sets[t.right[node]], sets[node]
+		for p := range a {
+			cost := 0
+			if d.ordered {
+				lo, hi := max(a[p]>>8, b[p]>>8), min(a[p]&0xff, b[p]&0xff)
+				if lo > hi {
+					cost, lo, hi = int(lo-hi), hi, lo
+				}
+				out[p] = lo<<8 | hi
+			} else if both := a[p] & b[p]; both != 0 {
+				out[p] = both
+			} else {
+				cost, out[p] = 1, a[p]|b[p]
+			}
+			steps += cost * d.weights[p]
+		}
+		return steps
+	}
+	return visit(t.root)
+}
+
+// Consistency and retention index of a tree of the given length, as in PAUP*'s pscores.
+func (d *parsimonyData) indices(length int) (float64, float64) {
+	ci, ri := 1.0, 1.0
+	if length > 0 {
+		ci = float64(d.min) / float64(length)
+	}
+	if d.max > d.min {
+		ri = float64(d.max-length) / float64(d.max-d.min)
+	}
+	return ci, ri
+}
+
+// Rooted binary tree on taxa 0..n-1, whose internal nodes are n..2n-2. The
+// root only matters for storage: trees are compared and written unrooted.
+type ptree struct {
+	parent, left, right []int // -1 where there is none
+	root                int
+	next                int // next unused internal node
+}
+
+// Returns the tree ((a,b),c) on three of n taxa, with the other taxa still to be inserted.
+func newPTree(n, a, b, c int) *ptree {
+	t := &ptree{parent: make([]int, 2*n-1), left: make([]int, 2*n-1), right: make([]int, 2*n-1), next: n}
+	for k := range t.parent {
+		t.parent[k], t.left[k], t.right[k] = -1, -1, -1
+	}
+	t.root = a
+	t.insert(a, b)
+	t.insert(t.root, c)
+	return t
+}
+
+func (t *ptree) clone() *ptree {
+	return &ptree{parent: slices.Clone(t.parent), left: slices.Clone(t.left), right: slices.Clone(t.right), root: t.root, next: t.next}
+}
+
+// Inserts taxon k on the edge above node v and returns the new internal node.
+func (t *ptree) insert(v, k int) int {
+	u := t.next
+	t.next++
+	t.replaceChild(t.parent[v], v, u)
+	t.left[u], t.right[u] = v, k
+	t.parent[v], t.parent[k] = u, u
+	return u
+}
+
+// Undoes the last insert, which added internal node u.
+func (t *ptree) undoInsert(u int) {
+	v, k := t.left[u], t.right[u]
+	t.replaceChild(t.parent[u], u, v)
+	t.parent[k] = -1
+	t.left[u], t.right[u], t.parent[u] = -1, -1, -1
+	t.next--
+}
+
+// Makes child take old's place under parent (or as root, if parent is -1).
+func (t *ptree) replaceChild(parent, old, child int) {
+	t.parent[child] = parent
+	if parent == -1 {
+		t.root = child
+	} else if t.left[parent] == old {
+		t.left[parent] = child
+	} else {
+		t.right[parent] = child
+	}
+}
+
+// Nodes of the tree other than the root, i.e. the edges taxa can be inserted on.
+func (t *ptree) edges() []int {
+	nodes := make([]int, 0, t.next)
+	var visit func(node int)
+	visit = func(node int) {
+		if node != t.root {
+			nodes = append(nodes, node)
+		}
+		if t.left[node] != -1 {
+			visit(t.left[node])
+			visit(t.right[node])
+		}
+	}
+	visit(t.root)
+	return nodes
+}
+
+// Moves the subtree at v onto the edge above w, returning a new tree, or nil
+// if that does not change the tree.
+func (t *ptree) spr(v, w int) *ptree {
+	p := t.parent[v]
+	if p == -1 || w == v || w == p {
+		return nil
+	}
+	sibling := t.left[p]
+	if sibling == v {
+		sibling = t.right[p]
+	}
+	if w == sibling {
+		return nil
+	}
+	for x := w; x != -1; x = t.parent[x] {
+		if x == v {
+			return nil // w is inside the moved subtree
+		}
+	}
+	s := t.clone()
+	s.replaceChild(s.parent[p], p, sibling)
+	s.replaceChild(s.parent[w], w, p)
+	s.left[p], s.right[p] = w, v
+	s.parent[w], s.parent[v] = p, p
+	return s
+}
+
+// Identifies the unrooted topology: its non-trivial splits, each written as
+// the side without taxon 0.
+func (t *ptree) key() string {
+	n := (len(t.parent) + 1) / 2
+	splits := make([]string, 0)
+	var visit func(node int) []byte
+	visit = func(node int) []byte {
+		side := make([]byte, n)
+		if t.left[node] == -1 {
+			side[node] = 1
+			return side
+		}
+		for _, child := range []int{t.left[node], t.right[node]} {
+			for k, in := range visit(child) {
+				side[k] |= in
+			}
+		}
+		size := bytesCount(side)
+		if node != t.root && size > 1 && size < n-1 {
+			split := slices.Clone(side)
+			if split[0] == 1 {
+				for k := range split {
+					split[k] ^= 1
+				}
+			}
+			splits = append(splits, string(split))
+		}
+		return side
+	}
+	visit(t.root)
+	slices.Sort(splits)
+	return strings.Join(slices.Compact(splits), "|")
+}
+
+func bytesCount(b []byte) int {
+	n := 0
+	for _, x := range b {
+		n += int(x)
+	}
+	return n
+}
+
+// Unrooted Newick with the names of the taxa or, if names is nil, taxa
+// numbered from 1 as in a PAUP* tree file with a translate table.
+func (t *ptree) newick(names []string) string {
+	var write func(node int) string
+	write = func(node int) string {
+		if t.left[node] == -1 && names != nil {
+			return nexusName(names[node])
+		}
+		if t.left[node] == -1 {
+			return fmt.Sprint(node + 1)
+		}
+		return "(" + write(t.left[node]) + "," + write(t.right[node]) + ")"
+	}
+	a, b := t.left[t.root], t.right[t.root]
+	if t.left[a] == -1 {
+		a, b = b, a
+	}
+	return "(" + write(t.left[a]) + "," + write(t.right[a]) + "," + write(b) + ")"
+}
+
+// Collects the shortest trees seen, up to maxTrees of them.
+type treePool struct {
+	maxTrees int
+	length   int
+	trees    []*ptree
+	seen     map[string]bool
+}
+
+func newTreePool(maxTrees int) *treePool {
+	return &treePool{maxTrees: maxTrees, length: math.MaxInt, seen: make(map[string]bool)}
+}
+
+// Adds a tree if it is at least as short as the pool's and new. Returns true if it was added.
+func (p *treePool) add(t *ptree, length int) bool {
+	if length > p.length {
+		return false
+	}
+	if length < p.length {
+		p.length, p.trees, p.seen = length, nil, make(map[string]bool)
+	}
+	key := t.key()
+	if p.seen[key] || len(p.trees) >= p.maxTrees {
+		return false
+	}
+	p.seen[key] = true
+	p.trees = append(p.trees, t.clone())
+	return true
+}
+
+func (s BuiltinSearch) exhaustive(data *parsimonyData, n int) []*ptree {
+	pool := newTreePool(s.MaxTrees)
+	// taxon 0 stays a child of the root, so inserting taxa on every edge but
+	// the one above it generates every unrooted tree once
+	t := newPTree(n, 1, 2, 0)
+	var add func(k int)
+	add = func(k int) {
+		if k == n {
+			pool.add(t, data.length(t))
+			return
+		}
+		for _, v := range t.edges() {
+			if v == 0 {
+				continue
+			}
+			u := t.insert(v, k)
+			add(k + 1)
+			t.undoInsert(u)
+		}
+	}
+	add(3)
+	return pool.trees
+}
+
+func (s BuiltinSearch) heuristic(ctx context.Context, data *parsimonyData, n int) ([]*ptree, error) {
+	rng := rand.New(rand.NewSource(s.Seed))
+	pool := newTreePool(s.MaxTrees)
+	for range s.Replicates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		t, length := s.climb(data, stepwise(data, n, rng))
+		pool.add(t, length)
+	}
+	// swap on every shortest tree to find the others of the same length
+	for k := 0; k < len(pool.trees); k++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		t, length := pool.trees[k], pool.length
+	swap:
+		for _, v := range t.edges() {
+			for _, w := range t.edges() {
+				if neighbour := t.spr(v, w); neighbour != nil {
+					if pool.add(neighbour, data.length(neighbour)); pool.length < length {
+						k = -1 // found a shorter tree, which replaced the pool
+						break swap
+					}
+				}
+			}
+		}
+	}
+	return pool.trees, nil
+}
+
+// Adds taxa in random order, each where it lengthens the tree least.
+func stepwise(data *parsimonyData, n int, rng *rand.Rand) *ptree {
+	order := rng.Perm(n)
+	t := newPTree(n, order[0], order[1], order[2])
+	for _, k := range order[3:] {
+		best, bestLength, ties := -1, math.MaxInt, 0
+		for _, v := range t.edges() {
+			u := t.insert(v, k)
+			length := data.length(t)
+			t.undoInsert(u)
+			if length < bestLength {
+				best, bestLength, ties = v, length, 1
+			} else if length == bestLength {
+				if ties++; rng.Intn(ties) == 0 {
+					best = v
+				}
+			}
+		}
+		t.insert(best, k)
+	}
+	return t
+}
+
+// SPR swapping until no rearrangement shortens the tree.
+func (s BuiltinSearch) climb(data *parsimonyData, t *ptree) (*ptree, int) {
+	length := data.length(t)
+	for improved := true; improved; {
+		improved = false
+		for _, v := range t.edges() {
+			for _, w := range t.edges() {
+				if neighbour := t.spr(v, w); neighbour != nil {
+					if l := data.length(neighbour); l < length {
+						t, length, improved = neighbour, l, true
+						break
+					}
+				}
+			}
+			if improved {
+				break
+			}
+		}
+	}
+	return t, length
+}
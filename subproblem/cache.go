@@ -0,0 +1,150 @@
+package subproblem
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/errs"
+	"lv1-netest/internal/atomicfile"
+)
+
+// Directory of search results for polytomy subproblems, keyed by a hash of
+// the subproblem, so that unchanged subproblems are not searched again when
+// an edited alignment is rerun. An entry holds the output files of a search,
+// named without the stem of the subproblem, e.g. scores.tsv for
+// polytomy_0_3_scores.tsv.
+type Cache struct {
+	dir string
+}
+
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create cache: %w", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Hashes a subproblem: its taxa and their sequences, in sorted order, and the
+// search settings and backend. The seed is left out, so results are reused
+// across seeds.
+func SubproblemHash(taxa []string, aln align.Alignment, settings SearchSettings) (string, error) {
+	sorted := slices.Clone(taxa)
+	slices.Sort(sorted)
+	var b strings.Builder
+	fmt.Fprintf(&b, "criterion %s\ntemplate %s\n", settings.Criterion.Name, settings.Template.Root.String())
+	if name := settings.Backend.Name(); name != "paup" { // so entries from before backends stay valid
+		fmt.Fprintf(&b, "backend %s\n", name)
+	}
+	for _, t := range sorted {
+		seq, exists := aln.GetSequenceByName(t)
+		if !exists {
+			return "", errs.NoSequence(-1, t)
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", t, seq.Sequence())
+	}
+	return textSHA256(b.String()), nil
+}
+
+func (c *Cache) entry(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// Entries are renamed into place once complete, so one that exists is complete.
+func (c *Cache) Has(hash string) bool {
+	_, err := os.Stat(c.entry(hash))
+	return err == nil
+}
+
+// Copies the cached results of a subproblem into dir under the output names for stem.
+func (c *Cache) Fetch(hash, dir, stem string) error {
+	files, err := os.ReadDir(c.entry(hash))
+	if err != nil {
+		return fmt.Errorf("could not read cache entry for %s: %w", stem, err)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(c.entry(hash), file.Name()))
+		if err != nil {
+			return fmt.Errorf("could not read cache entry for %s: %w", stem, err)
+		}
+		if err := atomicfile.Write(filepath.Join(dir, stem+"_"+file.Name()), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds the output files of stem in dir to the cache, unless it is already there.
+func (c *Cache) Store(hash, dir, stem string, outputs []string) error {
+	if c.Has(hash) {
+		return nil
+	}
+	tmp, err := os.MkdirTemp(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, name := range outputs {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(tmp, strings.TrimPrefix(name, stem+"_")), content, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(c.entry(hash)), 0755); err != nil {
+		return err
+	}
+	// another run may have stored the same subproblem in the meantime
+	if err := os.Rename(tmp, c.entry(hash)); err != nil && !errors.Is(err, fs.ErrExist) && !c.Has(hash) {
+		return err
+	}
+	return nil
+}
+
+// Opens the cache given on the command line or, if that is empty, the one
+// recorded in the manifest of a setup directory. Returns nil if there is neither.
+func OpenSetupCache(polytomyDir, cacheDir string) (*Cache, error) {
+	if cacheDir == "" {
+		manifest, err := ReadManifest(polytomyDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		cacheDir = manifest.Cache
+	}
+	if cacheDir == "" {
+		return nil, nil
+	}
+	return OpenCache(cacheDir)
+}
+
+// Copies cached results into a setup directory for every subproblem without output there.
+func (c *Cache) Pull(polytomyDir string, manifest *Manifest) (int, error) {
+	pulled := 0
+	backend := SetupBackend(manifest)
+	for _, job := range manifest.Jobs {
+		missing := replacing(polytomyDir, job.Stem) || slices.ContainsFunc(backend.Outputs(job.Stem), func(name string) bool {
+			_, err := os.Stat(filepath.Join(polytomyDir, name))
+			return err != nil
+		})
+		if missing && c.Has(job.Hash) {
+			if err := c.Fetch(job.Hash, polytomyDir, job.Stem); err != nil {
+				return pulled, err
+			}
+			if err := os.Remove(Replacing(polytomyDir, job.Stem)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return pulled, err
+			}
+			pulled++
+		}
+	}
+	return pulled, nil
+}
@@ -0,0 +1,50 @@
+package subproblem
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Solves subproblems with any program that reads the PAUP* input and writes
+// PAUP* score and tree files. Command is run by sh in the setup directory,
+// after replacing {input}, {stem}, {scores}, {trees}, {seed} and {criterion}
+// with the subproblem's (quoted) values, e.g.
+//
+//	mysearch --seed {seed} -o {trees} -s {scores} {input}
+type CommandBackend struct {
+	nexusFiles
+	Command string
+}
+
+func (CommandBackend) Name() string { return "command" }
+
+func (b CommandBackend) String() string { return strconv.Quote(b.Command) }
+
+func (b CommandBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	if b.Command == "" {
+		return fmt.Errorf("the command backend needs a command")
+	}
+	outputs := b.Outputs(sub.Stem)
+	command := strings.NewReplacer(
+		"{input}", shellQuote(sub.Stem+".nex"),
+		"{stem}", shellQuote(sub.Stem),
+		"{scores}", shellQuote(outputs[0]),
+		"{trees}", shellQuote(outputs[1]),
+		"{seed}", strconv.FormatInt(sub.Seed, 10),
+		"{criterion}", shellQuote(sub.Criterion.Name),
+	).Replace(b.Command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", command, err, lastLines(string(output), 10))
+	}
+	return checkOutputs(dir, b, sub.Stem)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
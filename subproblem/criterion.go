@@ -0,0 +1,72 @@
+package subproblem
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Optimality criterion used to search the polytomy subproblems.
+type Criterion struct {
+	Name       string
+	deftype    string // PAUP* character type, empty for likelihood
+	likelihood bool
+	Ranking    string // default ranking of the candidate trees
+}
+
+var criteria = []Criterion{
+	{Name: "unord", deftype: "unord", Ranking: "ci"},
+	{Name: "wagner", deftype: "ord", Ranking: "ci"},
+	{Name: "dollo", deftype: "dollo.up", Ranking: "ci"},       // 0 -> 1 happens once, e.g. gene gain or cognate birth
+	{Name: "camin-sokal", deftype: "irrev.up", Ranking: "ci"}, // 0 -> 1 only, no reversals
+	{Name: "mk", likelihood: true, Ranking: "lnl"},
+	{Name: "gtr2", likelihood: true, Ranking: "lnl"}, // Mk with estimated state frequencies; IQ-TREE and RAxML-NG only
+}
+
+func ParseCriterion(name string) (Criterion, error) {
+	for _, c := range criteria {
+		if strings.EqualFold(c.Name, name) {
+			return c, nil
+		}
+	}
+	return Criterion{}, fmt.Errorf("unknown criterion %q (expected one of %s)", name, strings.Join(CriterionNames(), ", "))
+}
+
+func CriterionNames() []string {
+	names := make([]string, len(criteria))
+	for i, c := range criteria {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func (c Criterion) paupAssumptions() string {
+	if c.deftype == "" {
+		return ""
+	}
+	return fmt.Sprintf(`begin assumptions;
+	options deftype=%s;
+ end;
+ `, c.deftype)
+}
+
+func (c Criterion) paupSet() string {
+	if c.likelihood {
+		return "set criterion=likelihood"
+	}
+	return "set criterion=parsimony"
+}
+
+// binary Mk model: equal rates and equal state frequencies
+func (c Criterion) paupModel() string {
+	if c.likelihood {
+		return "\n\tlset nst=1 basefreq=equal rates=equal;"
+	}
+	return ""
+}
+
+func (c Criterion) paupScores(scoreFile string) string {
+	if c.likelihood {
+		return fmt.Sprintf("lscores all/ scorefile=%s replace=yes;", scoreFile)
+	}
+	return fmt.Sprintf("pscores all/ ci ri rc hi scorefile=%s replace=yes;", scoreFile)
+}
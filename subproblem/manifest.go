@@ -0,0 +1,211 @@
+package subproblem
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/sntree"
+)
+
+const ManifestFile = "manifest.json"
+
+// Record of what produced a setup directory, checked again by the finish step.
+type Manifest struct {
+	Tool       string         `json:"tool"`
+	Version    string         `json:"version"`
+	Alignment  AlignmentInfo  `json:"alignment"`
+	Taxa       []string       `json:"taxa"`
+	Polytomies [][]string     `json:"polytomies"`             // taxa of each polytomy, in index order
+	IDs        []string       `json:"polytomy_ids,omitempty"` // ID of each polytomy; absent in setups from before IDs
+	Parameters SetupParameter `json:"parameters"`
+	Seed       int64          `json:"seed"`
+	Cache      string         `json:"cache,omitempty"` // cache directory used at setup
+	Jobs       []JobRecord    `json:"jobs"`
+}
+
+// A subproblem (polytomy_<id>_j) and the hash it is cached under.
+type JobRecord struct {
+	Stem   string `json:"stem"`
+	Hash   string `json:"hash"`
+	Seed   int64  `json:"seed"`
+	Cached bool   `json:"cached"` // found in the cache at setup, so no PAUP* input was written
+}
+
+type AlignmentInfo struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	Taxa   int    `json:"taxa"`
+	Sites  int    `json:"sites"`
+}
+
+type SetupParameter struct {
+	Criterion      string `json:"criterion"`
+	Template       string `json:"template"` // preset name or template file
+	TemplateSHA256 string `json:"template_sha256"`
+	Backend        string `json:"backend,omitempty"` // absent in setups from before backends, which were for PAUP*
+}
+
+func NewManifest(alnFile string, aln align.Alignment, polytomies []sntree.Polytomy, settings SearchSettings, jobs []JobRecord, cacheDir string) (*Manifest, error) {
+	hash, err := AlignmentSHA256(alnFile, aln)
+	if err != nil {
+		return nil, err
+	}
+	taxa := make([]string, 0, aln.NbSequences())
+	for _, seq := range aln.Sequences() {
+		taxa = append(taxa, seq.Name())
+	}
+	slices.Sort(taxa)
+	polytomyTaxa, ids := make([][]string, len(polytomies)), make([]string, len(polytomies))
+	for i, p := range polytomies {
+		polytomyTaxa[i], ids[i] = p.Taxa, p.ID
+	}
+	return &Manifest{
+		Tool:       "lv1-netest",
+		Version:    toolVersion(),
+		Alignment:  AlignmentInfo{File: alnFile, SHA256: hash, Taxa: aln.NbSequences(), Sites: aln.Length()},
+		Taxa:       taxa,
+		Polytomies: polytomyTaxa,
+		IDs:        ids,
+		Parameters: SetupParameter{
+			Criterion:      settings.Criterion.Name,
+			Template:       settings.Template.Name(),
+			TemplateSHA256: TemplateSHA256(settings.Template),
+			Backend:        settings.Backend.Name(),
+		},
+		Seed:  settings.Seed,
+		Cache: cacheDir,
+		Jobs:  jobs,
+	}, nil
+}
+
+func WriteManifest(dir string, m *Manifest) error {
+	content, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(content, '\n'), 0644)
+}
+
+// Reads the manifest of a setup directory; the error wraps fs.ErrNotExist if there is none.
+func ReadManifest(dir string) (*Manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, ManifestFile), err)
+	}
+	return m, nil
+}
+
+// Returns the first 12 characters of a hash, or all of it if it is shorter,
+// as it may come from a hand-edited manifest.
+func shortHash(hash string) string {
+	return hash[:min(len(hash), 12)]
+}
+
+// Checks that the alignment and polytomy taxa used by the finish step are the
+// ones setup wrote. alnFile is the file aln was read from, if any.
+func (m *Manifest) Verify(alnFile string, aln align.Alignment, polytomies []sntree.Polytomy) error {
+	hash, err := AlignmentSHA256(alnFile, aln)
+	if err != nil {
+		return err
+	}
+	if hash != m.Alignment.SHA256 {
+		return fmt.Errorf("alignment %s (sha256 %s) is not the one used in setup: %s (sha256 %s)", cmp.Or(alnFile, "given"), shortHash(hash), cmp.Or(m.Alignment.File, "given"), shortHash(m.Alignment.SHA256))
+	}
+	if len(polytomies) != len(m.Polytomies) {
+		return fmt.Errorf("found %d polytomies, but setup wrote %d", len(polytomies), len(m.Polytomies))
+	}
+	for i, polytomy := range m.Polytomies {
+		if !slices.Equal(polytomies[i].Taxa, polytomy) {
+			return fmt.Errorf("%s does not match polytomy %d in %s", taxaFile(polytomies[i].ID), i, ManifestFile)
+		}
+		if i < len(m.IDs) && polytomies[i].ID != m.IDs[i] {
+			return fmt.Errorf("polytomy %d has ID %s, but setup gave it ID %s", i, polytomies[i].ID, m.IDs[i])
+		}
+	}
+	return nil
+}
+
+// Returns the name of the backend the subproblems were prepared for.
+func (m *Manifest) backend() string {
+	if m == nil || m.Parameters.Backend == "" {
+		return "paup"
+	}
+	return m.Parameters.Backend
+}
+
+// Returns the record of the subproblem with the given stem, or nil.
+func (m *Manifest) Job(stem string) *JobRecord {
+	for k := range m.Jobs {
+		if m.Jobs[k].Stem == stem {
+			return &m.Jobs[k]
+		}
+	}
+	return nil
+}
+
+// One line summary embedded in the final outputs.
+func (m *Manifest) Provenance() string {
+	return fmt.Sprintf("lv1-netest version=%q alignment=%q alignment_sha256=%s criterion=%s backend=%s template=%q template_sha256=%s seed=%d",
+		m.Version, filepath.Base(m.Alignment.File), m.Alignment.SHA256, m.Parameters.Criterion, m.backend(), filepath.Base(m.Parameters.Template), m.Parameters.TemplateSHA256, m.Seed)
+}
+
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := []string{info.Main.Version}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version = append(version, s.Value)
+		} else if s.Key == "vcs.modified" && s.Value == "true" {
+			version = append(version, "modified")
+		}
+	}
+	return strings.Join(version, " ")
+}
+
+// Hashes the alignment file, or the sequences of aln if it was not read from a file.
+func AlignmentSHA256(file string, aln align.Alignment) (string, error) {
+	if file != "" {
+		return FileSHA256(file)
+	}
+	var b strings.Builder
+	for _, seq := range aln.Sequences() {
+		fmt.Fprintf(&b, ">%s\n%s\n", seq.Name(), seq.Sequence())
+	}
+	return textSHA256(b.String()), nil
+}
+
+func TemplateSHA256(t *template.Template) string {
+	return textSHA256(t.Root.String())
+}
+
+// Returns the SHA-256 hash of the content of a file, in hexadecimal.
+func FileSHA256(name string) (string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return textSHA256(string(content)), nil
+}
+
+func textSHA256(text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
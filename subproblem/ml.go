@@ -0,0 +1,232 @@
+package subproblem
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/goalign/io/phylip"
+	"github.com/evolbioinfo/gotree/io/newick"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+)
+
+// Models of the likelihood criteria in IQ-TREE and RAxML-NG: Mk has equal
+// state frequencies, GTR2 estimates them.
+var (
+	iqtreeModels = map[string]string{"mk": "JC2", "gtr2": "GTR2"}
+	raxmlModels  = map[string]string{"mk": "BIN+FE", "gtr2": "BIN+FO"}
+)
+
+// Solves subproblems with IQ-TREE. Each subproblem is written as PHYLIP
+// (stem.phy) and searched under the model of the criterion; the best tree is
+// read from stem_iqtree.treefile and its scores from the stem_iqtree.iqtree report.
+type IQTreeBackend struct {
+	phylipFiles
+	Program string   // IQ-TREE executable; "iqtree2" if empty
+	Args    []string // arguments given to IQ-TREE after the ones set by the backend, e.g. -nt 2
+}
+
+func (IQTreeBackend) Name() string { return "iqtree" }
+
+func (b IQTreeBackend) String() string { return b.program() }
+
+func (b IQTreeBackend) program() string {
+	if b.Program == "" {
+		return "iqtree2"
+	}
+	return b.Program
+}
+
+func (IQTreeBackend) Outputs(stem string) []string {
+	return []string{stem + "_iqtree.iqtree", stem + "_iqtree.treefile"}
+}
+
+func (b IQTreeBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	args := []string{"-s", sub.Stem + ".phy", "-st", "BIN", "-m", iqtreeModels[sub.Criterion.Name],
+		"-pre", sub.Stem + "_iqtree", "-seed", strconv.FormatInt(sub.Seed, 10), "-redo", "-quiet"}
+	return runML(ctx, dir, b, sub, b.program(), append(args, b.Args...))
+}
+
+func (b IQTreeBackend) Results(dir string, sub Subproblem) (*ScoredTrees, error) {
+	return readMLResults(dir, b, sub, []mlScore{
+		{"-lnL", regexp.MustCompile(`Log-likelihood of the tree:\s*(\S+)`), true},
+		{"AIC", regexp.MustCompile(`\(AIC\) score:\s*(\S+)`), false},
+		{"AICc", regexp.MustCompile(`\(AICc\) score:\s*(\S+)`), false},
+	})
+}
+
+// Solves subproblems with RAxML-NG. Each subproblem is written as PHYLIP
+// (stem.phy) and searched under the model of the criterion; the best tree is
+// read from stem_raxml.raxml.bestTree and its scores from stem_raxml.raxml.log.
+type RAxMLBackend struct {
+	phylipFiles
+	Program string   // RAxML-NG executable; "raxml-ng" if empty
+	Args    []string // arguments given to RAxML-NG after the ones set by the backend, e.g. --threads 2
+}
+
+func (RAxMLBackend) Name() string { return "raxml" }
+
+func (b RAxMLBackend) String() string { return b.program() }
+
+func (b RAxMLBackend) program() string {
+	if b.Program == "" {
+		return "raxml-ng"
+	}
+	return b.Program
+}
+
+func (RAxMLBackend) Outputs(stem string) []string {
+	return []string{stem + "_raxml.raxml.log", stem + "_raxml.raxml.bestTree"}
+}
+
+func (b RAxMLBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	args := []string{"--search", "--msa", sub.Stem + ".phy", "--data-type", "BIN", "--model", raxmlModels[sub.Criterion.Name],
+		"--prefix", sub.Stem + "_raxml", "--seed", strconv.FormatInt(sub.Seed, 10), "--redo"}
+	return runML(ctx, dir, b, sub, b.program(), append(args, b.Args...))
+}
+
+func (b RAxMLBackend) Results(dir string, sub Subproblem) (*ScoredTrees, error) {
+	return readMLResults(dir, b, sub, []mlScore{
+		{"-lnL", regexp.MustCompile(`Final LogLikelihood:\s*(\S+)`), true},
+		{"AIC", regexp.MustCompile(`\bAIC score:\s*(\S+)`), false},
+		{"AICc", regexp.MustCompile(`\bAICc score:\s*(\S+)`), false},
+	})
+}
+
+// Input of the likelihood backends: the subproblem's taxa as relaxed PHYLIP.
+type phylipFiles struct{}
+
+func (phylipFiles) Inputs(stem string) []string {
+	return []string{stem + ".phy"}
+}
+
+func (phylipFiles) Prepare(dir string, sub Subproblem, aln align.Alignment, settings SearchSettings) error {
+	if err := CheckCriterion(settings.Backend, sub.Criterion); err != nil {
+		return errs.New(errs.ErrInvalidOption, "", err)
+	}
+	out, err := subAlignment(aln, sub)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, sub.Stem+".phy"), []byte(phylip.WriteAlignment(out, false, true, true)), 0644); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+func runML(ctx context.Context, dir string, b SearchBackend, sub Subproblem, program string, args []string) error {
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", program, err, lastLines(string(output), 10))
+	}
+	return checkOutputs(dir, b, sub.Stem)
+}
+
+// A score read from the report of a likelihood search. The log-likelihood is
+// stored negated, as the -lnL column of PAUP*'s lscores.
+type mlScore struct {
+	column string
+	value  *regexp.Regexp // the first submatch is the value
+	negate bool
+}
+
+// Reads the report (the first output of b) and the best tree (the second) of
+// sub. Both programs report a single best tree.
+func readMLResults(dir string, b SearchBackend, sub Subproblem, scores []mlScore) (*ScoredTrees, error) {
+	outputs := b.Outputs(sub.Stem)
+	reportFile, treeFile := filepath.Join(dir, outputs[0]), filepath.Join(dir, outputs[1])
+	problems := make([]string, 0)
+	kind, file := errs.ErrMissingOutput, filepath.Join(dir, sub.Stem+".phy")
+	table, err := readMLReport(reportFile, scores)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "report missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("report malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, reportFile
+	}
+	t, err := readNewickTree(treeFile, sub.Taxa)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "tree missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("tree malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, treeFile
+	}
+	if len(problems) > 0 {
+		return nil, &errs.Error{Kind: kind, Polytomy: sub.Polytomy, File: file, Taxon: sub.Removed, Err: errors.New(strings.Join(problems, ", "))}
+	}
+	return &ScoredTrees{Scores: table, Trees: []*tree.Tree{t}, TreeFile: treeFile}, nil
+}
+
+// Reads the scores of the best tree from a report, as a one row table.
+func readMLReport(file string, scores []mlScore) (*ScoreTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	table := &ScoreTable{File: file, Tree: []int{1}, Rows: [][]float64{{}}}
+	found := make([]bool, len(scores))
+	values := make([]float64, len(scores))
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		for k, score := range scores {
+			match := score.value.FindStringSubmatch(scanner.Text())
+			if match == nil {
+				continue
+			}
+			if values[k], err = strconv.ParseFloat(strings.TrimRight(match[1], ",;"), 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid %s %q", file, line, score.column, match[1])
+			}
+			if score.negate {
+				values[k] = -values[k]
+			}
+			found[k] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for k, score := range scores {
+		if !found[k] {
+			return nil, fmt.Errorf("%s: no %s", file, score.column)
+		}
+		table.Columns = append(table.Columns, score.column)
+		table.Rows[0] = append(table.Rows[0], values[k])
+	}
+	return table, nil
+}
+
+// Reads the first tree of a Newick file, checking that it is on taxa.
+func readNewickTree(file string, taxa []string) (*tree.Tree, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := newick.NewParser(f).Parse()
+	if err != nil {
+		return nil, err
+	}
+	tips := t.AllTipNames()
+	slices.Sort(tips)
+	taxa = slices.Clone(taxa)
+	slices.Sort(taxa)
+	if !slices.Equal(tips, taxa) {
+		return nil, fmt.Errorf("tree is on taxa %s, expected %s", strings.Join(tips, ","), strings.Join(taxa, ","))
+	}
+	return t, nil
+}
@@ -0,0 +1,108 @@
+package subproblem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"lv1-netest/errs"
+)
+
+func TestMLStandIn(t *testing.T) {
+	program := standIn(t, "ml-standin.sh")
+	// The stand-in makes up -lnL = 0.7 * ones + 1 for the number of 1
+	// states in the PHYLIP file, with 2n-3 parameters on its 24 sites:
+	// 15 ones and 7 parameters without taxon_6, 20 and 9 with it.
+	tests := []struct {
+		backend   SearchBackend
+		criterion string
+		taxa      []string
+		want      []float64 // -lnL, AIC, AICc
+	}{
+		{IQTreeBackend{Program: program}, "mk", cycleTaxa[:5], []float64{11.5, 37, 44}},
+		{IQTreeBackend{Program: program}, "gtr2", cycleTaxa, []float64{15, 48, 60.8571}},
+		{RAxMLBackend{Program: program}, "mk", cycleTaxa[:5], []float64{11.5, 37, 44}},
+		{RAxMLBackend{Program: program}, "gtr2", cycleTaxa, []float64{15, 48, 60.8571}},
+	}
+	for _, test := range tests {
+		t.Run(test.backend.Name()+"/"+test.criterion+"/"+strings.Join(test.taxa, ","), func(t *testing.T) {
+			criterion, err := ParseCriterion(test.criterion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub := Subproblem{PolytomyID: "0123456789abcdef", Taxa: test.taxa, Stem: "polytomy_0123456789abcdef_0", Seed: 1, Criterion: criterion}
+			result, err := solveCycle(t, test.backend, sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(result.Scores.Columns, []string{"-lnL", "AIC", "AICc"}) {
+				t.Errorf("columns %v", result.Scores.Columns)
+			}
+			if len(result.Scores.Rows) != 1 || len(result.Trees) != 1 {
+				t.Fatalf("%d rows and %d trees, expected one of each", len(result.Scores.Rows), len(result.Trees))
+			}
+			if !closeTo(result.Scores.Rows[0], test.want) {
+				t.Errorf("scores %v, expected %v", result.Scores.Rows[0], test.want)
+			}
+			tips := result.Trees[0].AllTipNames()
+			slices.Sort(tips)
+			if !slices.Equal(tips, test.taxa) {
+				t.Errorf("tree on %v, expected %v", tips, test.taxa)
+			}
+		})
+	}
+}
+
+func TestMLResultsMissing(t *testing.T) {
+	criterion, _ := ParseCriterion("mk")
+	sub := Subproblem{Taxa: cycleTaxa, Stem: "polytomy_0123456789abcdef_0", Criterion: criterion}
+	for _, b := range []SearchBackend{IQTreeBackend{}, RAxMLBackend{}} {
+		_, err := b.Results(t.TempDir(), sub)
+		if !errors.Is(err, errs.ErrMissingOutput) || !strings.Contains(err.Error(), "report missing, tree missing") {
+			t.Errorf("%s: got error %v, expected the report and tree to be missing", b.Name(), err)
+		}
+	}
+}
+
+func TestReadMLReport(t *testing.T) {
+	scores := []mlScore{
+		{"-lnL", regexp.MustCompile(`Final LogLikelihood:\s*(\S+)`), true},
+		{"AIC", regexp.MustCompile(`\bAIC score:\s*(\S+)`), false},
+	}
+	tests := []struct {
+		name   string
+		report string
+		want   []float64
+		err    string
+	}{
+		{"scores", "Final LogLikelihood: -11.5\n\nAIC score: 37 / AICc score: 44\n", []float64{11.5, 37}, ""},
+		{"last value kept", "Final LogLikelihood: -12\nFinal LogLikelihood: -11.5\nAIC score: 37,\n", []float64{11.5, 37}, ""},
+		{"missing score", "Final LogLikelihood: -11.5\n", nil, "no AIC"},
+		{"invalid score", "Final LogLikelihood: nothing\nAIC score: 37\n", nil, `invalid -lnL "nothing"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "report.log")
+			if err := os.WriteFile(file, []byte(test.report), 0644); err != nil {
+				t.Fatal(err)
+			}
+			table, err := readMLReport(file, scores)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got error %v, expected one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(table.Rows[0], test.want) {
+				t.Errorf("scores %v, expected %v", table.Rows[0], test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package subproblem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/goalign/io/nexus"
+	gotreenexus "github.com/evolbioinfo/gotree/io/nexus"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+)
+
+// Settings shared by every polytomy subproblem search.
+type SearchSettings struct {
+	Criterion Criterion
+	Template  *template.Template // renders the paup block appended to each subproblem
+	Seed      int64              // every subproblem seed is drawn from this, in polytomy order
+	Backend   SearchBackend      // program the subproblems are prepared for
+}
+
+// Solves subproblems with PAUP*, run on NEXUS files ending with the paup
+// block of the template.
+type PAUPBackend struct {
+	nexusFiles
+	Program string   // PAUP* executable; "paup" if empty
+	Args    []string // arguments given to PAUP* before the input file
+}
+
+func (PAUPBackend) Name() string { return "paup" }
+
+func (b PAUPBackend) String() string { return b.program() }
+
+func (b PAUPBackend) program() string {
+	if b.Program == "" {
+		return "paup"
+	}
+	return b.Program
+}
+
+func (b PAUPBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	cmd := exec.CommandContext(ctx, b.program(), append(slices.Clone(b.Args), sub.Stem+".nex")...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", b.program(), err, lastLines(string(output), 10))
+	}
+	return checkOutputs(dir, b, sub.Stem)
+}
+
+// Input and output of the backends that run on the files PAUP* reads and
+// writes: a NEXUS data block followed by a paup block, and a score table and
+// NEXUS tree file.
+type nexusFiles struct{}
+
+func (nexusFiles) Inputs(stem string) []string {
+	return []string{stem + ".nex"}
+}
+
+func (nexusFiles) Outputs(stem string) []string {
+	return []string{stem + "_scores.tsv", stem + "_trees.nex"}
+}
+
+func (nexusFiles) Prepare(dir string, sub Subproblem, aln align.Alignment, settings SearchSettings) error {
+	out, err := subAlignment(aln, sub)
+	if err != nil {
+		return err
+	}
+	nexusStr, err := fixNexus(nexus.WriteAlignment(out), newPAUPJob(sub), settings)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, sub.Stem+".nex"), []byte(nexusStr), 0644); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+func fixNexus(nexusStr string, job paupJob, settings SearchSettings) (string, error) {
+	nexusStr = strings.Replace(nexusStr, "dmension", "dimension", -1) // there's a spelling error for some reason
+	nexusStr = strings.Replace(nexusStr, "format datatype=dna;", "format datatype = standard gap = - missing = ? symbols = \" 0 1\";", -1)
+	paupBlock, err := renderPAUPBlock(settings.Template, job)
+	if err != nil {
+		return "", err
+	}
+	return nexusStr + paupBlock, nil
+}
+
+// Reads the score table and tree file of sub, checking that every scored tree
+// is there and on the taxa of the subproblem.
+func (nexusFiles) Results(dir string, sub Subproblem) (*ScoredTrees, error) {
+	scoreFile := filepath.Join(dir, sub.Stem+"_scores.tsv")
+	treeFile := filepath.Join(dir, sub.Stem+"_trees.nex")
+	problems := make([]string, 0)
+	kind, file := errs.ErrMissingOutput, filepath.Join(dir, sub.Stem+".nex")
+	table, err := ReadScoreTable(scoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "scores missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("scores malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, scoreFile
+	}
+	trees, err := readTreeFile(treeFile, sub.Taxa)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "trees missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("trees malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, treeFile
+	} else if table != nil && len(trees) < slices.Max(table.Tree) {
+		problems = append(problems, fmt.Sprintf("trees truncated (%d trees, %d scored)", len(trees), slices.Max(table.Tree)))
+	}
+	if len(problems) > 0 {
+		return nil, &errs.Error{Kind: kind, Polytomy: sub.Polytomy, File: file, Taxon: sub.Removed, Err: errors.New(strings.Join(problems, ", "))}
+	}
+	return &ScoredTrees{Scores: table, Trees: trees, TreeFile: treeFile}, nil
+}
+
+// Reads the trees of a NEXUS tree file, checking each is on the expected taxa.
+func readTreeFile(file string, taxa []string) ([]*tree.Tree, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	nxs, err := gotreenexus.NewParser(f).Parse()
+	if err != nil {
+		return nil, err
+	}
+	taxa = slices.Clone(taxa)
+	slices.Sort(taxa)
+	trees := make([]*tree.Tree, 0)
+	nxs.IterateTrees(func(s string, t *tree.Tree) {
+		if err != nil {
+			return
+		}
+		tips := t.AllTipNames()
+		slices.Sort(tips)
+		if !slices.Equal(tips, taxa) {
+			err = fmt.Errorf("tree %d is on taxa %s, expected %s", len(trees)+1, strings.Join(tips, ","), strings.Join(taxa, ","))
+		}
+		trees = append(trees, t)
+	})
+	if err == nil && len(trees) == 0 {
+		err = errors.New("no trees")
+	}
+	return trees, err
+}
+
+// Data available to a paup block template. The finish step reads ScoreFile
+// and TreeFile, so a template must write both of them.
+type paupJob struct {
+	Polytomy     int    // polytomy index
+	PolytomyID   string // stable polytomy ID
+	Candidate    int    // index of the removed taxon in taxa_<PolytomyID>.txt
+	Removed      string // taxon left out of this subproblem
+	Stem         string // file stem, e.g. polytomy_96536f0992899d67_3
+	ScoreFile    string
+	TreeFile     string
+	Seed         int64
+	Assumptions  string // assumptions block for the criterion (may be empty)
+	SetCriterion string // e.g. "set criterion=parsimony"
+	Model        string // likelihood model settings (may be empty)
+	ScoreCommand string // pscores/lscores command writing ScoreFile
+}
+
+// Names of the PAUP* presets, from the quickest search to the most thorough.
+var Presets = []string{"fast", "default", "thorough"}
+
+var paupPresets = map[string]string{
+	"fast": `
+{{.Assumptions}}
+begin paup;
+	{{.SetCriterion}} maxtrees=10 increase=no;{{.Model}}
+	hsearch start=stepwise addseq=random rseed={{.Seed}} nreps=5 swap=spr collapse=no;
+	filter best=yes;
+	{{.ScoreCommand}}
+	savetrees file={{.TreeFile}} replace=yes format=nexus;
+	quit;
+end;`,
+	"default": `
+{{.Assumptions}}
+begin paup;
+	{{.SetCriterion}} maxtrees=100 increase=no;{{.Model}}
+	hsearch start=stepwise addseq=random rseed={{.Seed}} nreps=25 swap=tbr collapse=no;
+	filter best=yes;
+	describetrees 1/diag=yes;
+	{{.ScoreCommand}}
+	savetrees file={{.TreeFile}} replace=yes format=nexus;
+	quit;
+end;`,
+	"thorough": `
+{{.Assumptions}}
+begin paup;
+	{{.SetCriterion}} maxtrees=1000 increase=no;{{.Model}}
+	hsearch start=stepwise addseq=random rseed={{.Seed}} nreps=100 swap=tbr multrees=yes collapse=no;
+	filter best=yes;
+	describetrees 1/diag=yes;
+	{{.ScoreCommand}}
+	savetrees file={{.TreeFile}} replace=yes format=nexus;
+	quit;
+end;`,
+}
+
+// Loads a paup block template, either one of the presets or a text/template file.
+func LoadPAUPTemplate(nameOrFile string) (*template.Template, error) {
+	text, preset := paupPresets[nameOrFile]
+	if !preset {
+		content, err := os.ReadFile(nameOrFile)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a preset (%s) and could not be read: %w", nameOrFile, strings.Join(Presets, ", "), err)
+		}
+		text = string(content)
+	}
+	t, err := template.New(nameOrFile).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse paup template %s: %w", nameOrFile, err)
+	}
+	return t, nil
+}
+
+func newPAUPJob(sub Subproblem) paupJob {
+	scoreFile := sub.Stem + "_scores.tsv"
+	return paupJob{
+		Polytomy:     sub.Polytomy,
+		PolytomyID:   sub.PolytomyID,
+		Candidate:    sub.Candidate,
+		Removed:      sub.Removed,
+		Stem:         sub.Stem,
+		ScoreFile:    scoreFile,
+		TreeFile:     sub.Stem + "_trees.nex",
+		Seed:         sub.Seed,
+		Assumptions:  sub.Criterion.paupAssumptions(),
+		SetCriterion: sub.Criterion.paupSet(),
+		Model:        sub.Criterion.paupModel(),
+		ScoreCommand: sub.Criterion.paupScores(scoreFile),
+	}
+}
+
+func renderPAUPBlock(t *template.Template, job paupJob) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, job); err != nil {
+		return "", fmt.Errorf("could not render paup block for %s: %w", job.Stem, err)
+	}
+	block := b.String()
+	for _, file := range []string{job.ScoreFile, job.TreeFile} {
+		if !strings.Contains(block, file) {
+			return "", fmt.Errorf("paup template %s does not write %s, which the finish step reads", t.Name(), file)
+		}
+	}
+	return block, nil
+}
@@ -0,0 +1,163 @@
+package subproblem
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+	"lv1-netest/sntree"
+)
+
+// Table of a setup directory mapping polytomy indexes to IDs.
+const PolytomyFile = "polytomies.tsv"
+
+func taxaFile(id string) string {
+	return "taxa_" + id + ".txt"
+}
+
+func writePolytomyTable(dir string, polytomies []sntree.Polytomy) error {
+	var b strings.Builder
+	b.WriteString("polytomy\tid\tlabel\ttaxa\n")
+	for i, p := range polytomies {
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%s\n", i, p.ID, p.Label(), strings.Join(p.Taxa, ","))
+	}
+	if err := os.WriteFile(filepath.Join(dir, PolytomyFile), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+// Reads the polytomies of a setup directory, in index order: their IDs from
+// polytomies.tsv and their taxa from the taxa_<id>.txt files. Directories set
+// up before polytomies had IDs have no table, and taxa_<i>.txt files; their
+// polytomies get their index as ID, which gives the file names and node
+// labels of that time.
+func ReadPolytomies(dir string) ([]sntree.Polytomy, error) {
+	content, err := os.ReadFile(filepath.Join(dir, PolytomyFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return readIndexedPolytomies(dir)
+	} else if err != nil {
+		return nil, err
+	}
+	polytomies := make([]sntree.Polytomy, 0)
+	for k, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] != strconv.Itoa(k) || fields[1] == "" {
+			return nil, fmt.Errorf("%s line %d: expected polytomy %d and its ID, found %q", filepath.Join(dir, PolytomyFile), k+2, k, line)
+		}
+		taxa, err := os.ReadFile(filepath.Join(dir, taxaFile(fields[1])))
+		if err != nil {
+			return nil, err
+		}
+		polytomies = append(polytomies, sntree.Polytomy{ID: fields[1], Taxa: strings.Split(string(taxa), "\n")})
+	}
+	return polytomies, nil
+}
+
+func readIndexedPolytomies(dir string) ([]sntree.Polytomy, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	taxa := make(map[int][]string, 0)
+	var id int
+	for _, file := range files {
+		if nMatch, err := fmt.Sscanf(file.Name(), "taxa_%d.txt", &id); err != nil {
+			// fmt.Println(file.Name(), nMatch, id)
+			// panic(err)
+			continue
+		} else if nMatch != 1 {
+			continue
+		} else {
+			content, err := os.ReadFile(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			taxa[id] = strings.Split(string(content), "\n")
+		}
+	}
+	polytomies := make([]sntree.Polytomy, len(taxa))
+	for i := range polytomies {
+		polytomy, exists := taxa[i]
+		if !exists {
+			return nil, fmt.Errorf("%s has no taxa_%d.txt, but has taxa files for later polytomies", dir, i)
+		}
+		polytomies[i] = sntree.Polytomy{ID: strconv.Itoa(i), Taxa: polytomy}
+	}
+	return polytomies, nil
+}
+
+// Reads the candidates of polytomy i and the best tree of the chosen one.
+func ReadPolytomy(dir string, i int, polytomy sntree.Polytomy, ranking Ranking, backend SearchBackend) (*tree.Tree, []*CandidateScores, error) {
+	candidates, best, err := ReadCandidates(dir, i, polytomy, ranking, backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := ReadBestTree(best)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, candidates, nil
+}
+
+// Reads the scored trees of every subproblem of polytomy i, one candidate per
+// removed taxon, and returns them with the best candidate under ranking, which
+// is marked chosen.
+func ReadCandidates(dir string, i int, polytomy sntree.Polytomy, ranking Ranking, backend SearchBackend) ([]*CandidateScores, *CandidateScores, error) {
+	candidates := make([]*CandidateScores, 0, len(polytomy.Taxa)) // possible trees (each with a different taxa removed)
+	for j := range polytomy.Taxa {
+		sub := NewSubproblem(i, j, polytomy)
+		scored, err := backend.Results(dir, sub)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the best of the multiple trees *on the same taxa* found by the search is picked by rankCandidate
+		c, err := rankCandidate(scored, ranking)
+		if err != nil {
+			return nil, nil, &errs.Error{Kind: errs.ErrInvalidOutput, Polytomy: i, File: scored.Scores.File, Taxon: sub.Removed, Err: err}
+		}
+		c.Polytomy, c.PolytomyID, c.Candidate, c.Removed = i, polytomy.ID, j, sub.Removed
+		candidates = append(candidates, c)
+	}
+	// ties go to the lowest candidate index
+	var best *CandidateScores
+	for _, c := range candidates {
+		if best == nil || c.Values[c.Best] > best.Values[best.Best] {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, nil, errs.Polytomy(errs.ErrMissingOutput, i, dir, errors.New("no subproblems"))
+	}
+	best.Chosen = true
+	// fmt.Printf("best score for polytomy %d is %f\n", i, best.Values[best.Best])
+	return candidates, best, nil
+}
+
+// Returns the best tree of a candidate, unrooted.
+func ReadBestTree(best *CandidateScores) (*tree.Tree, error) {
+	i := best.Polytomy
+	n := best.Table.Tree[best.Best]
+	if n > len(best.Trees) {
+		err := fmt.Errorf("no tree %d, but it is listed in %s", n, best.Table.File)
+		return nil, &errs.Error{Kind: errs.ErrInvalidOutput, Polytomy: i, File: best.TreeFile, Taxon: best.Removed, Err: err}
+	}
+	result := best.Trees[n-1]
+	if result.Rooted() { // directed characters (dollo, camin-sokal) give rooted trees
+		result.UnRoot()
+	}
+	for _, node := range result.Nodes() {
+		if !node.Tip() && node.Nneigh() != 3 {
+			err := fmt.Errorf("tree %d has a node of degree %d", n, node.Nneigh())
+			return nil, &errs.Error{Kind: errs.ErrNonBinaryTree, Polytomy: i, File: best.TreeFile, Taxon: best.Removed, Err: err}
+		}
+	}
+	return result, nil
+}
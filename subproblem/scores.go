@@ -0,0 +1,275 @@
+package subproblem
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/gotree/tree"
+)
+
+// Scores of every tree found by a search, as in a PAUP* score file (pscores or lscores).
+type ScoreTable struct {
+	File    string
+	Columns []string    // score columns in file order, without the Tree column
+	Tree    []int       // tree number (1-based) of each row
+	Rows    [][]float64 // Rows[k][c] is the value of Columns[c] for tree Tree[k]
+}
+
+// direction of each known score column; 1 if higher is better, -1 if lower is better
+var scoreDirections = map[string]float64{
+	"length": -1,
+	"ci":     1,
+	"ri":     1,
+	"rc":     1,
+	"hi":     -1,
+	"-lnl":   -1,
+	"aic":    -1,
+	"aicc":   -1,
+}
+
+func ReadScoreTable(file string) (*ScoreTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1 // checked below so the error can name the column count
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s: empty score file", file)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	if header[0] != "Tree" {
+		return nil, fmt.Errorf("%s: expected first column to be Tree, found %q", file, header[0])
+	}
+	table := &ScoreTable{File: file, Columns: header[1:]}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("%s line %d: %d columns, but the header has %d", file, line, len(record), len(header))
+		}
+		treeNum, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil || treeNum < 1 {
+			return nil, fmt.Errorf("%s line %d: invalid tree number %q", file, line, record[0])
+		}
+		row := make([]float64, len(table.Columns))
+		for c, field := range record[1:] {
+			if row[c], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid %s value %q", file, line, table.Columns[c], field)
+			}
+		}
+		table.Tree = append(table.Tree, treeNum)
+		table.Rows = append(table.Rows, row)
+	}
+	if len(table.Rows) == 0 {
+		return nil, fmt.Errorf("%s: no trees in score file", file)
+	}
+	return table, nil
+}
+
+func (t *ScoreTable) column(name string) int {
+	return slices.IndexFunc(t.Columns, func(c string) bool { return strings.EqualFold(c, name) })
+}
+
+// Ranking of trees by one score column, or by a weighted sum of several.
+type Ranking struct {
+	Name    string
+	columns []string // lower case column names
+	weights []float64
+}
+
+// Parses a ranking: a single column (length, ci, ri, rc, hi, lnl, aic, aicc) or a
+// weighted combination such as "ci=1,ri=0.5". Weights multiply the column
+// after orienting it so that higher is better.
+func ParseRanking(spec string) (Ranking, error) {
+	r := Ranking{Name: spec}
+	for _, term := range strings.Split(spec, ",") {
+		name, weight, weighted := strings.Cut(strings.TrimSpace(term), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "lnl" {
+			name = "-lnl"
+		}
+		if _, ok := scoreDirections[name]; !ok {
+			return Ranking{}, fmt.Errorf("unknown ranking column %q in %q (expected length, ci, ri, rc, hi, lnl, aic or aicc)", name, spec)
+		}
+		w := 1.0
+		if weighted {
+			var err error
+			if w, err = strconv.ParseFloat(strings.TrimSpace(weight), 64); err != nil {
+				return Ranking{}, fmt.Errorf("invalid weight %q for %s in ranking %q", weight, name, spec)
+			}
+		}
+		r.columns = append(r.columns, name)
+		r.weights = append(r.weights, w)
+	}
+	return r, nil
+}
+
+// Checks that a score table has every column needed by the ranking.
+func (r Ranking) Check(t *ScoreTable) error {
+	for _, name := range r.columns {
+		if t.column(name) == -1 {
+			return fmt.Errorf("%s has no %s column needed by ranking %q (columns: %s)", t.File, name, r.Name, strings.Join(t.Columns, ", "))
+		}
+	}
+	return nil
+}
+
+// Ranking value of row k of the table; higher is better.
+func (r Ranking) Value(t *ScoreTable, k int) float64 {
+	value := 0.0
+	for i, name := range r.columns {
+		value += r.weights[i] * scoreDirections[name] * t.Rows[k][t.column(name)]
+	}
+	return value
+}
+
+// Scores of all trees for one candidate (one removed taxon) of a polytomy.
+type CandidateScores struct {
+	Polytomy   int
+	PolytomyID string
+	Candidate  int
+	Removed    string
+	Table      *ScoreTable
+	Trees      []*tree.Tree // trees scored in Table, see ScoredTrees
+	TreeFile   string
+	Values     []float64 // ranking value of each row
+	Best       int       // row of the best tree
+	Chosen     bool      // whether this candidate's best tree was used for the polytomy
+}
+
+func rankCandidate(scored *ScoredTrees, ranking Ranking) (*CandidateScores, error) {
+	table := scored.Scores
+	if err := ranking.Check(table); err != nil {
+		return nil, err
+	}
+	c := &CandidateScores{Table: table, Trees: scored.Trees, TreeFile: scored.TreeFile, Values: make([]float64, len(table.Rows))}
+	for k := range table.Rows {
+		c.Values[k] = ranking.Value(table, k)
+		if k == 0 || c.Values[k] > c.Values[c.Best] {
+			c.Best = k
+		}
+	}
+	return c, nil
+}
+
+// Writes a table with every candidate tree of every polytomy, preceded by a
+// "#" comment line if comment is not empty.
+func WriteCandidateSummary(name string, polytomies [][]*CandidateScores, comment string) error {
+	var columns []string
+	var first string
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	for _, candidates := range polytomies {
+		for _, c := range candidates {
+			if columns == nil {
+				columns, first = c.Table.Columns, c.Table.File
+				fmt.Fprintf(&b, "polytomy\tpolytomy_id\tcandidate\tremoved\ttree\t%s\trank_value\tbest\tchosen\n", strings.Join(columns, "\t"))
+			} else if !slices.Equal(columns, c.Table.Columns) {
+				return fmt.Errorf("%s has columns %v, but %s has %v", c.Table.File, c.Table.Columns, first, columns)
+			}
+			for k, row := range c.Table.Rows {
+				values := make([]string, len(row))
+				for v := range row {
+					values[v] = strconv.FormatFloat(row[v], 'g', -1, 64)
+				}
+				fmt.Fprintf(&b, "%d\t%s\t%d\t%s\t%d\t%s\t%s\t%t\t%t\n", c.Polytomy, c.PolytomyID, c.Candidate, c.Removed, c.Table.Tree[k],
+					strings.Join(values, "\t"), strconv.FormatFloat(c.Values[k], 'g', -1, 64), k == c.Best, k == c.Best && c.Chosen)
+			}
+		}
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
+
+// Reads a table written by WriteCandidateSummary back, one candidate per
+// polytomy and removed taxon, in file order. The candidates have their scores
+// and ranking values but not their trees.
+func ReadCandidateSummary(name string) ([]*CandidateScores, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "#") {
+		lines = lines[1:]
+	}
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%s: no candidates", name)
+	}
+	header := strings.Split(lines[0], "\t")
+	const before, after = 5, 3 // columns before and after the scores
+	if len(header) < before+after || header[0] != "polytomy" || !slices.Equal(header[len(header)-after:], []string{"rank_value", "best", "chosen"}) {
+		return nil, fmt.Errorf("%s: not a candidate summary, header %q", name, lines[0])
+	}
+	columns := header[before : len(header)-after]
+	candidates := make([]*CandidateScores, 0)
+	var c *CandidateScores
+	for k, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		invalid := func(what string) error {
+			return fmt.Errorf("%s line %d: invalid %s in %q", name, k+2, what, line)
+		}
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("%s line %d: %d columns, but the header has %d", name, k+2, len(fields), len(header))
+		}
+		polytomy, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, invalid("polytomy")
+		}
+		candidate, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, invalid("candidate")
+		}
+		if c == nil || c.Polytomy != polytomy || c.Candidate != candidate {
+			c = &CandidateScores{Polytomy: polytomy, PolytomyID: fields[1], Candidate: candidate, Removed: fields[3], Table: &ScoreTable{File: name, Columns: columns}}
+			candidates = append(candidates, c)
+		}
+		tree, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return nil, invalid("tree")
+		}
+		row := make([]float64, len(columns))
+		for v := range row {
+			if row[v], err = strconv.ParseFloat(fields[before+v], 64); err != nil {
+				return nil, invalid(columns[v])
+			}
+		}
+		value, err := strconv.ParseFloat(fields[len(fields)-3], 64)
+		if err != nil {
+			return nil, invalid("rank_value")
+		}
+		best, err := strconv.ParseBool(fields[len(fields)-2])
+		if err != nil {
+			return nil, invalid("best")
+		}
+		chosen, err := strconv.ParseBool(fields[len(fields)-1])
+		if err != nil {
+			return nil, invalid("chosen")
+		}
+		if best {
+			c.Best, c.Chosen = len(c.Table.Rows), chosen
+		}
+		c.Table.Tree = append(c.Table.Tree, tree)
+		c.Table.Rows = append(c.Table.Rows, row)
+		c.Values = append(c.Values, value)
+	}
+	return candidates, nil
+}
@@ -0,0 +1,56 @@
+// Package subproblem handles the leave-one-out subproblems of the polytomies
+// of an SN-tree: it writes them to a setup directory as the input of a search
+// backend (PAUP*, a built-in search or another program), solves them, caches
+// their results, and reads the scored trees back.
+package subproblem
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/errs"
+	"lv1-netest/sntree"
+)
+
+// Writes the taxa of each polytomy (taxa_<id>.txt), the table mapping
+// polytomy indexes to IDs and the input of each subproblem for
+// settings.Backend. Subproblems found in cache (which may be nil) get no input.
+func WritePolytomies(polytomies []sntree.Polytomy, aln align.Alignment, outdir string, settings SearchSettings, cache *Cache) ([]JobRecord, error) {
+	os.Mkdir(outdir, 0755)
+	if err := writePolytomyTable(outdir, polytomies); err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(settings.Seed))
+	jobs := make([]JobRecord, 0)
+	for i, polytomy := range polytomies {
+		err := os.WriteFile(filepath.Join(outdir, taxaFile(polytomy.ID)), []byte(strings.Join(polytomy.Taxa, "\n")), 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not write file: %w", err)
+		}
+		for j := range len(polytomy.Taxa) {
+			sub := NewSubproblem(i, j, polytomy)
+			sub.Seed = rng.Int63n(1<<31-1) + 1 // drawn even if cached, so other seeds don't change; PAUP* seeds are positive 32 bit integers
+			sub.Criterion = settings.Criterion
+			hash, err := SubproblemHash(sub.Taxa, aln, settings)
+			if err != nil {
+				return nil, errs.InPolytomy(i, err)
+			}
+			record := JobRecord{Stem: sub.Stem, Hash: hash, Seed: sub.Seed}
+			if cache != nil && cache.Has(record.Hash) {
+				record.Cached = true
+				jobs = append(jobs, record)
+				continue
+			}
+			jobs = append(jobs, record)
+			if err := settings.Backend.Prepare(outdir, sub, aln, settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,88 @@
+package subproblem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n")
+}
+
+// Solves every subproblem of a setup directory that has no output yet with
+// backend, parallel at most jobs at a time, writing progress messages to log.
+func SolveAll(ctx context.Context, dir string, backend SearchBackend, parallel int, log io.Writer) error {
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	criterion, err := ParseCriterion(manifest.Parameters.Criterion)
+	if err != nil {
+		return err
+	}
+	cache, err := OpenSetupCache(dir, "")
+	if err != nil {
+		return err
+	}
+	if setup := SetupBackend(manifest); !slices.Equal(setup.Inputs("stem"), backend.Inputs("stem")) {
+		return fmt.Errorf("%s was set up for the %s backend, whose input the %s backend cannot read", dir, setup.Name(), backend.Name())
+	}
+	statuses, err := Status(dir, cache)
+	if err != nil {
+		return err
+	}
+	pending := make([]JobStatus, 0)
+	for _, s := range statuses {
+		if !s.Done() {
+			if manifest.Job(s.Stem) == nil { // checked before any search is started
+				return fmt.Errorf("%s is not in %s", s.Stem, ManifestFile)
+			}
+			pending = append(pending, s)
+		}
+	}
+	fmt.Fprintf(log, "solving %d of %d subproblems with %s, %d at a time...\n", len(pending), len(statuses), backend, parallel)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		done     int
+	)
+	slots := make(chan struct{}, parallel)
+	for _, job := range pending {
+		sub, stem := job.Subproblem, job.Stem
+		record := manifest.Job(stem)
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			sub.Seed, sub.Criterion = record.Seed, criterion
+			err := backend.Solve(ctx, dir, sub)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", stem, err)
+					cancel()
+				}
+				return
+			}
+			done++
+			fmt.Fprintf(log, "\t%s done in %s (%d/%d)\n", stem, time.Since(start).Round(time.Millisecond), done, len(pending))
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,96 @@
+package subproblem
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"lv1-netest/errs"
+)
+
+// State of the search of a single subproblem of a setup directory.
+type JobStatus struct {
+	Subproblem
+	Input     string // first input file, the one the program is run on, e.g. polytomy_i_j.nex
+	Cached    bool   // output is not in the directory, but can be taken from the cache
+	Problems  []string
+	Malformed string // output file that is there but cannot be read, if any
+}
+
+func (s JobStatus) Done() bool {
+	return len(s.Problems) == 0
+}
+
+// Returns the file marking that the output of subproblem stem is being
+// replaced, one file at a time, so that the files may not belong together
+// until it is removed.
+func Replacing(dir, stem string) string {
+	return filepath.Join(dir, stem+".replacing")
+}
+
+func replacing(dir, stem string) bool {
+	_, err := os.Stat(Replacing(dir, stem))
+	return err == nil
+}
+
+// Checks the search of every subproblem expected in a directory written by
+// WritePolytomies. Output missing from the directory counts as done if it is
+// in cache, which may be nil.
+func Status(dir string, cache *Cache) ([]JobStatus, error) {
+	if _, err := os.Stat(filepath.Join(dir, "sntree.nwk")); err != nil {
+		return nil, fmt.Errorf("%s does not look like a setup directory: %w", dir, err)
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	backend := SetupBackend(manifest)
+	polytomies, err := ReadPolytomies(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]JobStatus, 0)
+	for i, polytomy := range polytomies {
+		for j := range polytomy.Taxa {
+			sub := NewSubproblem(i, j, polytomy)
+			inputs := backend.Inputs(sub.Stem)
+			job := JobStatus{Subproblem: sub, Input: filepath.Join(dir, inputs[0])}
+			var e *errs.Error
+			if _, err := backend.Results(dir, sub); errors.As(err, &e) {
+				job.Problems = append(job.Problems, e.Err.Error())
+				if errors.Is(err, errs.ErrInvalidOutput) {
+					job.Malformed = e.File
+				}
+			} else if err != nil {
+				return nil, err
+			}
+			if replacing(dir, sub.Stem) {
+				job.Problems = append(job.Problems, "output was being replaced when it was interrupted")
+			}
+			var record *JobRecord
+			if manifest != nil {
+				record = manifest.Job(sub.Stem)
+				if record != nil {
+					job.Seed = record.Seed
+				}
+			}
+			if !job.Done() && cache != nil && record != nil && cache.Has(record.Hash) {
+				job.Cached, job.Problems, job.Malformed = true, nil, ""
+			} else if !job.Done() && slices.ContainsFunc(inputs, func(input string) bool {
+				_, err := os.Stat(filepath.Join(dir, input))
+				return err != nil
+			}) {
+				if record != nil && record.Cached {
+					job.Problems = append(job.Problems, "input not written as it was cached at setup, but the cache entry is gone; rerun setup")
+				} else {
+					job.Problems = append(job.Problems, "input missing")
+				}
+			}
+			result = append(result, job)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,341 @@
+package subproblem
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evolbioinfo/goalign/align"
+	"github.com/evolbioinfo/gotree/io/newick"
+	"github.com/evolbioinfo/gotree/tree"
+
+	"lv1-netest/errs"
+)
+
+// Solves subproblems with TNT. Each subproblem is written as a TNT matrix
+// (stem.tnt) and a run script (stem.run) searching it with new technology
+// (xmult) and TBR, keeping the best trees. The script saves them to
+// stem_trees.tre and logs their lengths to stem_lengths.log, together with
+// the least and star tree lengths of the data, from which CI, RI, RC and HI
+// are computed as PAUP*'s pscores does.
+type TNTBackend struct {
+	Program string   // TNT executable; "tnt" if empty
+	Args    []string // arguments given to TNT before the proc command of the run script
+}
+
+func (TNTBackend) Name() string { return "tnt" }
+
+func (b TNTBackend) String() string { return b.program() }
+
+func (b TNTBackend) program() string {
+	if b.Program == "" {
+		return "tnt"
+	}
+	return b.Program
+}
+
+// The run script comes first, as it is the file TNT is run on.
+func (TNTBackend) Inputs(stem string) []string {
+	return []string{stem + ".run", stem + ".tnt"}
+}
+
+func (TNTBackend) Outputs(stem string) []string {
+	return []string{stem + "_lengths.log", stem + "_trees.tre"}
+}
+
+// TNT has no likelihood and only the character types of these criteria.
+var tntCharacterCodes = map[string]string{
+	"unord":  "-", // nonadditive
+	"wagner": "+", // additive
+}
+
+func (b TNTBackend) Prepare(dir string, sub Subproblem, aln align.Alignment, settings SearchSettings) error {
+	if err := CheckCriterion(b, sub.Criterion); err != nil {
+		return errs.New(errs.ErrInvalidOption, "", err)
+	}
+	out, err := subAlignment(aln, sub)
+	if err != nil {
+		return err
+	}
+	names, seqs := make([]string, 0, out.NbSequences()), make([]string, 0, out.NbSequences())
+	out.IterateChar(func(name string, seq []uint8) bool {
+		names, seqs = append(names, name), append(seqs, string(seq))
+		return false
+	})
+	data, err := newParsimonyData(seqs, sub.Criterion.Name == "wagner")
+	if err != nil {
+		return errs.Polytomy(errs.ErrInvalidInput, sub.Polytomy, "", err)
+	}
+	var matrix strings.Builder
+	fmt.Fprintf(&matrix, "xread\n'%s: %s left out'\n%d %d\n", sub.Stem, tntName(sub.Removed), out.Length(), len(names))
+	for k, name := range names {
+		fmt.Fprintf(&matrix, "%s %s\n", tntName(name), seqs[k])
+	}
+	matrix.WriteString(";\nproc/;\n")
+	outputs := b.Outputs(sub.Stem)
+	script := fmt.Sprintf(`mxram 256;
+taxname=;
+rseed %d;
+proc %s;
+ccode %s .;
+hold 1000;
+xmult = replic 10;
+bbreak = tbr;
+best;
+tsave *%s;
+save;
+tsave/;
+log %s;
+quote minsteps %d maxsteps %d;
+length;
+log/;
+quit;
+`, sub.Seed, sub.Stem+".tnt", tntCharacterCodes[sub.Criterion.Name], outputs[1], outputs[0], data.min, data.max)
+	for name, content := range map[string]string{sub.Stem + ".tnt": matrix.String(), sub.Stem + ".run": script} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("could not write file: %w", err)
+		}
+	}
+	return nil
+}
+
+// TNT names cannot contain blanks or the characters it reads as tree syntax.
+func tntName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || strings.ContainsRune("();*,'[]", r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func (b TNTBackend) Solve(ctx context.Context, dir string, sub Subproblem) error {
+	args := append(slices.Clone(b.Args), "proc", sub.Stem+".run;")
+	cmd := exec.CommandContext(ctx, b.program(), args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", b.program(), err, lastLines(string(output), 10))
+	}
+	return checkOutputs(dir, b, sub.Stem)
+}
+
+// Reads the tree lengths logged by the run script of sub and the trees it
+// saved, checking that every tree is there and on the taxa of the subproblem.
+func (b TNTBackend) Results(dir string, sub Subproblem) (*ScoredTrees, error) {
+	outputs := b.Outputs(sub.Stem)
+	scoreFile, treeFile := filepath.Join(dir, outputs[0]), filepath.Join(dir, outputs[1])
+	problems := make([]string, 0)
+	kind, file := errs.ErrMissingOutput, filepath.Join(dir, sub.Stem+".run")
+	table, err := readTNTLengths(scoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "lengths missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("lengths malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, scoreFile
+	}
+	trees, err := readTNTTrees(treeFile, sub.Taxa)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "trees missing")
+	} else if err != nil {
+		problems = append(problems, fmt.Sprintf("trees malformed (%s)", err))
+		kind, file = errs.ErrInvalidOutput, treeFile
+	} else if table != nil && len(trees) != len(table.Rows) {
+		problems = append(problems, fmt.Sprintf("trees truncated (%d trees, %d lengths)", len(trees), len(table.Rows)))
+	}
+	if len(problems) > 0 {
+		return nil, &errs.Error{Kind: kind, Polytomy: sub.Polytomy, File: file, Taxon: sub.Removed, Err: errors.New(strings.Join(problems, ", "))}
+	}
+	return &ScoredTrees{Scores: table, Trees: trees, TreeFile: treeFile}, nil
+}
+
+// Reads a TNT log holding the "minsteps M maxsteps G" line quoted by the run
+// script and the table of TNT's length command, e.g.
+//
+//	Tree lengths
+//	          0     1
+//	   0     12    12
+//
+// where the row label plus the column label is the (0-based) tree number.
+func readTNTLengths(file string) (*ScoreTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := &parsimonyData{min: -1}
+	var lengths []int
+	header, inTable := 0, false
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if k := slices.Index(fields, "minsteps"); k != -1 && k+3 < len(fields) && fields[k+2] == "maxsteps" {
+			data.min, err = strconv.Atoi(fields[k+1])
+			if err == nil {
+				data.max, err = strconv.Atoi(strings.TrimSuffix(fields[k+3], ";")) // TNT may echo the quote command
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid steps %q", file, line, scanner.Text())
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(scanner.Text()), "Tree lengths") {
+			inTable, header = true, 0
+			continue
+		}
+		if !inTable || len(fields) == 0 {
+			continue
+		}
+		numbers := make([]int, len(fields))
+		for c, field := range fields {
+			if numbers[c], err = strconv.Atoi(field); err != nil {
+				break
+			}
+		}
+		if err != nil { // the end of the table
+			inTable = false
+			continue
+		}
+		if header == 0 {
+			header = len(numbers)
+			continue
+		}
+		if len(numbers)-1 > header || numbers[0] != len(lengths) {
+			return nil, fmt.Errorf("%s line %d: expected the lengths of trees %d and on, found %q", file, line, len(lengths), scanner.Text())
+		}
+		lengths = append(lengths, numbers[1:]...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if data.min < 0 {
+		return nil, fmt.Errorf("%s: no minsteps line", file)
+	}
+	if len(lengths) == 0 {
+		return nil, fmt.Errorf("%s: no tree lengths", file)
+	}
+	table := &ScoreTable{File: file, Columns: []string{"Length", "CI", "RI", "RC", "HI"}}
+	for k, length := range lengths {
+		ci, ri := data.indices(length)
+		table.Tree = append(table.Tree, k+1)
+		table.Rows = append(table.Rows, []float64{float64(length), ci, ri, ci * ri, 1 - ci})
+	}
+	return table, nil
+}
+
+// Reads the trees of a file saved by TNT's tsave*, in TNT's parenthetical
+// format: a tread command with trees separated by * and ended by ;, e.g.
+//
+//	tread 'comment'
+//	(A (B (C D )))*
+//	(A (C (B D )));
+//
+// Taxa are given by name or by their number in taxa, counting from 0.
+func readTNTTrees(file string, taxa []string) ([]*tree.Tree, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	text := string(content)
+	start := strings.Index(text, "tread")
+	if start == -1 {
+		return nil, errors.New("no tread command")
+	}
+	text = text[start+len("tread"):]
+	if quoted := strings.TrimSpace(text); strings.HasPrefix(quoted, "'") {
+		end := strings.Index(quoted[1:], "'")
+		if end == -1 {
+			return nil, errors.New("unterminated tread comment")
+		}
+		text = quoted[end+2:]
+	}
+	end := strings.Index(text, ";")
+	if end == -1 {
+		return nil, errors.New("tread is not terminated")
+	}
+	// Tips are labelled by their number while parsing, as taxa may hold
+	// characters Newick would need quoted.
+	labels, names := make(map[string]string, 2*len(taxa)), make(map[string]string, len(taxa))
+	for k, name := range taxa {
+		labels[tntName(name)], labels[strconv.Itoa(k)] = strconv.Itoa(k), strconv.Itoa(k)
+		names[strconv.Itoa(k)] = name
+	}
+	sorted := slices.Clone(taxa)
+	slices.Sort(sorted)
+	trees := make([]*tree.Tree, 0)
+	for _, s := range strings.Split(text[:end], "*") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		nwk, err := tntNewick(s, labels)
+		if err != nil {
+			return nil, fmt.Errorf("tree %d: %w", len(trees)+1, err)
+		}
+		t, err := newick.NewParser(strings.NewReader(nwk)).Parse()
+		if err != nil {
+			return nil, fmt.Errorf("tree %d: %w", len(trees)+1, err)
+		}
+		if err := t.Rename(names); err != nil {
+			return nil, fmt.Errorf("tree %d: %w", len(trees)+1, err)
+		}
+		t.UnRoot() // TNT saves rooted trees, PAUP* unrooted ones
+		tips := t.AllTipNames()
+		slices.Sort(tips)
+		if !slices.Equal(tips, sorted) {
+			return nil, fmt.Errorf("tree %d is on taxa %s, expected %s", len(trees)+1, strings.Join(tips, ","), strings.Join(sorted, ","))
+		}
+		trees = append(trees, t)
+	}
+	if len(trees) == 0 {
+		return nil, errors.New("no trees")
+	}
+	return trees, nil
+}
+
+// Turns a TNT tree, whose siblings are separated by blanks, into Newick,
+// labelling each taxon with its label in labels.
+func tntNewick(s string, labels map[string]string) (string, error) {
+	var b strings.Builder
+	sibling := false // whether the next node follows a sibling
+	for k := 0; k < len(s); {
+		switch c := s[k]; {
+		case c == '(':
+			if sibling {
+				b.WriteByte(',')
+			}
+			b.WriteByte('(')
+			sibling = false
+			k++
+		case c == ')':
+			b.WriteByte(')')
+			sibling = true
+			k++
+		case c <= ' ':
+			k++
+		default:
+			end := k + strings.IndexFunc(s[k:], func(r rune) bool { return r <= ' ' || r == '(' || r == ')' })
+			if end < k {
+				end = len(s)
+			}
+			label, known := labels[s[k:end]]
+			if !known {
+				return "", fmt.Errorf("unknown taxon %q", s[k:end])
+			}
+			if sibling {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			sibling = true
+			k = end
+		}
+	}
+	return b.String() + ";", nil
+}
@@ -0,0 +1,240 @@
+package subproblem
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/evolbioinfo/goalign/align"
+
+	"lv1-netest/alignio"
+	"lv1-netest/errs"
+)
+
+var cycleTaxa = []string{"taxon_1", "taxon_2", "taxon_3", "taxon_4", "taxon_5", "taxon_6"}
+
+// Returns the absolute path of a stand-in script of testdata, skipping the
+// test if there is no shell to run it.
+func standIn(t *testing.T, name string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	program, err := filepath.Abs(filepath.Join("..", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// Prepares sub of testdata/cycle.nex for b in a temporary directory, solves
+// it and returns its results.
+func solveCycle(t *testing.T, b SearchBackend, sub Subproblem) (*ScoredTrees, error) {
+	t.Helper()
+	aln, err := alignio.Read(filepath.Join("..", "testdata", "cycle.nex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := b.Prepare(dir, sub, aln, SearchSettings{Criterion: sub.Criterion, Seed: 1, Backend: b}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Solve(context.Background(), dir, sub); err != nil {
+		t.Fatal(err)
+	}
+	return b.Results(dir, sub)
+}
+
+func TestTNTStandIn(t *testing.T) {
+	program := standIn(t, "tnt-standin.sh")
+	// The stand-in saves a ladder of the taxa whose length is the star tree
+	// length quoted by the run script, so CI is the least length over it
+	// and RI is 0. Only sites 2 to 7 vary; without taxon_6 each has one
+	// step at least and sites 2, 4, 6 and 7 have two on a star tree.
+	tests := []struct {
+		criterion string
+		taxa      []string
+		want      []float64 // Length, CI, RI, RC, HI
+	}{
+		{"unord", cycleTaxa[:5], []float64{10, 0.6, 0, 0, 0.4}},
+		{"wagner", cycleTaxa[:5], []float64{10, 0.6, 0, 0, 0.4}},
+		{"unord", cycleTaxa, []float64{14, 6.0 / 14, 0, 0, 8.0 / 14}},
+	}
+	for _, test := range tests {
+		t.Run(test.criterion+"/"+strings.Join(test.taxa, ","), func(t *testing.T) {
+			criterion, err := ParseCriterion(test.criterion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub := Subproblem{PolytomyID: "0123456789abcdef", Taxa: test.taxa, Stem: "polytomy_0123456789abcdef_0", Seed: 1, Criterion: criterion}
+			result, err := solveCycle(t, TNTBackend{Program: program}, sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(result.Scores.Columns, []string{"Length", "CI", "RI", "RC", "HI"}) {
+				t.Errorf("columns %v", result.Scores.Columns)
+			}
+			if len(result.Scores.Rows) != 1 || len(result.Trees) != 1 {
+				t.Fatalf("%d rows and %d trees, expected one of each", len(result.Scores.Rows), len(result.Trees))
+			}
+			if !closeTo(result.Scores.Rows[0], test.want) {
+				t.Errorf("scores %v, expected %v", result.Scores.Rows[0], test.want)
+			}
+			tips := result.Trees[0].AllTipNames()
+			slices.Sort(tips)
+			if !slices.Equal(tips, test.taxa) {
+				t.Errorf("tree on %v, expected %v", tips, test.taxa)
+			}
+		})
+	}
+}
+
+func TestTNTQuotedNames(t *testing.T) {
+	program := standIn(t, "tnt-standin.sh")
+	aln := align.NewAlign(align.UNKNOWN)
+	for name, seq := range map[string]string{"o'brien": "0011", "b c": "0110", "d(e)": "1100", "f;g": "1010", "h": "0001"} {
+		aln.AddSequence(name, seq, "")
+	}
+	criterion, _ := ParseCriterion("unord")
+	tests := []struct {
+		removed string
+		title   string
+	}{
+		{"o'brien", "'polytomy_0123456789abcdef_0: o_brien left out'"},
+		{"b c", "'polytomy_0123456789abcdef_0: b_c left out'"},
+		{"d(e)", "'polytomy_0123456789abcdef_0: d_e_ left out'"},
+	}
+	for _, test := range tests {
+		t.Run(test.removed, func(t *testing.T) {
+			taxa := slices.DeleteFunc([]string{"o'brien", "b c", "d(e)", "f;g", "h"}, func(name string) bool { return name == test.removed })
+			sub := Subproblem{Removed: test.removed, Taxa: taxa, Stem: "polytomy_0123456789abcdef_0", Seed: 1, Criterion: criterion}
+			b := TNTBackend{Program: program}
+			dir := t.TempDir()
+			if err := b.Prepare(dir, sub, aln, SearchSettings{Criterion: criterion, Backend: b}); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, sub.Stem+".tnt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := strings.Split(string(content), "\n"); lines[1] != test.title {
+				t.Errorf("title %s, expected %s", lines[1], test.title)
+			}
+			if err := b.Solve(context.Background(), dir, sub); err != nil {
+				t.Fatal(err)
+			}
+			result, err := b.Results(dir, sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tips := result.Trees[0].AllTipNames()
+			slices.Sort(tips)
+			slices.Sort(taxa)
+			if !slices.Equal(tips, taxa) {
+				t.Errorf("tree on %q, expected %q", tips, taxa)
+			}
+		})
+	}
+}
+
+func closeTo(got, want []float64) bool {
+	return slices.EqualFunc(got, want, func(a, b float64) bool { return a-b < 1e-9 && b-a < 1e-9 })
+}
+
+func TestTNTResultsMissing(t *testing.T) {
+	criterion, _ := ParseCriterion("unord")
+	sub := Subproblem{Taxa: cycleTaxa, Stem: "polytomy_0123456789abcdef_0", Criterion: criterion}
+	_, err := TNTBackend{}.Results(t.TempDir(), sub)
+	if !errors.Is(err, errs.ErrMissingOutput) || !strings.Contains(err.Error(), "lengths missing, trees missing") {
+		t.Errorf("got error %v, expected the lengths and trees to be missing", err)
+	}
+}
+
+func TestReadTNTLengths(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		lengths []float64
+		err     string
+	}{
+		{"one tree", "minsteps 6 maxsteps 10\n\nTree lengths\n\n          0\n    0    10\n\n", []float64{10}, ""},
+		{"echoed quote", "> quote minsteps 6 maxsteps 10;\nminsteps 6 maxsteps 10\nTree lengths\n          0     1\n    0     8     9\n", []float64{8, 9}, ""},
+		{"several rows", "minsteps 6 maxsteps 10\nTree lengths\n      0  1  2\n  0   7  7  8\n  3   9\n\nproc-;\n", []float64{7, 7, 8, 9}, ""},
+		{"no minsteps", "Tree lengths\n   0\n0  7\n", nil, "no minsteps line"},
+		{"invalid steps", "minsteps six maxsteps 10\n", nil, "invalid steps"},
+		{"no lengths", "minsteps 6 maxsteps 10\n", nil, "no tree lengths"},
+		{"rows out of order", "minsteps 6 maxsteps 10\nTree lengths\n      0  1\n  0   7  7\n  4   9\n", nil, "expected the lengths of trees 2 and on"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "lengths.log")
+			if err := os.WriteFile(file, []byte(test.log), 0644); err != nil {
+				t.Fatal(err)
+			}
+			table, err := readTNTLengths(file)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got error %v, expected one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lengths []float64
+			for k, row := range table.Rows {
+				if table.Tree[k] != k+1 {
+					t.Errorf("row %d is tree %d", k, table.Tree[k])
+				}
+				lengths = append(lengths, row[0])
+			}
+			if !slices.Equal(lengths, test.lengths) {
+				t.Errorf("lengths %v, expected %v", lengths, test.lengths)
+			}
+		})
+	}
+}
+
+func TestReadTNTTrees(t *testing.T) {
+	taxa := []string{"a", "b c", "d", "e"}
+	tests := []struct {
+		name  string
+		trees string
+		count int
+		err   string
+	}{
+		{"names", "tread 'two trees'\n(a (b_c (d e )))*\n(a (d (b_c e )));\nproc-;\n", 2, ""},
+		{"numbers", "tread\n(0 (1 (2 3)));\n", 1, ""},
+		{"comment with a star", "tread 'x*y'\n(0 (1 (2 3)));\n", 1, ""},
+		{"no tread", "(a (b_c (d e )));\n", 0, "no tread command"},
+		{"unterminated", "tread\n(a (b_c (d e )))", 0, "tread is not terminated"},
+		{"unknown taxon", "tread\n(a (b_c (d f )));\n", 0, `unknown taxon "f"`},
+		{"missing taxon", "tread\n(a (b_c d ));\n", 0, "tree 1 is on taxa"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "trees.tre")
+			if err := os.WriteFile(file, []byte(test.trees), 0644); err != nil {
+				t.Fatal(err)
+			}
+			trees, err := readTNTTrees(file, taxa)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got error %v, expected one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trees) != test.count {
+				t.Errorf("read %d trees, expected %d", len(trees), test.count)
+			}
+		})
+	}
+}
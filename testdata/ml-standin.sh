@@ -0,0 +1,57 @@
+#!/bin/sh
+# Stands in for IQ-TREE or RAxML-NG when trying the iqtree and raxml backends
+# without them:
+#
+#	lv1-netest run -a testdata/cycle.nex -d cycle -c mk --backend iqtree --iqtree testdata/ml-standin.sh
+#	lv1-netest run -a testdata/cycle.nex -d cycle -c mk --backend raxml --raxml testdata/ml-standin.sh
+#
+# It runs no search. It writes canned output in the program's formats: a
+# ladder of the PHYLIP taxa as the best tree, and a made-up log-likelihood
+# that only depends on the number of 1 states in the alignment.
+set -eu
+msa= prefix= program=
+while [ $# -gt 0 ]; do
+	case $1 in
+	-s) msa=$2 program=iqtree ;;
+	--msa) msa=$2 program=raxml ;;
+	-pre | --prefix) prefix=$2 ;;
+	esac
+	shift
+done
+[ -n "$msa" ] && [ -n "$prefix" ] || { echo "usage: $0 (-s MSA -pre PREFIX | --msa MSA --prefix PREFIX) ..." >&2; exit 1; }
+set -- $(awk 'NR == 1 { n = $1; sites = $2; next }
+	NF == 2 { taxa[k++] = $1; ones += gsub(/1/, "", $2) }
+	END {
+		t = taxa[k-1]
+		for (i = k - 2; i >= 0; i--) t = "(" taxa[i] ":0.1," t ":0.1)"
+		params = 2 * n - 3
+		lnl = -0.7 * ones - 1
+		aic = 2 * params - 2 * lnl
+		aicc = aic
+		if (sites - params - 1 > 0) aicc += 2 * params * (params + 1) / (sites - params - 1)
+		printf "%s; %.4f %.4f %.4f\n", t, lnl, aic, aicc
+	}' "$msa")
+tree=$1 lnl=$2 aic=$3 aicc=$4
+
+if [ "$program" = iqtree ]; then
+	echo "$tree" >"$prefix.treefile"
+	cat >"$prefix.iqtree" <<EOF
+IQ-TREE stand-in
+
+MAXIMUM LIKELIHOOD TREE
+-----------------------
+
+Log-likelihood of the tree: $lnl (s.e. 1.0000)
+Akaike information criterion (AIC) score: $aic
+Corrected Akaike information criterion (AICc) score: $aicc
+EOF
+else
+	echo "$tree" >"$prefix.raxml.bestTree"
+	cat >"$prefix.raxml.log" <<EOF
+RAxML-NG stand-in
+
+Final LogLikelihood: $lnl
+
+AIC score: $aic / AICc score: $aicc / BIC score: $aic
+EOF
+fi
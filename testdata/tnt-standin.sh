@@ -0,0 +1,41 @@
+#!/bin/sh
+# Stands in for TNT when trying the tnt backend without it:
+#
+#	lv1-netest run -a testdata/cycle.nex -d cycle --backend tnt --tnt testdata/tnt-standin.sh
+#
+# It runs no search. For the run script given as "proc FILE;" it writes canned
+# output in TNT's formats: a ladder of the matrix taxa as the only tree, of
+# the star tree length the script quotes.
+set -eu
+script=
+while [ $# -gt 0 ]; do
+	if [ "$1" = proc ]; then
+		script=${2%;}
+	fi
+	shift
+done
+[ -n "$script" ] || { echo "usage: $0 [ARGS] proc SCRIPT;" >&2; exit 1; }
+value() { sed -n "s/^$1 \*\{0,1\}\([^;]*\);.*/\1/p" "$script" | head -n 1; }
+matrix=$(value proc)
+trees=$(value tsave)
+lengths=$(value log)
+steps=$(value quote)
+ladder=$(awk 'NR > 3 && NF == 2 { taxa[n++] = $1 } $0 == ";" { exit }
+	END { t = taxa[n-1]; for (k = n - 2; k >= 0; k--) t = "(" taxa[k] " " t ")"; print t }' "$matrix")
+
+{
+	echo "tread 'canned tree of $matrix'"
+	echo "$ladder;"
+	echo "proc-;"
+} >"$trees"
+
+length=${steps##* }
+{
+	echo "$steps"
+	echo
+	echo "Tree lengths"
+	echo
+	echo "          0"
+	echo "    0    $length"
+	echo
+} >"$lengths"
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/network"
+)
+
+func newValidateCommand() *cobra.Command {
+	var summary bool
+	cmd := &cobra.Command{
+		Use:   "validate NETWORK...",
+		Short: "Report the level and classes of extended Newick networks",
+		Long: `Reads the networks of each file, in extended Newick with reticulations
+labelled #H1, #LGT2 and so on, and reports their blobs (biconnected components)
+and level, and whether they are tree-child, tree-based, normal, galled trees
+and level-1. finish checks its network the same way.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runE(func(cmd *cobra.Command, args []string) error {
+			for _, file := range args {
+				networks, err := network.ReadNetworks(file)
+				if err != nil {
+					return err
+				}
+				for k, n := range networks {
+					name := file
+					if len(networks) > 1 {
+						name = fmt.Sprintf("%s network %d", file, k+1)
+					}
+					report := network.Validate(n)
+					if summary {
+						fmt.Printf("%s: %s\n", name, report.Summary())
+					} else {
+						fmt.Printf("%s:\n%s", name, report)
+					}
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVarP(&summary, "summary", "s", false, "print one line per network")
+	return cmd
+}
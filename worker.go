@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"lv1-netest/subproblem"
+)
+
+// Takes runs from a coordinator (see Coordinator) until all are done.
+type Worker struct {
+	URL     string
+	Name    string
+	Backend subproblem.SearchBackend
+	WorkDir string // runs are done in temporary directories under this one
+	Poll    time.Duration
+	Retries int // attempts for each request to the coordinator
+	// stop after this many runs failed in a row, as the worker is probably misconfigured
+	MaxFailures int
+	failures    int
+	client      *http.Client
+}
+
+var errAllDone = errors.New("all runs are done")
+
+// status codes that are answers rather than failures
+var workerExpected = []int{http.StatusOK, http.StatusNoContent, http.StatusGone, http.StatusConflict}
+
+// Sends a request to the coordinator, retrying with backoff on network and
+// server errors. Returns the status code; the body is decoded into out on 200.
+func (w *Worker) call(path string, in, out any) (int, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		code, err := w.post(path, body, out)
+		if err == nil {
+			return code, nil
+		}
+		if attempt >= w.Retries {
+			return code, fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s, retrying in %s\n", path, err, wait)
+		time.Sleep(wait)
+		wait = min(2*wait, time.Minute)
+	}
+}
+
+func (w *Worker) post(path string, body []byte, out any) (int, error) {
+	resp, err := w.client.Post(strings.TrimSuffix(w.URL, "/")+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	known := false
+	for _, code := range workerExpected {
+		known = known || code == resp.StatusCode
+	}
+	if !known {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	if resp.StatusCode == http.StatusOK && out != nil {
+		return resp.StatusCode, json.Unmarshal(content, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// Runs leased jobs until the coordinator has none left.
+func (w *Worker) Run() error {
+	if w.client == nil {
+		w.client = &http.Client{Timeout: time.Minute}
+	}
+	for {
+		err := w.runOne()
+		if errors.Is(err, errAllDone) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Worker) runOne() error {
+	var lease Lease
+	code, err := w.call("/lease", map[string]string{"worker": w.Name}, &lease)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case http.StatusGone:
+		return errAllDone
+	case http.StatusNoContent:
+		time.Sleep(w.Poll)
+		return nil
+	}
+	fmt.Printf("running %s\n", lease.ID)
+	jobPath := "/jobs/" + lease.ID
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.renew(ctx, cancel, jobPath, lease)
+	result, runErr := w.solve(ctx, lease)
+	if ctx.Err() != nil && runErr != nil {
+		fmt.Fprintf(os.Stderr, "lost lease on %s, abandoning it\n", lease.ID)
+		return nil
+	}
+	cancel()
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", lease.ID, runErr)
+		if _, err := w.call(jobPath+"/fail", map[string]string{"token": lease.Token, "error": runErr.Error()}, nil); err != nil {
+			return err
+		}
+		if w.failures++; w.MaxFailures > 0 && w.failures >= w.MaxFailures {
+			return fmt.Errorf("%d runs failed in a row, stopping", w.failures)
+		}
+		return nil
+	}
+	w.failures = 0
+	var answer map[string]string
+	if code, err := w.call(jobPath+"/result", result, &answer); err != nil {
+		return err
+	} else if code == http.StatusConflict { // the run was leased again or given up on
+		fmt.Printf("%s: result not taken, the lease was lost\n", lease.ID)
+		return nil
+	} else if code != http.StatusOK {
+		return fmt.Errorf("coordinator refused result for %s", lease.ID)
+	}
+	fmt.Printf("%s %s\n", lease.ID, answer["status"])
+	return nil
+}
+
+// Renews the lease at a third of its duration until ctx is done, cancelling
+// the run if the coordinator reports the lease lost.
+func (w *Worker) renew(ctx context.Context, cancel context.CancelFunc, jobPath string, lease Lease) {
+	for {
+		wait := max(time.Until(lease.Expires)/3, time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		var renewed Lease
+		code, err := w.call(jobPath+"/renew", map[string]string{"token": lease.Token}, &renewed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if code == http.StatusConflict {
+			cancel()
+			return
+		}
+		lease.Expires = renewed.Expires
+	}
+}
+
+func (w *Worker) solve(ctx context.Context, lease Lease) (*JobResult, error) {
+	inputs := w.Backend.Inputs(lease.ID)
+	missing := slices.ContainsFunc(inputs, func(name string) bool {
+		_, sent := lease.Inputs[name]
+		return !sent
+	})
+	if missing || len(inputs) != len(lease.Inputs) {
+		return nil, fmt.Errorf("the %s backend cannot solve runs set up for the %s backend", w.Backend.Name(), lease.Backend)
+	}
+	dir, err := os.MkdirTemp(w.WorkDir, lease.ID+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range inputs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(lease.Inputs[name]), 0644); err != nil {
+			return nil, err
+		}
+	}
+	criterion, err := subproblem.ParseCriterion(lease.Criterion)
+	if err == nil || lease.Criterion == "" { // PAUP* needs no criterion, as it is in its input
+		err = subproblem.CheckCriterion(w.Backend, criterion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("coordinator did not send a usable criterion: %w", err)
+	}
+	sub := subproblem.Subproblem{Polytomy: lease.Polytomy, PolytomyID: lease.PolytomyID, Candidate: lease.Candidate, Removed: lease.Removed, Taxa: lease.Taxa,
+		Stem: lease.ID, Seed: lease.Seed, Criterion: criterion}
+	if err := w.Backend.Solve(ctx, dir, sub); err != nil {
+		return nil, err
+	}
+	result := &JobResult{Token: lease.Token, Outputs: make(map[string]string)}
+	for _, name := range w.Backend.Outputs(lease.ID) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("no %s written: %w", name, err)
+		}
+		result.Outputs[name] = string(content)
+	}
+	return result, nil
+}
+
+func newWorkerCommand() *cobra.Command {
+	hostname, _ := os.Hostname()
+	w := &Worker{}
+	var b backendFlags
+	cmd := &cobra.Command{
+		Use:   "worker --url URL",
+		Short: "Solve subproblem runs handed out by a serve process",
+		Args:  cobra.NoArgs,
+		RunE: runE(func(cmd *cobra.Command, _ []string) error {
+			var err error
+			if w.Backend, err = b.backend(); err != nil {
+				return err
+			}
+			return w.Run()
+		}),
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&w.URL, "url", "http://localhost:8080", "coordinator address")
+	flags.StringVar(&w.Name, "name", fmt.Sprintf("%s-%d", hostname, os.Getpid()), "worker name reported to the coordinator")
+	b.add(cmd, "paup", true)
+	flags.StringVar(&w.WorkDir, "workdir", "", "directory for temporary run directories (default: system temporary directory)")
+	flags.DurationVar(&w.Poll, "poll", 10*time.Second, "wait this long before asking again when every run is leased")
+	flags.IntVar(&w.Retries, "retries", 5, "attempts for each request to the coordinator")
+	flags.IntVar(&w.MaxFailures, "max-failures", 3, "stop after this many runs failed in a row (0: never)")
+	cmd.MarkFlagDirname("workdir")
+	return cmd
+}